@@ -3,15 +3,28 @@ package main
 import (
 	"context"
 	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/accounts"
 	"github.com/Fuonder/goptherstore.git/internal/accrualservice"
+	"github.com/Fuonder/goptherstore.git/internal/auth"
 	"github.com/Fuonder/goptherstore.git/internal/connection/postrge"
 	"github.com/Fuonder/goptherstore.git/internal/dbservices"
+	"github.com/Fuonder/goptherstore.git/internal/events"
 	"github.com/Fuonder/goptherstore.git/internal/httpserver"
 	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/mfa"
 	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/orders"
+	"github.com/Fuonder/goptherstore.git/internal/passwords"
+	"github.com/Fuonder/goptherstore.git/internal/referrals"
+	"github.com/Fuonder/goptherstore.git/internal/throttling"
+	"github.com/Fuonder/goptherstore.git/internal/tiers"
+	"github.com/Fuonder/goptherstore.git/internal/wallets"
+	"github.com/Fuonder/goptherstore.git/internal/webhooks"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 	"log"
+	"strings"
+	"time"
 )
 
 func main() {
@@ -50,11 +63,102 @@ func run() error {
 		return err
 	}
 
-	DBServices, err := dbservices.NewDatabaseServices(jobsCh, []byte(CliOptions.Key), instance, mu)
+	DBServices, err := dbservices.NewDatabaseServices(jobsCh, []byte(CliOptions.Key), instance, mu, dbservices.Config{
+		Throttle: throttling.Config{
+			LoginMaxAttempts: CliOptions.LoginMaxAttempts,
+			IPMaxAttempts:    CliOptions.IPMaxAttempts,
+			BaseLockout:      CliOptions.LockoutBase,
+			MaxLockout:       CliOptions.LockoutMax,
+			ResetAfter:       CliOptions.LockoutReset,
+			Persist:          CliOptions.ThrottlePersist,
+		},
+		Policy: auth.PolicyConfig{
+			PasswordMinLength:   CliOptions.PasswordMinLength,
+			PasswordMinClasses:  CliOptions.PasswordMinClasses,
+			BannedPasswordsFile: CliOptions.BannedPasswordsFile,
+			LoginMinLength:      CliOptions.LoginMinLength,
+			LoginMaxLength:      CliOptions.LoginMaxLength,
+			LoginPattern:        CliOptions.LoginPattern,
+		},
+		Hashing: passwords.Config{
+			Algorithm:     CliOptions.PasswordHasher,
+			BcryptCost:    CliOptions.BcryptCost,
+			Argon2Memory:  uint32(CliOptions.Argon2Memory),
+			Argon2Time:    uint32(CliOptions.Argon2Time),
+			Argon2Threads: uint8(CliOptions.Argon2Threads),
+		},
+		MFA: mfa.Config{
+			Issuer:            CliOptions.MFAIssuer,
+			WithdrawThreshold: float32(CliOptions.MFAWithdrawThreshold),
+		},
+		Accounts: accounts.Config{
+			GracePeriod:   CliOptions.DeletionGrace,
+			PurgeInterval: CliOptions.PurgeInterval,
+		},
+		Events: events.Config{
+			Notify:  CliOptions.EventsNotify,
+			DSN:     CliOptions.DatabaseDSN,
+			Channel: events.DefaultChannel,
+		},
+		Webhooks: webhooks.Config{
+			MaxAttempts:  CliOptions.WebhookMaxAttempts,
+			BaseBackoff:  10 * time.Second,
+			MaxBackoff:   time.Hour,
+			Timeout:      CliOptions.WebhookTimeout,
+			PollInterval: 2 * time.Second,
+			BatchSize:    20,
+		},
+		Orders: orders.ValidatorConfig{
+			MinLength: CliOptions.OrderMinLength,
+			MaxLength: CliOptions.OrderMaxLength,
+			Formats:   CliOptions.OrderFormats,
+		},
+		Wallets: wallets.Config{
+			AutoComplete:        CliOptions.WithdrawAutoComplete,
+			MaxAmount:           float32(CliOptions.WithdrawMax),
+			Precision:           CliOptions.WithdrawPrecision,
+			UniqueOrder:         CliOptions.WithdrawUniqueOrder,
+			ExpiryWarning:       CliOptions.ExpiryWarning,
+			ExpiryInterval:      CliOptions.ExpiryInterval,
+			PointsTTL:           CliOptions.PointsTTL,
+			TransferDailyAmount: float32(CliOptions.TransferDailyAmount),
+			TransferDailyCount:  CliOptions.TransferDailyCount,
+			HoldDefaultTTL:      CliOptions.HoldDefaultTTL,
+			HoldMaxTTL:          CliOptions.HoldMaxTTL,
+			HoldSweepInterval:   CliOptions.HoldSweepInterval,
+		},
+		Points: orders.Config{
+			PointsTTL:       CliOptions.PointsTTL,
+			RequeueInterval: CliOptions.OrderRequeueInterval,
+		},
+		Tiers: tiers.Config{
+			Tiers:            CliOptions.Tiers,
+			Basis:            CliOptions.TierBasis,
+			Window:           CliOptions.TierWindow,
+			EvaluateInterval: CliOptions.TierInterval,
+		},
+		Referrals: referrals.Config{
+			ReferrerBonus:  float32(CliOptions.ReferrerBonus),
+			RefereeBonus:   float32(CliOptions.RefereeBonus),
+			MaxPerReferrer: CliOptions.ReferralCap,
+			PointsTTL:      CliOptions.PointsTTL,
+		},
+	})
 	if err != nil {
 		return err
 	}
 
+	for _, login := range strings.Split(CliOptions.Admins, ",") {
+		login = strings.TrimSpace(login)
+		if login == "" {
+			continue
+		}
+		err = DBServices.AdminSrv.SetRoleByLogin(ctx, login, models.RoleAdmin)
+		if err != nil {
+			logger.Log.Warn("can not grant admin role", zap.String("login", login), zap.Error(err))
+		}
+	}
+
 	//storage := postrge.NewPsqlStorage(ctx, DBConn, []byte(CliOptions.Key), jobsCh)
 
 	service, err := httpserver.NewService(CliOptions.APIAddress.String(), DBServices)
@@ -80,6 +184,36 @@ func run() error {
 		return nil
 	})
 
+	g.Go(func() error {
+		return DBServices.AccSrv.RunPurger(ctx)
+	})
+
+	g.Go(func() error {
+		return DBServices.OrderSrv.RunRequeue(ctx)
+	})
+
+	g.Go(func() error {
+		return DBServices.WebhookSrv.RunDispatcher(ctx)
+	})
+
+	g.Go(func() error {
+		return DBServices.WalletSrv.RunExpiry(ctx)
+	})
+
+	g.Go(func() error {
+		return DBServices.WalletSrv.RunHoldSweeper(ctx)
+	})
+
+	g.Go(func() error {
+		return DBServices.TierSrv.RunEvaluator(ctx)
+	})
+
+	if DBServices.EventRelay != nil {
+		g.Go(func() error {
+			return DBServices.EventRelay.Run(ctx)
+		})
+	}
+
 	if err := g.Wait(); err != nil {
 		logger.Log.Debug("exit with error", zap.Error(err))
 		cancel()
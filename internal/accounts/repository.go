@@ -0,0 +1,208 @@
+package accounts
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"sync"
+	"time"
+)
+
+const (
+	GetAdjustmentsByUID = `
+						SELECT id, user_id, COALESCE(admin_id, 0), amount, reason, created_at 
+						FROM balance_adjustments 
+						WHERE user_id = $1 
+						ORDER BY created_at;`
+	ScheduleDeletionQuery = `UPDATE users SET deletion_scheduled_at = $1 WHERE id = $2 AND deleted_at IS NULL;`
+	CancelDeletionQuery   = `
+						UPDATE users SET deletion_scheduled_at = NULL 
+						WHERE id = $1 AND deletion_scheduled_at IS NOT NULL AND deleted_at IS NULL;`
+	GetDeletionScheduleQuery = `SELECT deletion_scheduled_at FROM users WHERE id = $1;`
+	GetDueDeletionsQuery     = `
+						SELECT id FROM users 
+						WHERE deletion_scheduled_at <= $1 AND deleted_at IS NULL;`
+	GetLoginForUpdateQuery = `SELECT login FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE;`
+	AnonymizeUserQuery     = `
+						UPDATE users 
+						SET login = $1, password_hash = '', role = 'user', display_name = NULL, email = NULL, 
+						    deletion_scheduled_at = NULL, deleted_at = $2 
+						WHERE id = $3;`
+	DeleteUserSessionsQuery = `DELETE FROM sessions WHERE user_id = $1;`
+	DeleteUserKeysQuery     = `DELETE FROM api_keys WHERE user_id = $1;`
+	DeleteUserTOTPQuery     = `DELETE FROM user_totp WHERE user_id = $1;`
+	DeleteUserCodesQuery    = `DELETE FROM totp_recovery_codes WHERE user_id = $1;`
+	DeleteUserWebhooksQuery = `DELETE FROM webhooks WHERE user_id = $1;`
+	DeleteUserReferralQuery = `DELETE FROM referral_codes WHERE user_id = $1;`
+	ClearSignupIPQuery      = `UPDATE referrals SET signup_ip = '' WHERE referee_id = $1;`
+	DeleteUserThrottleQuery = `DELETE FROM login_throttle WHERE key = $1;`
+	DeleteUserLockoutsQuery = `DELETE FROM login_lockouts WHERE key = $1;`
+)
+
+type DatabaseAccounts interface {
+	GetAdjustments(ctx context.Context, UID int) ([]models.BalanceAdjustment, error)
+	ScheduleDeletion(ctx context.Context, UID int, at time.Time) error
+	CancelDeletion(ctx context.Context, UID int) error
+	GetDeletionSchedule(ctx context.Context, UID int) (time.Time, error)
+	GetDueDeletions(ctx context.Context, now time.Time) ([]int, error)
+	Anonymize(ctx context.Context, UID int, placeholder string, at time.Time) error
+}
+
+type DBAccounts struct {
+	db *sql.DB
+	mu *sync.RWMutex
+}
+
+func NewDBAccounts(db *sql.DB, mu *sync.RWMutex) (*DBAccounts, error) {
+	return &DBAccounts{db: db, mu: mu}, nil
+}
+
+func (a *DBAccounts) GetAdjustments(ctx context.Context, UID int) ([]models.BalanceAdjustment, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	rows, err := a.db.QueryContext(ctx, GetAdjustmentsByUID, UID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query adjustments: %v", err)
+	}
+	defer rows.Close()
+
+	adjustments := make([]models.BalanceAdjustment, 0)
+	for rows.Next() {
+		var adj models.BalanceAdjustment
+		err = rows.Scan(&adj.ID, &adj.UserID, &adj.AdminID, &adj.Amount, &adj.Reason, &adj.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		adjustments = append(adjustments, adj)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %v", err)
+	}
+	return adjustments, nil
+}
+
+func (a *DBAccounts) ScheduleDeletion(ctx context.Context, UID int, at time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	res, err := a.db.ExecContext(ctx, ScheduleDeletionQuery, at, UID)
+	if err != nil {
+		return fmt.Errorf("failed to schedule deletion: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return models.ErrUserNotFound
+	}
+	return nil
+}
+
+func (a *DBAccounts) CancelDeletion(ctx context.Context, UID int) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	res, err := a.db.ExecContext(ctx, CancelDeletionQuery, UID)
+	if err != nil {
+		return fmt.Errorf("failed to cancel deletion: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return models.ErrDeletionNotScheduled
+	}
+	return nil
+}
+
+func (a *DBAccounts) GetDeletionSchedule(ctx context.Context, UID int) (time.Time, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	var at sql.NullTime
+	err := a.db.QueryRowContext(ctx, GetDeletionScheduleQuery, UID).Scan(&at)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, models.ErrUserNotFound
+		}
+		return time.Time{}, err
+	}
+	if !at.Valid {
+		return time.Time{}, models.ErrDeletionNotScheduled
+	}
+	return at.Time, nil
+}
+
+func (a *DBAccounts) GetDueDeletions(ctx context.Context, now time.Time) ([]int, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	rows, err := a.db.QueryContext(ctx, GetDueDeletionsQuery, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query due deletions: %v", err)
+	}
+	defer rows.Close()
+
+	UIDs := make([]int, 0)
+	for rows.Next() {
+		var UID int
+		if err := rows.Scan(&UID); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		UIDs = append(UIDs, UID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %v", err)
+	}
+	return UIDs, nil
+}
+
+// Anonymize strips everything identifying from the account and drops its
+// credentials. Orders, withdrawals and adjustments stay attached to the
+// anonymized row, the cascade on users is never triggered. The referral
+// code goes away and the signup IP is cleared, the referral itself is kept
+// for the referrer's history.
+func (a *DBAccounts) Anonymize(ctx context.Context, UID int, placeholder string, at time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var login string
+	err = tx.QueryRowContext(ctx, GetLoginForUpdateQuery, UID).Scan(&login)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrUserNotFound
+		}
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, AnonymizeUserQuery, placeholder, at, UID)
+	if err != nil {
+		return fmt.Errorf("failed to anonymize user: %w", err)
+	}
+	for _, query := range []string{
+		DeleteUserSessionsQuery,
+		DeleteUserKeysQuery,
+		DeleteUserTOTPQuery,
+		DeleteUserCodesQuery,
+		DeleteUserWebhooksQuery,
+		DeleteUserReferralQuery,
+		ClearSignupIPQuery,
+	} {
+		_, err = tx.ExecContext(ctx, query, UID)
+		if err != nil {
+			return err
+		}
+	}
+	for _, query := range []string{DeleteUserThrottleQuery, DeleteUserLockoutsQuery} {
+		_, err = tx.ExecContext(ctx, query, "login:"+login)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,86 @@
+package accounts
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/apikeys"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/testdb"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDBAnonymizeDropsReferralData(t *testing.T) {
+	db := testdb.Open(t)
+	a, err := NewDBAccounts(db, &sync.RWMutex{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	referrer, _ := testdb.CreateUser(t, db)
+	UID, login := testdb.CreateUser(t, db)
+
+	_, err = db.ExecContext(ctx, `INSERT INTO referral_codes (user_id, code) VALUES ($1, $2);`, UID, "R"+login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO referrals (referrer_id, referee_id, signup_ip, status) 
+		VALUES ($1, $2, '203.0.113.7', 'pending');`, referrer, UID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = a.Anonymize(ctx, UID, "deleted-"+login, time.Now()); err != nil {
+		t.Fatalf("Anonymize: %v", err)
+	}
+
+	var code string
+	err = db.QueryRowContext(ctx, `SELECT code FROM referral_codes WHERE user_id = $1;`, UID).Scan(&code)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("referral code still there: %q, %v", code, err)
+	}
+	var ip string
+	err = db.QueryRowContext(ctx, `SELECT signup_ip FROM referrals WHERE referee_id = $1;`, UID).Scan(&ip)
+	if err != nil {
+		t.Fatalf("referral row is gone: %v", err)
+	}
+	if ip != "" {
+		t.Errorf("signup_ip = %q, want it cleared", ip)
+	}
+}
+
+func TestDBScheduledDeletionBlocksAPIKeys(t *testing.T) {
+	db := testdb.Open(t)
+	mu := &sync.RWMutex{}
+	a, err := NewDBAccounts(db, mu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := apikeys.NewDBAPIKeys(db, mu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	UID, login := testdb.CreateUser(t, db)
+	prefix := "del" + login
+	_, err = k.CreateKey(ctx, models.APIKey{UserID: UID, Name: "ci", Prefix: prefix, Hash: "hash", Scopes: []string{"read"}, CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+
+	if err = a.ScheduleDeletion(ctx, UID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("ScheduleDeletion: %v", err)
+	}
+	if _, err = k.GetKeyByPrefix(ctx, prefix); !errors.Is(err, models.ErrInvalidAPIKey) {
+		t.Errorf("key during the grace period: error = %v, want ErrInvalidAPIKey", err)
+	}
+	if err = a.CancelDeletion(ctx, UID); err != nil {
+		t.Fatalf("CancelDeletion: %v", err)
+	}
+	if _, err = k.GetKeyByPrefix(ctx, prefix); err != nil {
+		t.Errorf("key after cancelling: %v", err)
+	}
+}
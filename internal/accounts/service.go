@@ -0,0 +1,15 @@
+package accounts
+
+import (
+	"context"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+)
+
+type AccountService interface {
+	Export(ctx context.Context, UID int) (models.AccountExport, error)
+	RequestDeletion(ctx context.Context, UID int, password string, currentSession string, client models.ClientInfo) (models.DeletionSchedule, error)
+	GetDeletion(ctx context.Context, UID int) (models.DeletionSchedule, error)
+	CancelDeletion(ctx context.Context, UID int) error
+	PurgeDue(ctx context.Context) (int, error)
+	RunPurger(ctx context.Context) error
+}
@@ -0,0 +1,183 @@
+package accounts
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/auth"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/orders"
+	"github.com/Fuonder/goptherstore.git/internal/sessions"
+	"github.com/Fuonder/goptherstore.git/internal/users"
+	"github.com/Fuonder/goptherstore.git/internal/wallets"
+	"go.uber.org/zap"
+	"time"
+)
+
+type Config struct {
+	GracePeriod   time.Duration
+	PurgeInterval time.Duration
+}
+
+type ACService struct {
+	conn     DatabaseAccounts
+	uConn    users.DatabaseUsers
+	wConn    wallets.DatabaseWallets
+	oConn    orders.DatabaseOrders
+	authSrv  auth.AuthService
+	sessions sessions.SessionService
+	cfg      Config
+}
+
+func NewACService(conn DatabaseAccounts, uConn users.DatabaseUsers, wConn wallets.DatabaseWallets, oConn orders.DatabaseOrders, authSrv auth.AuthService, sessions sessions.SessionService, cfg Config) *ACService {
+	return &ACService{
+		conn:     conn,
+		uConn:    uConn,
+		wConn:    wConn,
+		oConn:    oConn,
+		authSrv:  authSrv,
+		sessions: sessions,
+		cfg:      cfg,
+	}
+}
+
+func (s *ACService) Export(ctx context.Context, UID int) (models.AccountExport, error) {
+	profile, err := s.uConn.GetProfile(ctx, UID)
+	if err != nil {
+		return models.AccountExport{}, err
+	}
+	profile.Stats, err = s.uConn.GetProfileStats(ctx, UID)
+	if err != nil {
+		return models.AccountExport{}, err
+	}
+	wallet, err := s.wConn.GetUserWallet(ctx, UID)
+	if err != nil {
+		return models.AccountExport{}, err
+	}
+
+	export := models.AccountExport{
+		Profile:     profile,
+		Wallet:      wallet,
+		Orders:      make([]models.MartOrder, 0),
+		Withdrawals: make([]models.Withdrawal, 0),
+		ExportedAt:  time.Now(),
+	}
+
+	userOrders, err := s.oConn.GetUserOrders(ctx, UID)
+	if err != nil && !errors.Is(err, models.ErrNoData) {
+		return models.AccountExport{}, err
+	}
+	if err == nil {
+		export.Orders = userOrders
+	}
+	withdrawals, err := s.wConn.GetUserWithdrawals(ctx, UID)
+	if err != nil && !errors.Is(err, models.ErrNoData) {
+		return models.AccountExport{}, err
+	}
+	if err == nil {
+		export.Withdrawals = withdrawals
+	}
+	export.Ledger, err = s.conn.GetAdjustments(ctx, UID)
+	if err != nil {
+		return models.AccountExport{}, err
+	}
+	return export, nil
+}
+
+// RequestDeletion schedules the account for anonymization after the grace
+// period. Every session but the current one is signed out, so the owner
+// can still cancel from where they asked. API keys stop working until the
+// deletion is cancelled.
+func (s *ACService) RequestDeletion(ctx context.Context, UID int, password string, currentSession string, client models.ClientInfo) (models.DeletionSchedule, error) {
+	login, err := s.uConn.GetLoginByUID(ctx, UID)
+	if err != nil {
+		return models.DeletionSchedule{}, err
+	}
+	err = s.authSrv.VerifyPassword(ctx, login, password, client)
+	if err != nil {
+		return models.DeletionSchedule{}, err
+	}
+
+	at := time.Now().Add(s.cfg.GracePeriod)
+	err = s.conn.ScheduleDeletion(ctx, UID, at)
+	if err != nil {
+		return models.DeletionSchedule{}, err
+	}
+	err = s.sessions.RevokeAll(ctx, UID, currentSession)
+	if err != nil {
+		return models.DeletionSchedule{}, err
+	}
+	logger.Log.Info("account deletion scheduled", zap.Int("uid", UID), zap.Time("at", at))
+	return models.DeletionSchedule{ScheduledFor: at}, nil
+}
+
+func (s *ACService) GetDeletion(ctx context.Context, UID int) (models.DeletionSchedule, error) {
+	at, err := s.conn.GetDeletionSchedule(ctx, UID)
+	if err != nil {
+		return models.DeletionSchedule{}, err
+	}
+	return models.DeletionSchedule{ScheduledFor: at}, nil
+}
+
+func (s *ACService) CancelDeletion(ctx context.Context, UID int) error {
+	err := s.conn.CancelDeletion(ctx, UID)
+	if err != nil {
+		return err
+	}
+	logger.Log.Info("account deletion cancelled", zap.Int("uid", UID))
+	return nil
+}
+
+func (s *ACService) PurgeDue(ctx context.Context) (int, error) {
+	now := time.Now()
+	UIDs, err := s.conn.GetDueDeletions(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, UID := range UIDs {
+		placeholder, err := anonymousLogin()
+		if err != nil {
+			return purged, err
+		}
+		err = s.conn.Anonymize(ctx, UID, placeholder, now)
+		if err != nil {
+			logger.Log.Error("can not anonymize account", zap.Int("uid", UID), zap.Error(err))
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+func (s *ACService) RunPurger(ctx context.Context) error {
+	ticker := time.NewTicker(s.cfg.PurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			purged, err := s.PurgeDue(ctx)
+			if err != nil {
+				logger.Log.Error("account purge failed", zap.Error(err))
+				continue
+			}
+			if purged > 0 {
+				logger.Log.Info("accounts anonymized", zap.Int("count", purged))
+			}
+		}
+	}
+}
+
+// anonymousLogin keeps the unique login column satisfied without leaving
+// anything that points back to the former owner.
+func anonymousLogin() (string, error) {
+	raw := make([]byte, 12)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return "deleted-" + hex.EncodeToString(raw), nil
+}
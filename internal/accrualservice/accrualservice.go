@@ -111,6 +111,7 @@ func (b *BonusAPIService) GetAccrualStatus(order models.MartOrder) error {
 			return nil
 		} else {
 			logger.Log.Info("Status of response BAD", zap.Any("response", responseOrder.Status))
+			b.s.RecordPoll(ctx, order.OrderID, responseOrder.Status)
 			i = 0
 		}
 		if i < len(timeouts) {
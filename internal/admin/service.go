@@ -0,0 +1,19 @@
+package admin
+
+import (
+	"context"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+)
+
+type AdminService interface {
+	GetUser(ctx context.Context, UID int) (models.UserInfo, error)
+	FindUser(ctx context.Context, login string) (models.UserInfo, error)
+	GetUserOrders(ctx context.Context, UID int) ([]models.MartOrder, error)
+	GetUserWithdrawals(ctx context.Context, UID int) ([]models.Withdrawal, error)
+	RequeueOrder(ctx context.Context, orderNumber string) error
+	AdjustBalance(ctx context.Context, adjustment models.BalanceAdjustment) error
+	SetRole(ctx context.Context, UID int, role string) error
+	SetRoleByLogin(ctx context.Context, login string, role string) error
+	CreateServiceAccount(ctx context.Context, login string) (models.UserInfo, error)
+	CreateAPIKey(ctx context.Context, UID int, req models.APIKeyRequest) (models.APIKeyCreated, error)
+}
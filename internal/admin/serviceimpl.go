@@ -0,0 +1,162 @@
+package admin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/Fuonder/goptherstore.git/internal/apikeys"
+	"github.com/Fuonder/goptherstore.git/internal/events"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/orders"
+	"github.com/Fuonder/goptherstore.git/internal/users"
+	"github.com/Fuonder/goptherstore.git/internal/wallets"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+type AdmService struct {
+	uConn    users.DatabaseUsers
+	wConn    wallets.DatabaseWallets
+	orderSrv orders.OrderService
+	keySrv   apikeys.APIKeyService
+	events   events.Publisher
+}
+
+func NewAdmService(uConn users.DatabaseUsers, wConn wallets.DatabaseWallets, orderSrv orders.OrderService, keySrv apikeys.APIKeyService, events events.Publisher) *AdmService {
+	return &AdmService{uConn: uConn, wConn: wConn, orderSrv: orderSrv, keySrv: keySrv, events: events}
+}
+
+func (s *AdmService) GetUser(ctx context.Context, UID int) (models.UserInfo, error) {
+	user, err := s.uConn.GetUserByID(ctx, UID)
+	if err != nil {
+		return models.UserInfo{}, err
+	}
+	wallet, err := s.wConn.GetUserWallet(ctx, UID)
+	if err != nil {
+		return models.UserInfo{}, err
+	}
+	return models.UserInfo{
+		ID:        user.ID,
+		Login:     user.Login,
+		Role:      user.Role,
+		CreatedAt: user.CreatedAt,
+		Balance:   wallet.Balance,
+		Withdrawn: wallet.TotalWithdraw,
+	}, nil
+}
+
+func (s *AdmService) FindUser(ctx context.Context, login string) (models.UserInfo, error) {
+	state, err := s.uConn.GetAuthState(ctx, login)
+	if err != nil {
+		return models.UserInfo{}, err
+	}
+	return s.GetUser(ctx, state.UID)
+}
+
+func (s *AdmService) GetUserOrders(ctx context.Context, UID int) ([]models.MartOrder, error) {
+	return s.orderSrv.GetOrdersByUID(ctx, UID)
+}
+
+func (s *AdmService) GetUserWithdrawals(ctx context.Context, UID int) ([]models.Withdrawal, error) {
+	return s.wConn.GetUserWithdrawals(ctx, UID)
+}
+
+func (s *AdmService) RequeueOrder(ctx context.Context, orderNumber string) error {
+	err := s.orderSrv.RequeueOrder(ctx, orderNumber)
+	if err != nil {
+		return err
+	}
+	logger.Log.Info("order re-queued by admin", zap.String("order", orderNumber))
+	return nil
+}
+
+func (s *AdmService) AdjustBalance(ctx context.Context, adjustment models.BalanceAdjustment) error {
+	adjustment.Reason = strings.TrimSpace(adjustment.Reason)
+	if adjustment.Reason == "" {
+		return models.ErrReasonRequired
+	}
+	if adjustment.Amount == 0 {
+		return models.ErrInvalidAmount
+	}
+	adjustment.CreatedAt = time.Now()
+
+	err := s.wConn.Adjust(ctx, adjustment)
+	if err != nil {
+		return err
+	}
+	logger.Log.Info("balance adjusted by admin",
+		zap.Int("user", adjustment.UserID),
+		zap.Int("admin", adjustment.AdminID),
+		zap.Float32("amount", adjustment.Amount),
+		zap.String("reason", adjustment.Reason))
+	wallets.PublishBalance(ctx, s.wConn, s.events, adjustment.UserID, adjustment.Amount, "adjustment")
+	return nil
+}
+
+func (s *AdmService) SetRole(ctx context.Context, UID int, role string) error {
+	if !isKnownRole(role) {
+		return models.ErrInvalidRole
+	}
+	return s.uConn.SetRole(ctx, UID, role)
+}
+
+func (s *AdmService) SetRoleByLogin(ctx context.Context, login string, role string) error {
+	if !isKnownRole(role) {
+		return models.ErrInvalidRole
+	}
+	return s.uConn.SetRoleByLogin(ctx, login, role)
+}
+
+// CreateServiceAccount creates a user that can only authenticate with
+// API keys: its password is random and never revealed.
+func (s *AdmService) CreateServiceAccount(ctx context.Context, login string) (models.UserInfo, error) {
+	login = strings.TrimSpace(login)
+	if login == "" {
+		return models.UserInfo{}, models.ErrUserCreationFailed
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return models.UserInfo{}, err
+	}
+	account := models.MartUser{
+		Login:     login,
+		Password:  hex.EncodeToString(raw),
+		CreatedAt: time.Now(),
+	}
+
+	err := s.uConn.CheckLoginPresence(ctx, account)
+	if err != nil {
+		return models.UserInfo{}, err
+	}
+	err = s.uConn.CreateUser(ctx, account)
+	if err != nil {
+		return models.UserInfo{}, err
+	}
+	err = s.uConn.SetRoleByLogin(ctx, login, models.RoleService)
+	if err != nil {
+		return models.UserInfo{}, err
+	}
+	UID, err := s.uConn.GetUIDByUsername(ctx, login)
+	if err != nil {
+		return models.UserInfo{}, err
+	}
+	err = s.wConn.CreateUserWallet(ctx, UID)
+	if err != nil {
+		return models.UserInfo{}, err
+	}
+	return s.GetUser(ctx, UID)
+}
+
+func (s *AdmService) CreateAPIKey(ctx context.Context, UID int, req models.APIKeyRequest) (models.APIKeyCreated, error) {
+	_, err := s.uConn.GetUserByID(ctx, UID)
+	if err != nil {
+		return models.APIKeyCreated{}, err
+	}
+	return s.keySrv.CreateKey(ctx, UID, req)
+}
+
+func isKnownRole(role string) bool {
+	return role == models.RoleUser || role == models.RoleAdmin || role == models.RoleService
+}
@@ -0,0 +1,175 @@
+package apikeys
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	InsertAPIKeyQuery = `
+						INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at, created_at) 
+						VALUES ($1, $2, $3, $4, $5, $6, $7) 
+						RETURNING id;`
+	GetAPIKeysByUID = `
+						SELECT id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at 
+						FROM api_keys 
+						WHERE user_id = $1 
+						ORDER BY created_at DESC;`
+	// GetAPIKeyByPrefix skips the keys of accounts scheduled for deletion,
+	// machine clients stop with the sessions and come back on cancel.
+	GetAPIKeyByPrefix = `
+						SELECT k.id, k.user_id, k.name, k.prefix, k.key_hash, k.scopes, k.expires_at, k.last_used_at, k.revoked_at, k.created_at 
+						FROM api_keys k 
+						JOIN users u ON u.id = k.user_id 
+						WHERE k.prefix = $1 AND u.deletion_scheduled_at IS NULL;`
+	RevokeAPIKeyQuery = `UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL;`
+	TouchAPIKeyQuery  = `UPDATE api_keys SET last_used_at = $1 WHERE id = $2;`
+)
+
+type DatabaseAPIKeys interface {
+	CreateKey(ctx context.Context, key models.APIKey) (int, error)
+	GetUserKeys(ctx context.Context, UID int) ([]models.APIKey, error)
+	GetKeyByPrefix(ctx context.Context, prefix string) (models.APIKey, error)
+	RevokeKey(ctx context.Context, UID int, keyID int) error
+	TouchKey(ctx context.Context, keyID int, usedAt time.Time) error
+}
+
+type DBAPIKeys struct {
+	db *sql.DB
+	mu *sync.RWMutex
+}
+
+func NewDBAPIKeys(db *sql.DB, mu *sync.RWMutex) (*DBAPIKeys, error) {
+	return &DBAPIKeys{db: db, mu: mu}, nil
+}
+
+func (k *DBAPIKeys) CreateKey(ctx context.Context, key models.APIKey) (int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	var ID int
+	err := k.db.QueryRowContext(
+		ctx, InsertAPIKeyQuery,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		key.Hash,
+		strings.Join(key.Scopes, ","),
+		key.ExpiresAt,
+		key.CreatedAt,
+	).Scan(&ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create api key: %w", err)
+	}
+	return ID, nil
+}
+
+func (k *DBAPIKeys) GetUserKeys(ctx context.Context, UID int) ([]models.APIKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	rows, err := k.db.QueryContext(ctx, GetAPIKeysByUID, UID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api keys: %v", err)
+	}
+	defer rows.Close()
+	keys := make([]models.APIKey, 0)
+
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %v", err)
+	}
+	if len(keys) == 0 {
+		return nil, models.ErrNoData
+	}
+	return keys, nil
+}
+
+func (k *DBAPIKeys) GetKeyByPrefix(ctx context.Context, prefix string) (models.APIKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, err := scanKey(k.db.QueryRowContext(ctx, GetAPIKeyByPrefix, prefix))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.APIKey{}, models.ErrInvalidAPIKey
+		}
+		return models.APIKey{}, fmt.Errorf("failed to get api key: %w", err)
+	}
+	return key, nil
+}
+
+func (k *DBAPIKeys) RevokeKey(ctx context.Context, UID int, keyID int) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	res, err := k.db.ExecContext(ctx, RevokeAPIKeyQuery, time.Now(), keyID, UID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return models.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (k *DBAPIKeys) TouchKey(ctx context.Context, keyID int, usedAt time.Time) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	_, err := k.db.ExecContext(ctx, TouchAPIKeyQuery, usedAt, keyID)
+	if err != nil {
+		return fmt.Errorf("failed to update api key usage: %w", err)
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanKey(row rowScanner) (models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.Hash,
+		&scopes,
+		&expiresAt,
+		&lastUsedAt,
+		&revokedAt,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, ",")
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return key, nil
+}
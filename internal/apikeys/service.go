@@ -0,0 +1,13 @@
+package apikeys
+
+import (
+	"context"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+)
+
+type APIKeyService interface {
+	CreateKey(ctx context.Context, UID int, req models.APIKeyRequest) (models.APIKeyCreated, error)
+	GetKeys(ctx context.Context, UID int) ([]models.APIKey, error)
+	RevokeKey(ctx context.Context, UID int, keyID int) error
+	Authenticate(ctx context.Context, rawKey string) (models.Principal, error)
+}
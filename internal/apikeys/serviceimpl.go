@@ -0,0 +1,126 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"go.uber.org/zap"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Keys look like gm_<prefix>_<secret>. The prefix is stored in clear for
+// lookup, only a SHA-256 of the whole key is kept.
+const (
+	keyMarker        = "gm"
+	prefixBytes      = 5
+	secretBytes      = 20
+	touchGranularity = time.Minute
+)
+
+var keyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type KService struct {
+	conn DatabaseAPIKeys
+}
+
+func NewKService(conn DatabaseAPIKeys) *KService {
+	return &KService{conn: conn}
+}
+
+func (s *KService) CreateKey(ctx context.Context, UID int, req models.APIKeyRequest) (models.APIKeyCreated, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return models.APIKeyCreated{}, fmt.Errorf("%w: name is required", models.ErrInvalidAPIKeyRequest)
+	}
+	if len(req.Scopes) == 0 {
+		return models.APIKeyCreated{}, fmt.Errorf("%w: at least one scope is required", models.ErrInvalidScope)
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(models.KnownScopes, scope) {
+			return models.APIKeyCreated{}, fmt.Errorf("%w: %q", models.ErrInvalidScope, scope)
+		}
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		return models.APIKeyCreated{}, fmt.Errorf("%w: expiry is in the past", models.ErrInvalidAPIKeyRequest)
+	}
+
+	prefix, err := randomToken(prefixBytes)
+	if err != nil {
+		return models.APIKeyCreated{}, err
+	}
+	secret, err := randomToken(secretBytes)
+	if err != nil {
+		return models.APIKeyCreated{}, err
+	}
+	rawKey := keyMarker + "_" + prefix + "_" + secret
+
+	key := models.APIKey{
+		UserID:    UID,
+		Name:      req.Name,
+		Prefix:    prefix,
+		Hash:      hashKey(rawKey),
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+		CreatedAt: time.Now(),
+	}
+	key.ID, err = s.conn.CreateKey(ctx, key)
+	if err != nil {
+		return models.APIKeyCreated{}, err
+	}
+	return models.APIKeyCreated{APIKey: key, Key: rawKey}, nil
+}
+
+func (s *KService) GetKeys(ctx context.Context, UID int) ([]models.APIKey, error) {
+	return s.conn.GetUserKeys(ctx, UID)
+}
+
+func (s *KService) RevokeKey(ctx context.Context, UID int, keyID int) error {
+	return s.conn.RevokeKey(ctx, UID, keyID)
+}
+
+func (s *KService) Authenticate(ctx context.Context, rawKey string) (models.Principal, error) {
+	parts := strings.Split(rawKey, "_")
+	if len(parts) != 3 || parts[0] != keyMarker {
+		return models.Principal{}, models.ErrInvalidAPIKey
+	}
+	key, err := s.conn.GetKeyByPrefix(ctx, parts[1])
+	if err != nil {
+		return models.Principal{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashKey(rawKey)), []byte(key.Hash)) != 1 {
+		return models.Principal{}, models.ErrInvalidAPIKey
+	}
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && now.After(*key.ExpiresAt)) {
+		return models.Principal{}, models.ErrInvalidAPIKey
+	}
+
+	// Recording every single request would turn reads into writes.
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > touchGranularity {
+		if err := s.conn.TouchKey(ctx, key.ID, now); err != nil {
+			logger.Log.Warn("can not record api key usage", zap.Int("key", key.ID), zap.Error(err))
+		}
+	}
+	return models.Principal{UID: key.UserID, APIKeyID: key.ID, Scopes: key.Scopes}, nil
+}
+
+func randomToken(size int) (string, error) {
+	raw := make([]byte, size)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return strings.ToLower(keyEncoding.EncodeToString(raw)), nil
+}
+
+func hashKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
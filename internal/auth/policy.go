@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var defaultBannedPasswords = []string{
+	"password", "password1", "password123", "passw0rd",
+	"12345678", "123456789", "1234567890", "87654321",
+	"11111111", "00000000", "qwerty123", "qwertyuiop",
+	"1q2w3e4r", "1qaz2wsx", "abc12345", "iloveyou",
+	"letmein1", "welcome1", "admin123", "gophermart",
+}
+
+type PolicyConfig struct {
+	PasswordMinLength   int
+	PasswordMinClasses  int
+	BannedPasswordsFile string
+	LoginMinLength      int
+	LoginMaxLength      int
+	LoginPattern        string
+}
+
+// Policy checks logins and passwords before they are stored.
+type Policy struct {
+	cfg     PolicyConfig
+	loginRe *regexp.Regexp
+	banned  map[string]struct{}
+}
+
+func NewPolicy(cfg PolicyConfig) (*Policy, error) {
+	p := &Policy{cfg: cfg, banned: make(map[string]struct{})}
+
+	if cfg.LoginPattern != "" {
+		re, err := regexp.Compile(cfg.LoginPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid login pattern: %w", err)
+		}
+		p.loginRe = re
+	}
+
+	for _, pwd := range defaultBannedPasswords {
+		p.banned[pwd] = struct{}{}
+	}
+	if cfg.BannedPasswordsFile != "" {
+		err := p.loadBanned(cfg.BannedPasswordsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *Policy) loadBanned(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("can not open banned passwords file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.banned[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("can not read banned passwords file: %w", err)
+	}
+	return nil
+}
+
+func (p *Policy) ValidateLogin(login string) error {
+	length := utf8.RuneCountInString(login)
+	if length < p.cfg.LoginMinLength {
+		return fmt.Errorf("%w: login must be at least %d characters", models.ErrPolicyViolation, p.cfg.LoginMinLength)
+	}
+	if p.cfg.LoginMaxLength > 0 && length > p.cfg.LoginMaxLength {
+		return fmt.Errorf("%w: login must be at most %d characters", models.ErrPolicyViolation, p.cfg.LoginMaxLength)
+	}
+	if p.loginRe != nil && !p.loginRe.MatchString(login) {
+		return fmt.Errorf("%w: login contains forbidden characters", models.ErrPolicyViolation)
+	}
+	return nil
+}
+
+func (p *Policy) ValidatePassword(login string, password string) error {
+	if password == "" || utf8.RuneCountInString(password) < p.cfg.PasswordMinLength {
+		return fmt.Errorf("%w: password must be at least %d characters", models.ErrPolicyViolation, p.cfg.PasswordMinLength)
+	}
+	if classes := characterClasses(password); classes < p.cfg.PasswordMinClasses {
+		return fmt.Errorf("%w: password must contain at least %d of: lowercase, uppercase, digits, symbols",
+			models.ErrPolicyViolation, p.cfg.PasswordMinClasses)
+	}
+	lower := strings.ToLower(password)
+	if _, ok := p.banned[lower]; ok {
+		return fmt.Errorf("%w: password is too common", models.ErrPolicyViolation)
+	}
+	if login != "" && lower == strings.ToLower(login) {
+		return fmt.Errorf("%w: password must differ from login", models.ErrPolicyViolation)
+	}
+	return nil
+}
+
+func characterClasses(s string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	count := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			count++
+		}
+	}
+	return count
+}
@@ -4,13 +4,16 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
 	"github.com/Fuonder/goptherstore.git/internal/models"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/Fuonder/goptherstore.git/internal/passwords"
+	"go.uber.org/zap"
 	"sync"
 )
 
 const (
-	GetUserPasswordQuery = `SELECT password_hash FROM users WHERE login = $1;`
+	GetUserPasswordQuery    = `SELECT password_hash FROM users WHERE login = $1 AND deleted_at IS NULL;`
+	UpdateUserPasswordQuery = `UPDATE users SET password_hash = $1 WHERE login = $2 AND password_hash = $3;`
 )
 
 type DatabaseAuth interface {
@@ -18,29 +21,67 @@ type DatabaseAuth interface {
 }
 
 type DBAuth struct {
-	db *sql.DB
-	mu *sync.RWMutex
+	db     *sql.DB
+	mu     *sync.RWMutex
+	hasher passwords.PasswordHasher
 }
 
-func NewDBAuth(db *sql.DB, mu *sync.RWMutex) (*DBAuth, error) {
-	return &DBAuth{db: db, mu: mu}, nil
+func NewDBAuth(db *sql.DB, mu *sync.RWMutex, hasher passwords.PasswordHasher) (*DBAuth, error) {
+	return &DBAuth{db: db, mu: mu, hasher: hasher}, nil
 }
 
 func (a *DBAuth) ValidateUserCredentials(ctx context.Context, user models.MartUser) error {
+	hashPassword, err := a.getPasswordHash(ctx, user.Login)
+	if err != nil {
+		return err
+	}
+
+	ok, err := a.hasher.Verify(hashPassword, user.Password)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return models.ErrWrongCredentials
+	}
+
+	// The plain password is only available here, so outdated hashes
+	// are upgraded on the fly. Failing to do so must not fail the login.
+	if a.hasher.NeedsRehash(hashPassword) {
+		err = a.rehash(ctx, user, hashPassword)
+		if err != nil {
+			logger.Log.Warn("can not upgrade password hash", zap.String("login", user.Login), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func (a *DBAuth) getPasswordHash(ctx context.Context, login string) (string, error) {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
 	var hashPassword string
-	err := a.db.QueryRowContext(ctx, GetUserPasswordQuery, user.Login).Scan(&hashPassword)
+	err := a.db.QueryRowContext(ctx, GetUserPasswordQuery, login).Scan(&hashPassword)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return models.ErrWrongCredentials
+			return "", models.ErrWrongCredentials
 		}
+		return "", err
+	}
+	return hashPassword, nil
+}
+
+func (a *DBAuth) rehash(ctx context.Context, user models.MartUser, oldHash string) error {
+	newHash, err := a.hasher.Hash(user.Password)
+	if err != nil {
 		return err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(hashPassword), []byte(user.Password))
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// Matching on the old hash keeps a concurrent password change intact.
+	_, err = a.db.ExecContext(ctx, UpdateUserPasswordQuery, newHash, user.Login, oldHash)
 	if err != nil {
-		return models.ErrWrongCredentials
+		return err
 	}
+	logger.Log.Info("password hash upgraded", zap.String("login", user.Login))
 	return nil
 }
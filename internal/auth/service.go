@@ -6,9 +6,14 @@ import (
 )
 
 type AuthService interface {
-	Register(ctx context.Context, newUser models.MartUser) (token string, err error) //+
-	Login(ctx context.Context, user models.MartUser) (token string, err error)       //+
-	GetJWT(ctx context.Context, login string) (tokenString string, err error)        //+
+	Register(ctx context.Context, newUser models.MartUser, client models.ClientInfo) (token string, err error) //+
+	Login(ctx context.Context, user models.MartUser, client models.ClientInfo) (token string, mfaRequired bool, err error)
+	CompleteMFALogin(ctx context.Context, pendingToken string, code string, client models.ClientInfo) (token string, err error)
+	GetJWT(ctx context.Context, login string, client models.ClientInfo) (tokenString string, err error) //+
+	VerifyPassword(ctx context.Context, login string, password string, client models.ClientInfo) error
+	ChangePassword(ctx context.Context, UID int, change models.PasswordChange, client models.ClientInfo) (token string, err error)
 	ValidateJWT(ctx context.Context, tokenString string) error
 	GetUIDFromJWT(ctx context.Context, tokenString string) (int, error)
+	GetRoleFromJWT(ctx context.Context, tokenString string) (string, error)
+	GetSessionIDFromJWT(ctx context.Context, tokenString string) (string, error)
 }
@@ -2,37 +2,72 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/mfa"
 	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/referrals"
+	"github.com/Fuonder/goptherstore.git/internal/sessions"
+	"github.com/Fuonder/goptherstore.git/internal/throttling"
 	"github.com/Fuonder/goptherstore.git/internal/users"
 	"github.com/Fuonder/goptherstore.git/internal/wallets"
 	"github.com/dgrijalva/jwt-go"
+	"go.uber.org/zap"
 	"time"
 )
 
 type AService struct {
-	uConn  users.DatabaseUsers
-	wConn  wallets.DatabaseWallets
-	conn   DatabaseAuth
-	secret []byte
+	uConn     users.DatabaseUsers
+	wConn     wallets.DatabaseWallets
+	conn      DatabaseAuth
+	throttle  throttling.LoginThrottler
+	policy    *Policy
+	mfa       mfa.MFAService
+	sessions  sessions.SessionService
+	referrals referrals.ReferralService
+	secret    []byte
 }
 
-func NewAService(uConn users.DatabaseUsers, wConn wallets.DatabaseWallets, conn DatabaseAuth, secret []byte) *AService {
+const mfaPendingTTL = 5 * time.Minute
+
+func NewAService(uConn users.DatabaseUsers, wConn wallets.DatabaseWallets, conn DatabaseAuth, throttle throttling.LoginThrottler, policy *Policy, mfa mfa.MFAService, sessions sessions.SessionService, referrals referrals.ReferralService, secret []byte) *AService {
 	return &AService{
-		uConn:  uConn,
-		wConn:  wConn,
-		conn:   conn,
-		secret: secret,
+		uConn:     uConn,
+		wConn:     wConn,
+		conn:      conn,
+		throttle:  throttle,
+		policy:    policy,
+		mfa:       mfa,
+		sessions:  sessions,
+		referrals: referrals,
+		secret:    secret,
 	}
 }
 
-func (a *AService) Register(ctx context.Context, newUser models.MartUser) (token string, err error) {
+func (a *AService) Register(ctx context.Context, newUser models.MartUser, client models.ClientInfo) (token string, err error) {
+	err = a.policy.ValidateLogin(newUser.Login)
+	if err != nil {
+		return "", err
+	}
+	err = a.policy.ValidatePassword(newUser.Login, newUser.Password)
+	if err != nil {
+		return "", err
+	}
+
 	err = a.uConn.CheckLoginPresence(ctx, newUser)
 	if err != nil {
 		return "", err
 	}
 
+	referrerID := 0
+	if newUser.ReferralCode != "" {
+		referrerID, err = a.referrals.ResolveCode(ctx, newUser.ReferralCode)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	err = a.uConn.CreateUser(ctx, newUser)
 	if err != nil {
 		return "", err
@@ -48,20 +83,41 @@ func (a *AService) Register(ctx context.Context, newUser models.MartUser) (token
 		return "", err
 	}
 
-	token, err = a.GetJWT(ctx, newUser.Login)
+	// The account already exists, a lost referral is not worth failing
+	// the registration over.
+	if referrerID != 0 {
+		err = a.referrals.Attach(ctx, referrerID, UID, client.IP)
+		if err != nil {
+			logger.Log.Warn("can not attach referral", zap.Int("uid", UID), zap.Error(err))
+		}
+	}
+
+	token, err = a.GetJWT(ctx, newUser.Login, client)
 	if err != nil {
 		return "", err
 	}
 	return token, nil
 }
 
-func (a *AService) GetJWT(ctx context.Context, login string) (tokenString string, err error) {
-	expirationTime := time.Now().Add(10 * time.Hour)
+// GetJWT records a new session for the client and returns a token bound to it.
+func (a *AService) GetJWT(ctx context.Context, login string, client models.ClientInfo) (tokenString string, err error) {
+	state, err := a.uConn.GetAuthState(ctx, login)
+	if err != nil {
+		return "", err
+	}
+
+	session, err := a.sessions.StartSession(ctx, state.UID, client)
+	if err != nil {
+		return "", err
+	}
 
 	claims := &models.Claims{
-		Username: login,
+		Username:  login,
+		Role:      state.Role,
+		SessionID: session.ID,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
+			ExpiresAt: session.ExpiresAt.Unix(),
+			IssuedAt:  session.CreatedAt.Unix(),
 		},
 	}
 
@@ -75,37 +131,148 @@ func (a *AService) GetJWT(ctx context.Context, login string) (tokenString string
 	return tokenString, nil
 }
 
-func (a *AService) Login(ctx context.Context, user models.MartUser) (token string, err error) {
+func (a *AService) getMFAPendingJWT(login string) (tokenString string, err error) {
+	now := time.Now()
+	claims := &models.Claims{
+		Username:   login,
+		MFAPending: true,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: now.Add(mfaPendingTTL).Unix(),
+			IssuedAt:  now.Unix(),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(a.secret)
+}
+
+// Login returns a full token, or a short-lived "mfa pending" token with
+// mfaRequired set when the account has a second factor enrolled.
+func (a *AService) Login(ctx context.Context, user models.MartUser, client models.ClientInfo) (token string, mfaRequired bool, err error) {
+	err = a.throttle.Check(ctx, user.Login, client.IP)
+	if err != nil {
+		logger.Log.Debug("login throttled", zap.String("login", user.Login), zap.String("ip", client.IP))
+		return "", false, err
+	}
 	err = a.conn.ValidateUserCredentials(ctx, user)
 	if err != nil {
 		logger.Log.Debug("can not validate user creds")
-		return "", err
+		a.registerFailure(ctx, user.Login, client, err)
+		return "", false, err
 	}
-	token, err = a.GetJWT(ctx, user.Login)
+
+	UID, err := a.uConn.GetUIDByUsername(ctx, user.Login)
+	if err != nil {
+		return "", false, err
+	}
+	enabled, err := a.mfa.IsEnabled(ctx, UID)
+	if err != nil {
+		return "", false, err
+	}
+	if enabled {
+		token, err = a.getMFAPendingJWT(user.Login)
+		if err != nil {
+			logger.Log.Debug("can not create mfa pending JWT")
+			return "", false, err
+		}
+		return token, true, nil
+	}
+
+	a.registerSuccess(ctx, user.Login, client)
+	token, err = a.GetJWT(ctx, user.Login, client)
 	if err != nil {
 		logger.Log.Debug("can not create JWT")
+		return "", false, err
+	}
+	return token, false, nil
+}
+
+func (a *AService) CompleteMFALogin(ctx context.Context, pendingToken string, code string, client models.ClientInfo) (token string, err error) {
+	claims, err := a.parseClaims(pendingToken)
+	if err != nil || !claims.MFAPending {
+		return "", models.ErrWrongCredentials
+	}
+	err = a.throttle.Check(ctx, claims.Username, client.IP)
+	if err != nil {
 		return "", err
 	}
-	return token, nil
+
+	UID, err := a.uConn.GetUIDByUsername(ctx, claims.Username)
+	if err != nil {
+		return "", err
+	}
+	err = a.mfa.Verify(ctx, UID, code)
+	if err != nil {
+		a.registerFailure(ctx, claims.Username, client, err)
+		return "", err
+	}
+
+	a.registerSuccess(ctx, claims.Username, client)
+	return a.GetJWT(ctx, claims.Username, client)
 }
 
-func (a *AService) ValidateJWT(ctx context.Context, tokenString string) error {
+func (a *AService) registerFailure(ctx context.Context, login string, client models.ClientInfo, err error) {
+	if !errors.Is(err, models.ErrWrongCredentials) && !errors.Is(err, models.ErrInvalidMFACode) {
+		return
+	}
+	if tErr := a.throttle.RegisterFailure(ctx, login, client.IP); tErr != nil {
+		logger.Log.Error("can not register failed login", zap.Error(tErr))
+	}
+}
 
-	token, err := jwt.ParseWithClaims(tokenString, &models.Claims{}, func(token *jwt.Token) (interface{}, error) {
-		// Ensure the token uses the correct signing method
-		if token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
-			return nil, fmt.Errorf("unexpected signing method %v", token.Method.Alg())
-		}
-		return a.secret, nil
-	})
+func (a *AService) registerSuccess(ctx context.Context, login string, client models.ClientInfo) {
+	if tErr := a.throttle.RegisterSuccess(ctx, login, client.IP); tErr != nil {
+		logger.Log.Error("can not reset login throttle", zap.Error(tErr))
+	}
+}
 
-	if err != nil || !token.Valid {
-		return fmt.Errorf("validation err: %v", err)
+// VerifyPassword re-checks the password of a signed in user. It counts
+// against the same throttle as logins, so a stolen session can not be used
+// to guess the password.
+func (a *AService) VerifyPassword(ctx context.Context, login string, password string, client models.ClientInfo) error {
+	err := a.throttle.Check(ctx, login, client.IP)
+	if err != nil {
+		return err
 	}
+	err = a.conn.ValidateUserCredentials(ctx, models.MartUser{Login: login, Password: password})
+	if err != nil {
+		a.registerFailure(ctx, login, client, err)
+		return err
+	}
+	a.registerSuccess(ctx, login, client)
 	return nil
 }
 
-func (a *AService) GetUIDFromJWT(ctx context.Context, tokenString string) (int, error) {
+func (a *AService) ChangePassword(ctx context.Context, UID int, change models.PasswordChange, client models.ClientInfo) (token string, err error) {
+	login, err := a.uConn.GetLoginByUID(ctx, UID)
+	if err != nil {
+		return "", err
+	}
+	err = a.VerifyPassword(ctx, login, change.OldPassword, client)
+	if err != nil {
+		return "", err
+	}
+	err = a.policy.ValidatePassword(login, change.NewPassword)
+	if err != nil {
+		return "", err
+	}
+	if change.NewPassword == change.OldPassword {
+		return "", fmt.Errorf("%w: new password must differ from the old one", models.ErrPolicyViolation)
+	}
+
+	// Every existing session is revoked, the caller gets a fresh one
+	// to stay signed in.
+	err = a.uConn.UpdatePassword(ctx, UID, change.NewPassword)
+	if err != nil {
+		return "", err
+	}
+	err = a.sessions.RevokeAll(ctx, UID, "")
+	if err != nil {
+		return "", err
+	}
+	return a.GetJWT(ctx, login, client)
+}
+
+func (a *AService) parseClaims(tokenString string) (*models.Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &models.Claims{}, func(token *jwt.Token) (interface{}, error) {
 		// Ensure the token uses the correct signing method
 		if token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
@@ -114,11 +281,62 @@ func (a *AService) GetUIDFromJWT(ctx context.Context, tokenString string) (int,
 		return a.secret, nil
 	})
 	if err != nil || !token.Valid {
-		return 0, fmt.Errorf("invalid token: %v", err)
+		return nil, fmt.Errorf("invalid token: %v", err)
 	}
 	claims, ok := token.Claims.(*models.Claims)
-	if !ok || !token.Valid {
-		return 0, fmt.Errorf("invalid token")
+	if !ok {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return claims, nil
+}
+
+func (a *AService) ValidateJWT(ctx context.Context, tokenString string) error {
+	claims, err := a.parseClaims(tokenString)
+	if err != nil {
+		return fmt.Errorf("validation err: %v", err)
+	}
+	if claims.MFAPending {
+		return fmt.Errorf("validation err: second factor not completed")
+	}
+
+	state, err := a.uConn.GetAuthState(ctx, claims.Username)
+	if err != nil {
+		return fmt.Errorf("validation err: %v", err)
+	}
+	if !state.PasswordChangedAt.IsZero() && claims.IssuedAt < state.PasswordChangedAt.Unix() {
+		return fmt.Errorf("validation err: token issued before password change")
+	}
+	// A role change invalidates tokens carrying the old role.
+	if claims.Role != state.Role {
+		return fmt.Errorf("validation err: role changed")
+	}
+	err = a.sessions.CheckSession(ctx, state.UID, claims.SessionID)
+	if err != nil {
+		return fmt.Errorf("validation err: %v", err)
+	}
+	return nil
+}
+
+func (a *AService) GetSessionIDFromJWT(ctx context.Context, tokenString string) (string, error) {
+	claims, err := a.parseClaims(tokenString)
+	if err != nil {
+		return "", err
+	}
+	return claims.SessionID, nil
+}
+
+func (a *AService) GetRoleFromJWT(ctx context.Context, tokenString string) (string, error) {
+	claims, err := a.parseClaims(tokenString)
+	if err != nil {
+		return "", err
+	}
+	return claims.Role, nil
+}
+
+func (a *AService) GetUIDFromJWT(ctx context.Context, tokenString string) (int, error) {
+	claims, err := a.parseClaims(tokenString)
+	if err != nil {
+		return 0, err
 	}
 
 	UID, err := a.uConn.GetUIDByUsername(ctx, claims.Username)
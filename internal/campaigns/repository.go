@@ -0,0 +1,277 @@
+package campaigns
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/wallets"
+	"sync"
+	"time"
+)
+
+const (
+	campaignColumns = `
+						c.id, c.name, c.rule, COALESCE(c.code, ''), c.threshold, c.reward, c.per_user_limit, 
+						c.global_limit, c.starts_at, c.ends_at, c.created_at, 
+						(SELECT COUNT(*) FROM campaign_redemptions r WHERE r.campaign_id = c.id)`
+	InsertCampaignQuery = `
+						INSERT INTO campaigns (name, rule, code, threshold, reward, per_user_limit, global_limit, starts_at, ends_at, created_at) 
+						VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id;`
+	UpdateCampaignQuery = `
+						UPDATE campaigns 
+						SET name = $2, rule = $3, code = $4, threshold = $5, reward = $6, per_user_limit = $7, 
+							global_limit = $8, starts_at = $9, ends_at = $10 
+						WHERE id = $1 AND archived_at IS NULL;`
+	ArchiveCampaignQuery = `UPDATE campaigns SET archived_at = $2 WHERE id = $1 AND archived_at IS NULL;`
+	GetCampaignsQuery    = `SELECT` + campaignColumns + `
+						FROM campaigns c 
+						WHERE c.archived_at IS NULL 
+						ORDER BY c.id;`
+	GetCampaignQuery = `SELECT` + campaignColumns + `
+						FROM campaigns c 
+						WHERE c.id = $1 AND c.archived_at IS NULL;`
+	GetCampaignByCodeQuery = `SELECT` + campaignColumns + `
+						FROM campaigns c 
+						WHERE c.code = $1 AND c.rule = 'promo_code' AND c.archived_at IS NULL;`
+	GetActiveCampaignsQuery = `SELECT` + campaignColumns + `
+						FROM campaigns c 
+						WHERE c.rule = ANY($1) AND c.archived_at IS NULL 
+							AND c.starts_at <= $2 AND (c.ends_at IS NULL OR c.ends_at > $2) 
+						ORDER BY c.id;`
+	CountProcessedOrdersQuery = `SELECT COUNT(*) FROM orders WHERE user_id = $1 AND status = 'PROCESSED';`
+	LockCampaignQuery         = `SELECT global_limit, per_user_limit FROM campaigns WHERE id = $1 FOR UPDATE;`
+	CountRedemptionsQuery     = `
+						SELECT COUNT(*), COUNT(*) FILTER (WHERE user_id = $2) 
+						FROM campaign_redemptions 
+						WHERE campaign_id = $1;`
+	InsertRedemptionQuery = `
+						INSERT INTO campaign_redemptions (campaign_id, user_id, order_number, amount, created_at) 
+						VALUES ($1, $2, $3, $4, $5) 
+						ON CONFLICT DO NOTHING;`
+)
+
+type DatabaseCampaigns interface {
+	CreateCampaign(ctx context.Context, campaign models.Campaign) (int, error)
+	UpdateCampaign(ctx context.Context, campaign models.Campaign) error
+	ArchiveCampaign(ctx context.Context, ID int) error
+	GetCampaigns(ctx context.Context) ([]models.Campaign, error)
+	GetCampaign(ctx context.Context, ID int) (models.Campaign, error)
+	GetCampaignByCode(ctx context.Context, code string) (models.Campaign, error)
+	GetActiveCampaigns(ctx context.Context, rules []string, at time.Time) ([]models.Campaign, error)
+	CountProcessedOrders(ctx context.Context, UID int) (int, error)
+	Redeem(ctx context.Context, credit models.CampaignCredit, lot models.PointLot) error
+}
+
+type DBCampaigns struct {
+	db *sql.DB
+	mu *sync.RWMutex
+}
+
+func NewDBCampaigns(db *sql.DB, mu *sync.RWMutex) (*DBCampaigns, error) {
+	return &DBCampaigns{db: db, mu: mu}, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanCampaign(row rowScanner) (models.Campaign, error) {
+	var c models.Campaign
+	var endsAt sql.NullTime
+	err := row.Scan(
+		&c.ID,
+		&c.Name,
+		&c.Rule,
+		&c.Code,
+		&c.Threshold,
+		&c.Reward,
+		&c.PerUserLimit,
+		&c.GlobalLimit,
+		&c.StartsAt,
+		&endsAt,
+		&c.CreatedAt,
+		&c.Redemptions,
+	)
+	if err != nil {
+		return models.Campaign{}, err
+	}
+	if endsAt.Valid {
+		c.EndsAt = &endsAt.Time
+	}
+	return c, nil
+}
+
+func campaignArgs(c models.Campaign) []any {
+	var code sql.NullString
+	if c.Code != "" {
+		code = sql.NullString{String: c.Code, Valid: true}
+	}
+	var endsAt sql.NullTime
+	if c.EndsAt != nil {
+		endsAt = sql.NullTime{Time: *c.EndsAt, Valid: true}
+	}
+	return []any{c.Name, c.Rule, code, c.Threshold, c.Reward, c.PerUserLimit, c.GlobalLimit, c.StartsAt, endsAt}
+}
+
+func (c *DBCampaigns) CreateCampaign(ctx context.Context, campaign models.Campaign) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ID := 0
+	args := append(campaignArgs(campaign), campaign.CreatedAt)
+	err := c.db.QueryRowContext(ctx, InsertCampaignQuery, args...).Scan(&ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create campaign: %w", err)
+	}
+	return ID, nil
+}
+
+func (c *DBCampaigns) UpdateCampaign(ctx context.Context, campaign models.Campaign) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	args := append([]any{campaign.ID}, campaignArgs(campaign)...)
+	res, err := c.db.ExecContext(ctx, UpdateCampaignQuery, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update campaign: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return models.ErrCampaignNotFound
+	}
+	return nil
+}
+
+// ArchiveCampaign hides the campaign but keeps its redemption history.
+func (c *DBCampaigns) ArchiveCampaign(ctx context.Context, ID int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, err := c.db.ExecContext(ctx, ArchiveCampaignQuery, ID, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to archive campaign: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return models.ErrCampaignNotFound
+	}
+	return nil
+}
+
+func (c *DBCampaigns) queryCampaigns(ctx context.Context, query string, args ...any) ([]models.Campaign, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query campaigns: %v", err)
+	}
+	defer rows.Close()
+	campaigns := make([]models.Campaign, 0)
+	for rows.Next() {
+		campaign, err := scanCampaign(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		campaigns = append(campaigns, campaign)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %v", err)
+	}
+	return campaigns, nil
+}
+
+func (c *DBCampaigns) GetCampaigns(ctx context.Context) ([]models.Campaign, error) {
+	return c.queryCampaigns(ctx, GetCampaignsQuery)
+}
+
+func (c *DBCampaigns) GetActiveCampaigns(ctx context.Context, rules []string, at time.Time) ([]models.Campaign, error) {
+	return c.queryCampaigns(ctx, GetActiveCampaignsQuery, rules, at)
+}
+
+func (c *DBCampaigns) getOne(ctx context.Context, query string, arg any) (models.Campaign, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	campaign, err := scanCampaign(c.db.QueryRowContext(ctx, query, arg))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Campaign{}, models.ErrCampaignNotFound
+		}
+		return models.Campaign{}, fmt.Errorf("failed to get campaign: %w", err)
+	}
+	return campaign, nil
+}
+
+func (c *DBCampaigns) GetCampaign(ctx context.Context, ID int) (models.Campaign, error) {
+	return c.getOne(ctx, GetCampaignQuery, ID)
+}
+
+func (c *DBCampaigns) GetCampaignByCode(ctx context.Context, code string) (models.Campaign, error) {
+	return c.getOne(ctx, GetCampaignByCodeQuery, code)
+}
+
+func (c *DBCampaigns) CountProcessedOrders(ctx context.Context, UID int) (int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	count := 0
+	err := c.db.QueryRowContext(ctx, CountProcessedOrdersQuery, UID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count orders: %w", err)
+	}
+	return count, nil
+}
+
+// Redeem checks the campaign limits under a row lock, records the
+// redemption and credits the wallet in one transaction.
+func (c *DBCampaigns) Redeem(ctx context.Context, credit models.CampaignCredit, lot models.PointLot) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var globalLimit, perUserLimit int
+	err = tx.QueryRowContext(ctx, LockCampaignQuery, credit.CampaignID).Scan(&globalLimit, &perUserLimit)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrCampaignNotFound
+		}
+		return fmt.Errorf("failed to lock campaign: %w", err)
+	}
+	var total, byUser int
+	err = tx.QueryRowContext(ctx, CountRedemptionsQuery, credit.CampaignID, credit.UserID).Scan(&total, &byUser)
+	if err != nil {
+		return fmt.Errorf("failed to count redemptions: %w", err)
+	}
+	if (globalLimit > 0 && total >= globalLimit) || (perUserLimit > 0 && byUser >= perUserLimit) {
+		return models.ErrPromoLimitReached
+	}
+
+	var order sql.NullString
+	if credit.OrderID != "" {
+		order = sql.NullString{String: credit.OrderID, Valid: true}
+	}
+	res, err := tx.ExecContext(ctx, InsertRedemptionQuery, credit.CampaignID, credit.UserID, order, credit.Amount, credit.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record redemption: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return models.ErrPromoLimitReached
+	}
+	err = wallets.CreditTx(ctx, tx, lot)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
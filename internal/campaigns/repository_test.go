@@ -0,0 +1,142 @@
+package campaigns
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/testdb"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestDBCampaigns(t *testing.T) (*DBCampaigns, *sql.DB) {
+	t.Helper()
+	db := testdb.Open(t)
+	c, err := NewDBCampaigns(db, &sync.RWMutex{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, db
+}
+
+func createPromo(t *testing.T, c *DBCampaigns, perUser int, global int) models.Campaign {
+	t.Helper()
+	raw := make([]byte, 6)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	campaign := models.Campaign{
+		Name:         "test promo",
+		Rule:         models.CampaignRulePromoCode,
+		Code:         "T" + hex.EncodeToString(raw),
+		Reward:       10,
+		PerUserLimit: perUser,
+		GlobalLimit:  global,
+		StartsAt:     time.Now().Add(-time.Hour),
+		CreatedAt:    time.Now(),
+	}
+	ID, err := c.CreateCampaign(context.Background(), campaign)
+	if err != nil {
+		t.Fatalf("CreateCampaign: %v", err)
+	}
+	campaign.ID = ID
+	return campaign
+}
+
+func redeem(c *DBCampaigns, campaign models.Campaign, UID int) error {
+	now := time.Now()
+	return c.Redeem(context.Background(), models.CampaignCredit{
+		CampaignID: campaign.ID,
+		UserID:     UID,
+		Amount:     campaign.Reward,
+		CreatedAt:  now,
+	}, models.PointLot{
+		UserID:   UID,
+		Source:   models.LotSourceCampaign,
+		Amount:   campaign.Reward,
+		EarnedAt: now,
+	})
+}
+
+func balance(t *testing.T, db *sql.DB, UID int) float32 {
+	t.Helper()
+	var amount float32
+	err := db.QueryRowContext(context.Background(), `SELECT balance FROM wallets WHERE user_id = $1;`, UID).Scan(&amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return amount
+}
+
+func TestDBRedeemPerUserLimit(t *testing.T) {
+	c, db := newTestDBCampaigns(t)
+	campaign := createPromo(t, c, 2, 0)
+	UID, _ := testdb.CreateUser(t, db)
+	other, _ := testdb.CreateUser(t, db)
+
+	for i := range 2 {
+		if err := redeem(c, campaign, UID); err != nil {
+			t.Fatalf("redemption %d: %v", i+1, err)
+		}
+	}
+	if err := redeem(c, campaign, UID); !errors.Is(err, models.ErrPromoLimitReached) {
+		t.Fatalf("third redemption: error = %v, want ErrPromoLimitReached", err)
+	}
+	if got := balance(t, db, UID); got != 20 {
+		t.Errorf("balance = %.2f, want 20", got)
+	}
+	// The limit is per user, somebody else still gets the reward.
+	if err := redeem(c, campaign, other); err != nil {
+		t.Errorf("other user: %v", err)
+	}
+}
+
+func TestDBRedeemGlobalLimitConcurrently(t *testing.T) {
+	c, db := newTestDBCampaigns(t)
+	const limit = 3
+	campaign := createPromo(t, c, 1, limit)
+
+	users := make([]int, 8)
+	for i := range users {
+		users[i], _ = testdb.CreateUser(t, db)
+	}
+	// Each goroutine plays a replica with its own lock, only the campaign
+	// row lock keeps them apart.
+	errs := make([]error, len(users))
+	var wg sync.WaitGroup
+	for i, UID := range users {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			replica := &DBCampaigns{db: db, mu: &sync.RWMutex{}}
+			errs[i] = redeem(replica, campaign, UID)
+		}()
+	}
+	wg.Wait()
+
+	var granted int
+	var credited float32
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			granted++
+		case !errors.Is(err, models.ErrPromoLimitReached):
+			t.Fatalf("redeem: %v", err)
+		}
+		credited += balance(t, db, users[i])
+	}
+	if granted != limit || credited != limit*campaign.Reward {
+		t.Errorf("granted %d credited %.2f, want %d and %.2f", granted, credited, limit, limit*campaign.Reward)
+	}
+	stored, err := c.GetCampaign(context.Background(), campaign.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Redemptions != limit {
+		t.Errorf("redemptions = %d, want %d", stored.Redemptions, limit)
+	}
+}
@@ -0,0 +1,16 @@
+package campaigns
+
+import (
+	"context"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+)
+
+type CampaignService interface {
+	CreateCampaign(ctx context.Context, campaign models.Campaign) (models.Campaign, error)
+	GetCampaigns(ctx context.Context) ([]models.Campaign, error)
+	GetCampaign(ctx context.Context, ID int) (models.Campaign, error)
+	UpdateCampaign(ctx context.Context, ID int, campaign models.Campaign) (models.Campaign, error)
+	DeleteCampaign(ctx context.Context, ID int) error
+	RedeemCode(ctx context.Context, UID int, code string) (models.CampaignCredit, error)
+	OnOrderProcessed(ctx context.Context, UID int, orderNumber string)
+}
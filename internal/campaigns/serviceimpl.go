@@ -0,0 +1,203 @@
+package campaigns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/events"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/wallets"
+	"go.uber.org/zap"
+	"slices"
+	"strings"
+	"time"
+)
+
+const maxCodeLen = 32
+
+type Config struct {
+	// PointsTTL is how long campaign credits live, zero keeps them forever.
+	PointsTTL time.Duration
+}
+
+type CService struct {
+	conn   DatabaseCampaigns
+	wConn  wallets.DatabaseWallets
+	events events.Publisher
+	cfg    Config
+}
+
+func NewCService(conn DatabaseCampaigns, wConn wallets.DatabaseWallets, events events.Publisher, cfg Config) *CService {
+	return &CService{conn: conn, wConn: wConn, events: events, cfg: cfg}
+}
+
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func validateCampaign(c *models.Campaign) error {
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" {
+		return fmt.Errorf("%w: name is required", models.ErrInvalidCampaign)
+	}
+	if !slices.Contains(models.CampaignRules, c.Rule) {
+		return fmt.Errorf("%w: rule must be one of %s", models.ErrInvalidCampaign, strings.Join(models.CampaignRules, ", "))
+	}
+	if c.Reward <= 0 {
+		return fmt.Errorf("%w: reward must be positive", models.ErrInvalidCampaign)
+	}
+	c.Code = normalizeCode(c.Code)
+	switch c.Rule {
+	case models.CampaignRulePromoCode:
+		if c.Code == "" || len(c.Code) > maxCodeLen {
+			return fmt.Errorf("%w: code must be 1 to %d characters", models.ErrInvalidCampaign, maxCodeLen)
+		}
+		c.Threshold = 0
+	case models.CampaignRuleOrderCount:
+		if c.Threshold < 1 {
+			return fmt.Errorf("%w: threshold must be at least 1", models.ErrInvalidCampaign)
+		}
+		c.Code = ""
+	default:
+		c.Threshold, c.Code = 0, ""
+	}
+	if c.PerUserLimit < 0 || c.GlobalLimit < 0 {
+		return fmt.Errorf("%w: limits must not be negative", models.ErrInvalidCampaign)
+	}
+	if c.PerUserLimit == 0 {
+		c.PerUserLimit = 1
+	}
+	if c.StartsAt.IsZero() {
+		c.StartsAt = time.Now()
+	}
+	if c.EndsAt != nil && !c.EndsAt.After(c.StartsAt) {
+		return fmt.Errorf("%w: ends_at must be after starts_at", models.ErrInvalidCampaign)
+	}
+	return nil
+}
+
+func (s *CService) CreateCampaign(ctx context.Context, campaign models.Campaign) (models.Campaign, error) {
+	err := validateCampaign(&campaign)
+	if err != nil {
+		return models.Campaign{}, err
+	}
+	campaign.CreatedAt = time.Now()
+	campaign.ID, err = s.conn.CreateCampaign(ctx, campaign)
+	if err != nil {
+		return models.Campaign{}, err
+	}
+	return campaign, nil
+}
+
+func (s *CService) GetCampaigns(ctx context.Context) ([]models.Campaign, error) {
+	campaigns, err := s.conn.GetCampaigns(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(campaigns) == 0 {
+		return nil, models.ErrNoData
+	}
+	return campaigns, nil
+}
+
+func (s *CService) GetCampaign(ctx context.Context, ID int) (models.Campaign, error) {
+	return s.conn.GetCampaign(ctx, ID)
+}
+
+func (s *CService) UpdateCampaign(ctx context.Context, ID int, campaign models.Campaign) (models.Campaign, error) {
+	err := validateCampaign(&campaign)
+	if err != nil {
+		return models.Campaign{}, err
+	}
+	campaign.ID = ID
+	err = s.conn.UpdateCampaign(ctx, campaign)
+	if err != nil {
+		return models.Campaign{}, err
+	}
+	return s.conn.GetCampaign(ctx, ID)
+}
+
+func (s *CService) DeleteCampaign(ctx context.Context, ID int) error {
+	return s.conn.ArchiveCampaign(ctx, ID)
+}
+
+func (s *CService) RedeemCode(ctx context.Context, UID int, code string) (models.CampaignCredit, error) {
+	code = normalizeCode(code)
+	if code == "" {
+		return models.CampaignCredit{}, models.ErrInvalidPromoCode
+	}
+	campaign, err := s.conn.GetCampaignByCode(ctx, code)
+	if err != nil {
+		if errors.Is(err, models.ErrCampaignNotFound) {
+			return models.CampaignCredit{}, models.ErrInvalidPromoCode
+		}
+		return models.CampaignCredit{}, err
+	}
+	if !campaign.ActiveAt(time.Now()) {
+		return models.CampaignCredit{}, models.ErrPromoNotActive
+	}
+	return s.credit(ctx, campaign, UID, "")
+}
+
+// OnOrderProcessed grants the order based campaigns matched by the order
+// that just reached PROCESSED. Failures are logged, the accrual itself is
+// already credited.
+func (s *CService) OnOrderProcessed(ctx context.Context, UID int, orderNumber string) {
+	now := time.Now()
+	campaigns, err := s.conn.GetActiveCampaigns(ctx, []string{models.CampaignRuleFirstOrder, models.CampaignRuleOrderCount}, now)
+	if err != nil {
+		logger.Log.Error("can not load campaigns", zap.Error(err))
+		return
+	}
+	if len(campaigns) == 0 {
+		return
+	}
+	processed, err := s.conn.CountProcessedOrders(ctx, UID)
+	if err != nil {
+		logger.Log.Error("can not count processed orders", zap.Int("uid", UID), zap.Error(err))
+		return
+	}
+	for _, campaign := range campaigns {
+		matched := (campaign.Rule == models.CampaignRuleFirstOrder && processed == 1) ||
+			(campaign.Rule == models.CampaignRuleOrderCount && processed == campaign.Threshold)
+		if !matched {
+			continue
+		}
+		_, err = s.credit(ctx, campaign, UID, orderNumber)
+		if err != nil && !errors.Is(err, models.ErrPromoLimitReached) {
+			logger.Log.Error("can not grant campaign",
+				zap.Int("campaign", campaign.ID),
+				zap.Int("uid", UID),
+				zap.Error(err))
+		}
+	}
+}
+
+func (s *CService) credit(ctx context.Context, campaign models.Campaign, UID int, orderNumber string) (models.CampaignCredit, error) {
+	now := time.Now()
+	credit := models.CampaignCredit{
+		CampaignID:   campaign.ID,
+		CampaignName: campaign.Name,
+		UserID:       UID,
+		OrderID:      orderNumber,
+		Amount:       campaign.Reward,
+		CreatedAt:    now,
+	}
+	lot := models.PointLot{
+		UserID:   UID,
+		OrderID:  orderNumber,
+		Source:   models.LotSourceCampaign,
+		Amount:   campaign.Reward,
+		EarnedAt: now,
+	}
+	if s.cfg.PointsTTL > 0 {
+		lot.ExpiresAt = now.Add(s.cfg.PointsTTL)
+	}
+	err := s.conn.Redeem(ctx, credit, lot)
+	if err != nil {
+		return models.CampaignCredit{}, err
+	}
+	wallets.PublishBalance(ctx, s.wConn, s.events, UID, credit.Amount, "campaign")
+	return credit, nil
+}
@@ -0,0 +1,33 @@
+package campaigns
+
+import (
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"testing"
+	"time"
+)
+
+func TestValidateCampaignLimits(t *testing.T) {
+	c := models.Campaign{Name: " promo ", Rule: models.CampaignRulePromoCode, Code: " spring24 ", Reward: 5}
+	if err := validateCampaign(&c); err != nil {
+		t.Fatalf("validateCampaign: %v", err)
+	}
+	if c.PerUserLimit != 1 || c.GlobalLimit != 0 || c.Code != "SPRING24" || c.Name != "promo" {
+		t.Errorf("normalized campaign = %+v", c)
+	}
+
+	ends := time.Now().Add(-time.Hour)
+	invalid := []models.Campaign{
+		{Name: "neg user", Rule: models.CampaignRuleFirstOrder, Reward: 5, PerUserLimit: -1},
+		{Name: "neg global", Rule: models.CampaignRuleFirstOrder, Reward: 5, GlobalLimit: -1},
+		{Name: "no reward", Rule: models.CampaignRuleFirstOrder},
+		{Name: "no threshold", Rule: models.CampaignRuleOrderCount, Reward: 5},
+		{Name: "no code", Rule: models.CampaignRulePromoCode, Reward: 5},
+		{Name: "ended", Rule: models.CampaignRuleFirstOrder, Reward: 5, StartsAt: time.Now(), EndsAt: &ends},
+	}
+	for _, c := range invalid {
+		if err := validateCampaign(&c); !errors.Is(err, models.ErrInvalidCampaign) {
+			t.Errorf("%s: error = %v, want ErrInvalidCampaign", c.Name, err)
+		}
+	}
+}
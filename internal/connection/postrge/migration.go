@@ -9,6 +9,13 @@ const (
 		created_at TIMESTAMP DEFAULT NOW()
 	);
 
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS password_changed_at TIMESTAMP;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user';
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_scheduled_at TIMESTAMP;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name TEXT;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS email TEXT;
+
 	CREATE TABLE IF NOT EXISTS wallets (
 		id SERIAL PRIMARY KEY,
 		user_id INT UNIQUE NOT NULL REFERENCES users(id) ON DELETE CASCADE,
@@ -27,17 +34,277 @@ const (
 		UNIQUE(user_id, order_number)
 	);
 
+	CREATE TABLE IF NOT EXISTS order_events (
+		id SERIAL PRIMARY KEY,
+		order_id INT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+		kind TEXT NOT NULL,
+		status TEXT,
+		amount REAL,
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+
+	-- bonus_amount is what the accrual service answered, credited_amount
+	-- what reached the wallet after the tier multiplier.
+	ALTER TABLE orders ADD COLUMN IF NOT EXISTS credited_amount REAL;
+	ALTER TABLE orders ADD COLUMN IF NOT EXISTS credited_at TIMESTAMP;
+	UPDATE orders o SET 
+		credited_amount = COALESCE(
+			(SELECT SUM(e.amount) FROM order_events e WHERE e.order_id = o.id AND e.kind = 'credited'), o.bonus_amount), 
+		credited_at = COALESCE(
+			(SELECT MAX(e.created_at) FROM order_events e WHERE e.order_id = o.id AND e.kind = 'credited'), o.created_at) 
+	WHERE o.credited_amount IS NULL AND o.status = 'PROCESSED' AND o.bonus_amount > 0;
+
 	CREATE TABLE IF NOT EXISTS withdrawals (
 		id SERIAL PRIMARY KEY,
 		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
 		order_number TEXT NOT NULL,
 		amount REAL,
 		created_at TIMESTAMP DEFAULT NOW(),
-		status BOOLEAN NOT NULL DEFAULT TRUE
+		status TEXT NOT NULL DEFAULT 'PENDING'
+	);
+
+	DO $$
+	BEGIN
+		IF (SELECT data_type FROM information_schema.columns
+			WHERE table_name = 'withdrawals' AND column_name = 'status') = 'boolean' THEN
+			ALTER TABLE withdrawals ALTER COLUMN status DROP DEFAULT;
+			ALTER TABLE withdrawals ALTER COLUMN status TYPE TEXT
+				USING CASE WHEN status THEN 'COMPLETED' ELSE 'FAILED' END;
+			ALTER TABLE withdrawals ALTER COLUMN status SET DEFAULT 'PENDING';
+		END IF;
+	END $$;
+	ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS status_reason TEXT;
+	ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP;
+	-- unique_order holds the order number only for withdrawals made while
+	-- unique orders were required, so older duplicates never block the index.
+	ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS unique_order TEXT;
+	ALTER TABLE wallets ADD COLUMN IF NOT EXISTS held REAL NOT NULL DEFAULT 0 CHECK (held >= 0);
+
+	CREATE TABLE IF NOT EXISTS balance_holds (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		amount REAL NOT NULL,
+		captured REAL NOT NULL DEFAULT 0,
+		order_number TEXT,
+		status TEXT NOT NULL DEFAULT 'ACTIVE',
+		withdrawal_id INT REFERENCES withdrawals(id) ON DELETE SET NULL,
+		lots_expire_at TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW(),
+		settled_at TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS balance_adjustments (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		admin_id INT REFERENCES users(id) ON DELETE SET NULL,
+		amount REAL NOT NULL,
+		reason TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		prefix TEXT UNIQUE NOT NULL,
+		key_hash TEXT NOT NULL,
+		scopes TEXT NOT NULL,
+		expires_at TIMESTAMP,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		user_agent TEXT NOT NULL DEFAULT '',
+		ip TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT NOW(),
+		last_seen_at TIMESTAMP DEFAULT NOW(),
+		expires_at TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id SERIAL PRIMARY KEY,
+		user_id INT REFERENCES users(id) ON DELETE CASCADE,
+		url TEXT NOT NULL,
+		event_types TEXT NOT NULL DEFAULT '',
+		secret TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id SERIAL PRIMARY KEY,
+		webhook_id INT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+		event_id TEXT NOT NULL,
+		event_type TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INT NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP,
+		last_status_code INT,
+		last_error TEXT,
+		created_at TIMESTAMP DEFAULT NOW(),
+		delivered_at TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS point_lots (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		order_number TEXT,
+		source TEXT NOT NULL,
+		amount REAL NOT NULL,
+		remaining REAL NOT NULL CHECK (remaining >= 0),
+		expired_amount REAL NOT NULL DEFAULT 0,
+		earned_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS withdrawal_lots (
+		withdrawal_id INT NOT NULL REFERENCES withdrawals(id) ON DELETE CASCADE,
+		lot_id INT NOT NULL REFERENCES point_lots(id) ON DELETE CASCADE,
+		amount REAL NOT NULL,
+		PRIMARY KEY (withdrawal_id, lot_id)
+	);
+
+	-- A balance credited before point lots existed becomes one legacy lot,
+	-- the oldest of the wallet, so it is spent first and can expire.
+	INSERT INTO point_lots (user_id, source, amount, remaining, earned_at)
+	SELECT w.user_id, 'legacy', w.balance - COALESCE(l.remaining, 0), w.balance - COALESCE(l.remaining, 0), COALESCE(w.created_at, NOW())
+	FROM wallets w
+	LEFT JOIN (SELECT user_id, SUM(remaining) AS remaining FROM point_lots GROUP BY user_id) l ON l.user_id = w.user_id
+	WHERE w.balance - COALESCE(l.remaining, 0) > 0
+	AND NOT EXISTS (SELECT 1 FROM point_lots p WHERE p.user_id = w.user_id AND p.source = 'legacy');
+
+	CREATE TABLE IF NOT EXISTS user_tiers (
+		user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		tier TEXT NOT NULL,
+		rolling_amount REAL NOT NULL DEFAULT 0,
+		evaluated_at TIMESTAMP NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS campaigns (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		rule TEXT NOT NULL,
+		code TEXT,
+		threshold INT NOT NULL DEFAULT 0,
+		reward REAL NOT NULL CHECK (reward > 0),
+		per_user_limit INT NOT NULL DEFAULT 1,
+		global_limit INT NOT NULL DEFAULT 0,
+		starts_at TIMESTAMP NOT NULL,
+		ends_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT NOW(),
+		archived_at TIMESTAMP
+	);
+
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_campaigns_code ON campaigns(code) WHERE archived_at IS NULL;
+
+	CREATE TABLE IF NOT EXISTS campaign_redemptions (
+		id SERIAL PRIMARY KEY,
+		campaign_id INT NOT NULL REFERENCES campaigns(id),
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		order_number TEXT,
+		amount REAL NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW(),
+		UNIQUE (campaign_id, user_id, order_number)
+	);
+
+	CREATE TABLE IF NOT EXISTS referral_codes (
+		user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		code TEXT UNIQUE NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS referrals (
+		id SERIAL PRIMARY KEY,
+		referrer_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		referee_id INT UNIQUE NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		signup_ip TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		reason TEXT,
+		referrer_bonus REAL NOT NULL DEFAULT 0,
+		referee_bonus REAL NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT NOW(),
+		rewarded_at TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS transfers (
+		id SERIAL PRIMARY KEY,
+		sender_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		recipient_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		amount REAL NOT NULL,
+		idempotency_key TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW(),
+		UNIQUE (sender_id, idempotency_key)
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_attempts (
+		id SERIAL PRIMARY KEY,
+		delivery_id INT NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+		status_code INT,
+		error TEXT,
+		duration_ms BIGINT NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS login_throttle (
+		key TEXT PRIMARY KEY,
+		failures INT NOT NULL DEFAULT 0,
+		locked_until TIMESTAMP,
+		last_failure TIMESTAMP NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS login_lockouts (
+		id SERIAL PRIMARY KEY,
+		key TEXT NOT NULL,
+		failures INT NOT NULL,
+		locked_until TIMESTAMP NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS user_totp (
+		user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		secret TEXT NOT NULL,
+		confirmed BOOLEAN NOT NULL DEFAULT FALSE,
+		last_used_step BIGINT NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		code_hash TEXT NOT NULL,
+		used_at TIMESTAMP
 	);
 
 	CREATE INDEX IF NOT EXISTS idx_orders_user_id ON orders(user_id);
+	CREATE INDEX IF NOT EXISTS idx_orders_user_created ON orders(user_id, created_at DESC, id DESC);
+	CREATE INDEX IF NOT EXISTS idx_order_events_order_id ON order_events(order_id, id);
 	CREATE INDEX IF NOT EXISTS idx_withdrawals_user_id ON withdrawals(user_id);
+	CREATE INDEX IF NOT EXISTS idx_withdrawals_user_created ON withdrawals(user_id, created_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_balance_holds_user_id ON balance_holds(user_id, created_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_balance_holds_active ON balance_holds(expires_at) WHERE status = 'ACTIVE';
+	CREATE INDEX IF NOT EXISTS idx_withdrawals_pending ON withdrawals(created_at) WHERE status = 'PENDING';
+	DROP INDEX IF EXISTS idx_withdrawals_order_unique;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_withdrawals_unique_order ON withdrawals(unique_order) WHERE status <> 'FAILED';
+	CREATE INDEX IF NOT EXISTS idx_point_lots_user_active ON point_lots(user_id, earned_at, id) WHERE remaining > 0;
+	CREATE INDEX IF NOT EXISTS idx_point_lots_expiry ON point_lots(expires_at) WHERE remaining > 0;
+	CREATE INDEX IF NOT EXISTS idx_campaign_redemptions_user_id ON campaign_redemptions(user_id, campaign_id);
+	CREATE INDEX IF NOT EXISTS idx_transfers_sender_id ON transfers(sender_id, created_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_transfers_recipient_id ON transfers(recipient_id, created_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_referrals_referrer_id ON referrals(referrer_id, created_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_balance_adjustments_user_id ON balance_adjustments(user_id);
+	CREATE INDEX IF NOT EXISTS idx_totp_recovery_codes_user_id ON totp_recovery_codes(user_id);
+	CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
+	CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+	CREATE INDEX IF NOT EXISTS idx_webhooks_user_id ON webhooks(user_id);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id, id DESC);
+	CREATE INDEX IF NOT EXISTS idx_webhook_attempts_delivery_id ON webhook_attempts(delivery_id);
 	`
 )
 
@@ -2,53 +2,189 @@ package dbservices
 
 import (
 	"database/sql"
+	"github.com/Fuonder/goptherstore.git/internal/accounts"
+	"github.com/Fuonder/goptherstore.git/internal/admin"
+	"github.com/Fuonder/goptherstore.git/internal/apikeys"
 	"github.com/Fuonder/goptherstore.git/internal/auth"
+	"github.com/Fuonder/goptherstore.git/internal/campaigns"
+	"github.com/Fuonder/goptherstore.git/internal/events"
+	"github.com/Fuonder/goptherstore.git/internal/mfa"
 	"github.com/Fuonder/goptherstore.git/internal/models"
 	"github.com/Fuonder/goptherstore.git/internal/orders"
+	"github.com/Fuonder/goptherstore.git/internal/passwords"
+	"github.com/Fuonder/goptherstore.git/internal/referrals"
+	"github.com/Fuonder/goptherstore.git/internal/sessions"
+	"github.com/Fuonder/goptherstore.git/internal/throttling"
+	"github.com/Fuonder/goptherstore.git/internal/tiers"
 	"github.com/Fuonder/goptherstore.git/internal/users"
 	"github.com/Fuonder/goptherstore.git/internal/wallets"
+	"github.com/Fuonder/goptherstore.git/internal/webhooks"
 	"sync"
 )
 
+type Config struct {
+	Throttle  throttling.Config
+	Policy    auth.PolicyConfig
+	Hashing   passwords.Config
+	MFA       mfa.Config
+	Accounts  accounts.Config
+	Events    events.Config
+	Webhooks  webhooks.Config
+	Orders    orders.ValidatorConfig
+	Wallets   wallets.Config
+	Points    orders.Config
+	Tiers     tiers.Config
+	Referrals referrals.Config
+}
+
 type DatabaseServices struct {
-	UserSrv   users.UserService
-	WalletSrv wallets.WalletService
-	OrderSrv  orders.OrderService
-	AuthSrv   auth.AuthService
+	UserSrv    users.UserService
+	WalletSrv  wallets.WalletService
+	OrderSrv   orders.OrderService
+	AuthSrv    auth.AuthService
+	MFASrv     mfa.MFAService
+	AdminSrv   admin.AdminService
+	KeySrv     apikeys.APIKeyService
+	SessSrv    sessions.SessionService
+	AccSrv     accounts.AccountService
+	EventHub   events.EventHub
+	WebhookSrv webhooks.WebhookService
+	TierSrv    tiers.TierService
+	CampSrv    campaigns.CampaignService
+	RefSrv     referrals.ReferralService
+	// EventRelay is nil unless cross-replica notifications are enabled.
+	EventRelay *events.PGRelay
 }
 
-func NewDatabaseServices(jobsCh chan models.MartOrder, secret []byte, db *sql.DB, mu *sync.RWMutex) (*DatabaseServices, error) {
+func NewDatabaseServices(jobsCh chan models.MartOrder, secret []byte, db *sql.DB, mu *sync.RWMutex, cfg Config) (*DatabaseServices, error) {
 	s := &DatabaseServices{}
 
 	// user -> wallet -> order -> auth
 
-	DBUsers, err := users.NewDBUsers(db, mu)
+	hasher, err := passwords.NewManager(cfg.Hashing)
+	if err != nil {
+		return s, err
+	}
+
+	DBUsers, err := users.NewDBUsers(db, mu, hasher)
 	if err != nil {
 		return s, err
 	}
 
 	s.UserSrv = users.NewUService(DBUsers)
 
+	hub := events.NewHub()
+	if cfg.Events.Notify {
+		s.EventRelay = events.NewPGRelay(db, cfg.Events.DSN, cfg.Events.Channel, hub)
+		hub.SetRelay(s.EventRelay)
+	}
+	s.EventHub = hub
+
+	DBWebhooks, err := webhooks.NewDBWebhooks(db, mu)
+	if err != nil {
+		return s, err
+	}
+
+	s.WebhookSrv = webhooks.NewWHService(DBWebhooks, cfg.Webhooks)
+
+	publisher := events.Fanout{hub, s.WebhookSrv}
+
+	validator, err := orders.NewValidator(cfg.Orders)
+	if err != nil {
+		return s, err
+	}
+
 	DBWallets, err := wallets.NewDBWallets(db, mu)
 	if err != nil {
 		return s, err
 	}
 
-	s.WalletSrv = wallets.NewWService(DBWallets)
+	s.WalletSrv = wallets.NewWService(DBWallets, publisher, validator, cfg.Wallets)
+
+	DBTiers, err := tiers.NewDBTiers(db, mu)
+	if err != nil {
+		return s, err
+	}
+
+	s.TierSrv, err = tiers.NewTService(DBTiers, cfg.Tiers)
+	if err != nil {
+		return s, err
+	}
+
+	DBCampaigns, err := campaigns.NewDBCampaigns(db, mu)
+	if err != nil {
+		return s, err
+	}
+
+	s.CampSrv = campaigns.NewCService(DBCampaigns, DBWallets, publisher, campaigns.Config{PointsTTL: cfg.Points.PointsTTL})
+
+	DBReferrals, err := referrals.NewDBReferrals(db, mu)
+	if err != nil {
+		return s, err
+	}
+
+	s.RefSrv = referrals.NewRService(DBReferrals, DBWallets, publisher, cfg.Referrals)
 
 	DBOrders, err := orders.NewDBOrders(db, mu)
 	if err != nil {
 		return s, err
 	}
 
-	s.OrderSrv = orders.NewOService(DBOrders, DBWallets, jobsCh)
+	s.OrderSrv = orders.NewOService(DBOrders, DBWallets, jobsCh, publisher, validator, s.TierSrv, s.CampSrv, s.RefSrv, cfg.Points)
+
+	DBAPIKeys, err := apikeys.NewDBAPIKeys(db, mu)
+	if err != nil {
+		return s, err
+	}
+
+	s.KeySrv = apikeys.NewKService(DBAPIKeys)
+
+	s.AdminSrv = admin.NewAdmService(DBUsers, DBWallets, s.OrderSrv, s.KeySrv, publisher)
+
+	DBAuth, err := auth.NewDBAuth(db, mu, hasher)
+	if err != nil {
+		return s, err
+	}
+
+	DBThrottle, err := throttling.NewDBThrottle(db, mu)
+	if err != nil {
+		return s, err
+	}
+
+	throttle := throttling.NewTService(DBThrottle, cfg.Throttle)
+
+	policy, err := auth.NewPolicy(cfg.Policy)
+	if err != nil {
+		return s, err
+	}
+
+	DBMFA, err := mfa.NewDBMFA(db, mu)
+	if err != nil {
+		return s, err
+	}
+
+	mfaSrv, err := mfa.NewMService(DBMFA, DBUsers, throttle, secret, cfg.MFA)
+	if err != nil {
+		return s, err
+	}
+
+	s.MFASrv = mfaSrv
+
+	DBSessions, err := sessions.NewDBSessions(db, mu)
+	if err != nil {
+		return s, err
+	}
+
+	s.SessSrv = sessions.NewSService(DBSessions)
+
+	s.AuthSrv = auth.NewAService(DBUsers, DBWallets, DBAuth, throttle, policy, mfaSrv, s.SessSrv, s.RefSrv, secret)
 
-	DBAuth, err := auth.NewDBAuth(db, mu)
+	DBAccounts, err := accounts.NewDBAccounts(db, mu)
 	if err != nil {
 		return s, err
 	}
 
-	s.AuthSrv = auth.NewAService(DBUsers, DBWallets, DBAuth, secret)
+	s.AccSrv = accounts.NewACService(DBAccounts, DBUsers, DBWallets, DBOrders, s.AuthSrv, s.SessSrv, cfg.Accounts)
 
 	return s, nil
 }
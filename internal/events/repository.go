@@ -0,0 +1,103 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	NotifyQuery = `SELECT pg_notify($1, $2);`
+
+	DefaultChannel   = "gophermart_events"
+	maxReconnectWait = 30 * time.Second
+)
+
+type Config struct {
+	Notify  bool
+	DSN     string
+	Channel string
+}
+
+// wireEvent keeps the recipient, which models.Event hides from clients.
+type wireEvent struct {
+	UserID int          `json:"user_id"`
+	Event  models.Event `json:"event"`
+}
+
+// PGRelay fans events out to every replica with Postgres LISTEN/NOTIFY.
+type PGRelay struct {
+	db      *sql.DB
+	dsn     string
+	channel string
+	hub     *Hub
+}
+
+func NewPGRelay(db *sql.DB, dsn string, channel string, hub *Hub) *PGRelay {
+	return &PGRelay{db: db, dsn: dsn, channel: channel, hub: hub}
+}
+
+func (p *PGRelay) Send(ctx context.Context, event models.Event) error {
+	payload, err := json.Marshal(wireEvent{UserID: event.UserID, Event: event})
+	if err != nil {
+		return err
+	}
+	_, err = p.db.ExecContext(ctx, NotifyQuery, p.channel, string(payload))
+	if err != nil {
+		return fmt.Errorf("failed to notify: %w", err)
+	}
+	return nil
+}
+
+// Run listens on a dedicated connection and reconnects until ctx is done.
+func (p *PGRelay) Run(ctx context.Context) error {
+	wait := time.Second
+	for {
+		err := p.listen(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		logger.Log.Warn("event listener disconnected", zap.Error(err), zap.Duration("retry_in", wait))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, maxReconnectWait)
+	}
+}
+
+func (p *PGRelay) listen(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, p.dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	_, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{p.channel}.Sanitize())
+	if err != nil {
+		return err
+	}
+	logger.Log.Info("listening for events", zap.String("channel", p.channel))
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var wire wireEvent
+		err = json.Unmarshal([]byte(notification.Payload), &wire)
+		if err != nil {
+			logger.Log.Warn("malformed event notification", zap.Error(err))
+			continue
+		}
+		wire.Event.UserID = wire.UserID
+		p.hub.Dispatch(wire.Event)
+	}
+}
@@ -0,0 +1,30 @@
+package events
+
+import (
+	"context"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+)
+
+type Publisher interface {
+	Publish(ctx context.Context, event models.Event)
+}
+
+type Subscriber interface {
+	Subscribe(UID int) (events <-chan models.Event, cancel func())
+}
+
+type EventHub interface {
+	Publisher
+	Subscriber
+}
+
+// Fanout hands every event to each of its publishers in order, all of
+// them see the same event ID.
+type Fanout []Publisher
+
+func (f Fanout) Publish(ctx context.Context, event models.Event) {
+	event = stamp(event)
+	for _, p := range f {
+		p.Publish(ctx, event)
+	}
+}
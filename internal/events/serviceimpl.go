@@ -0,0 +1,109 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const subscriberBuffer = 32
+
+// Relay carries events between replicas. Every published event comes back
+// through the relay, including on the replica that sent it.
+type Relay interface {
+	Send(ctx context.Context, event models.Event) error
+}
+
+type Hub struct {
+	mu    sync.RWMutex
+	subs  map[int]map[chan models.Event]struct{}
+	relay Relay
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[int]map[chan models.Event]struct{})}
+}
+
+func (h *Hub) SetRelay(relay Relay) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.relay = relay
+}
+
+func (h *Hub) Subscribe(UID int) (<-chan models.Event, func()) {
+	ch := make(chan models.Event, subscriberBuffer)
+	h.mu.Lock()
+	if h.subs[UID] == nil {
+		h.subs[UID] = make(map[chan models.Event]struct{})
+	}
+	h.subs[UID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			delete(h.subs[UID], ch)
+			if len(h.subs[UID]) == 0 {
+				delete(h.subs, UID)
+			}
+			close(ch)
+		})
+	}
+}
+
+// Publish never blocks the caller: with a relay the event goes out through
+// it, and falls back to local delivery when the relay is unavailable.
+func (h *Hub) Publish(ctx context.Context, event models.Event) {
+	event = stamp(event)
+
+	h.mu.RLock()
+	relay := h.relay
+	h.mu.RUnlock()
+	if relay != nil {
+		err := relay.Send(ctx, event)
+		if err == nil {
+			return
+		}
+		logger.Log.Warn("can not relay event, delivering locally", zap.String("type", event.Type), zap.Error(err))
+	}
+	h.Dispatch(event)
+}
+
+// Dispatch delivers to subscribers on this replica. Subscribers that fall
+// behind lose events rather than stall the publisher.
+func (h *Hub) Dispatch(event models.Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for ch := range h.subs[event.UserID] {
+		select {
+		case ch <- event:
+		default:
+			logger.Log.Debug("event dropped for slow subscriber", zap.Int("uid", event.UserID), zap.String("type", event.Type))
+		}
+	}
+}
+
+func stamp(event models.Event) models.Event {
+	if event.ID == "" {
+		event.ID = newEventID()
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	return event
+}
+
+func newEventID() string {
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(raw)
+}
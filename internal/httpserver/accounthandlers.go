@@ -0,0 +1,207 @@
+package httpserver
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ExportAccountHandler returns everything stored about the caller. A ZIP
+// archive is sent for Accept: application/zip or ?format=zip, JSON otherwise.
+func (h Handlers) ExportAccountHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("ExportAccountHandler called")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	export, err := h.accSrv.Export(ctx, UID)
+	if err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			SendResponse(rw, http.StatusNotFound, []byte{})
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	stamp := export.ExportedAt.Format("20060102-150405")
+	if r.URL.Query().Get("format") == "zip" || strings.Contains(r.Header.Get("Accept"), "application/zip") {
+		archive, err := exportArchive(export)
+		if err != nil {
+			SendResponse(rw, http.StatusInternalServerError, []byte{})
+			return
+		}
+		rw.Header().Set("Content-Type", "application/zip")
+		rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"export-%s.zip\"", stamp))
+		SendResponse(rw, http.StatusOK, archive)
+		return
+	}
+
+	resp, err := json.MarshalIndent(export, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"export-%s.json\"", stamp))
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+func exportArchive(export models.AccountExport) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", export.Profile},
+		{"wallet.json", export.Wallet},
+		{"orders.json", export.Orders},
+		{"withdrawals.json", export.Withdrawals},
+		{"ledger.json", export.Ledger},
+	}
+	for _, file := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: export.ExportedAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+		content, err := json.MarshalIndent(file.data, "", "    ")
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(content); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (h Handlers) DeleteAccountHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("DeleteAccountHandler called")
+	if r.Header.Get("Content-Type") != "application/json" {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	var req models.DeletionRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Password == "" {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	current, err := h.getSessionID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	schedule, err := h.accSrv.RequestDeletion(ctx, UID, req.Password, current, clientInfo(r))
+	if err != nil {
+		var lockErr *models.LockoutError
+		if errors.As(err, &lockErr) {
+			setRetryAfter(rw, lockErr.RetryAfter)
+			SendResponse(rw, http.StatusTooManyRequests, []byte{})
+			return
+		}
+		if errors.Is(err, models.ErrWrongCredentials) {
+			SendResponse(rw, http.StatusUnauthorized, []byte{})
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	resp, err := json.MarshalIndent(schedule, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	SendResponse(rw, http.StatusAccepted, resp)
+}
+
+func (h Handlers) GetAccountDeletionHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("GetAccountDeletionHandler called")
+	rw.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	schedule, err := h.accSrv.GetDeletion(ctx, UID)
+	if err != nil {
+		if errors.Is(err, models.ErrDeletionNotScheduled) {
+			SendResponse(rw, http.StatusNotFound, []byte{})
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	resp, err := json.MarshalIndent(schedule, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+func (h Handlers) CancelAccountDeletionHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("CancelAccountDeletionHandler called")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	err = h.accSrv.CancelDeletion(ctx, UID)
+	if err != nil {
+		if errors.Is(err, models.ErrDeletionNotScheduled) {
+			SendResponse(rw, http.StatusNotFound, []byte{})
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, []byte{})
+}
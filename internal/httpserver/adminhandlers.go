@@ -0,0 +1,320 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (h Handlers) AdminFindUserHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("AdminFindUserHandler called")
+	rw.Header().Set("Content-Type", "application/json")
+
+	login := r.URL.Query().Get("login")
+	if login == "" {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := h.adminSrv.FindUser(ctx, login)
+	if err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			SendResponse(rw, http.StatusNotFound, []byte{})
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	resp, err := json.MarshalIndent(user, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+func (h Handlers) AdminGetUserHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("AdminGetUserHandler called")
+	rw.Header().Set("Content-Type", "application/json")
+
+	UID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := h.adminSrv.GetUser(ctx, UID)
+	if err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			SendResponse(rw, http.StatusNotFound, []byte{})
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	resp, err := json.MarshalIndent(user, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+func (h Handlers) AdminGetUserOrdersHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("AdminGetUserOrdersHandler called")
+	rw.Header().Set("Content-Type", "application/json")
+
+	UID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ord, err := h.adminSrv.GetUserOrders(ctx, UID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoData) {
+			SendResponse(rw, http.StatusNoContent, []byte{})
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	resp, err := json.MarshalIndent(ord, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+func (h Handlers) AdminGetUserWithdrawalsHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("AdminGetUserWithdrawalsHandler called")
+	rw.Header().Set("Content-Type", "application/json")
+
+	UID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	withdrawals, err := h.adminSrv.GetUserWithdrawals(ctx, UID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoData) {
+			SendResponse(rw, http.StatusNoContent, []byte{})
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	resp, err := json.MarshalIndent(withdrawals, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+func (h Handlers) AdminAdjustBalanceHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("AdminAdjustBalanceHandler called")
+	if r.Header.Get("Content-Type") != "application/json" {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	UID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	var adjustment models.BalanceAdjustment
+
+	err = json.NewDecoder(r.Body).Decode(&adjustment)
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	adminID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	adjustment.UserID = UID
+	adjustment.AdminID = adminID
+
+	err = h.adminSrv.AdjustBalance(ctx, adjustment)
+	if err != nil {
+		if errors.Is(err, models.ErrReasonRequired) || errors.Is(err, models.ErrInvalidAmount) {
+			SendResponse(rw, http.StatusUnprocessableEntity, []byte(err.Error()))
+			return
+		} else if errors.Is(err, models.ErrNotEnoughBonuses) {
+			SendResponse(rw, http.StatusConflict, []byte(err.Error()))
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, []byte{})
+}
+
+func (h Handlers) AdminSetRoleHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("AdminSetRoleHandler called")
+	if r.Header.Get("Content-Type") != "application/json" {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	UID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	var change models.RoleChange
+
+	err = json.NewDecoder(r.Body).Decode(&change)
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = h.adminSrv.SetRole(ctx, UID, change.Role)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidRole) {
+			SendResponse(rw, http.StatusUnprocessableEntity, []byte(err.Error()))
+			return
+		} else if errors.Is(err, models.ErrUserNotFound) {
+			SendResponse(rw, http.StatusNotFound, []byte{})
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, []byte{})
+}
+
+func (h Handlers) AdminRequeueOrderHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("AdminRequeueOrderHandler called")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := h.adminSrv.RequeueOrder(ctx, chi.URLParam(r, "number"))
+	if err != nil {
+		if errors.Is(err, models.ErrOrderNotFound) {
+			SendResponse(rw, http.StatusNotFound, []byte{})
+			return
+		} else if errors.Is(err, models.ErrOrderFinalized) || errors.Is(err, models.ErrOrderInProgress) {
+			SendResponse(rw, http.StatusConflict, []byte(err.Error()))
+			return
+		} else if errors.Is(err, models.ErrQueueFull) {
+			SendResponse(rw, http.StatusServiceUnavailable, []byte(err.Error()))
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusAccepted, []byte{})
+}
+
+func (h Handlers) AdminCompleteWithdrawalHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("AdminCompleteWithdrawalHandler called")
+	h.settleWithdrawal(rw, r, models.WithdrawalStatusCompleted)
+}
+
+func (h Handlers) AdminFailWithdrawalHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("AdminFailWithdrawalHandler called")
+	h.settleWithdrawal(rw, r, models.WithdrawalStatusFailed)
+}
+
+func (h Handlers) AdminReverseWithdrawalHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("AdminReverseWithdrawalHandler called")
+	h.settleWithdrawal(rw, r, models.WithdrawalStatusReversed)
+}
+
+func (h Handlers) settleWithdrawal(rw http.ResponseWriter, r *http.Request, status string) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	ID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	var transition models.WithdrawalTransition
+	if r.ContentLength != 0 {
+		err = json.NewDecoder(r.Body).Decode(&transition)
+		if err != nil {
+			SendResponse(rw, http.StatusBadRequest, []byte{})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var withdrawal models.Withdrawal
+	switch status {
+	case models.WithdrawalStatusCompleted:
+		withdrawal, err = h.walletSrv.CompleteWithdrawal(ctx, ID)
+	case models.WithdrawalStatusFailed:
+		withdrawal, err = h.walletSrv.FailWithdrawal(ctx, ID, transition.Reason)
+	case models.WithdrawalStatusReversed:
+		var adminID int
+		adminID, err = h.getUserID(ctx, r)
+		if err != nil {
+			SendResponse(rw, http.StatusInternalServerError, []byte{})
+			return
+		}
+		withdrawal, err = h.walletSrv.ReverseWithdrawal(ctx, ID, adminID, transition.Reason)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrWithdrawalNotFound) {
+			SendResponse(rw, http.StatusNotFound, []byte{})
+			return
+		} else if errors.Is(err, models.ErrInvalidTransition) {
+			SendResponse(rw, http.StatusConflict, []byte(err.Error()))
+			return
+		} else if errors.Is(err, models.ErrReasonRequired) {
+			SendResponse(rw, http.StatusUnprocessableEntity, []byte(err.Error()))
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	resp, err := json.MarshalIndent(withdrawal, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
@@ -0,0 +1,204 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (h Handlers) RedeemPromoHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("RedeemPromoHandler called")
+	if r.Header.Get("Content-Type") != "application/json" {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+
+	var req models.PromoRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	credit, err := h.campSrv.RedeemCode(ctx, UID, req.Code)
+	if err != nil {
+		sendCampaignError(rw, err)
+		return
+	}
+	resp, err := json.MarshalIndent(credit, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+func (h Handlers) AdminCreateCampaignHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("AdminCreateCampaignHandler called")
+	if r.Header.Get("Content-Type") != "application/json" {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+
+	var campaign models.Campaign
+
+	err := json.NewDecoder(r.Body).Decode(&campaign)
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	campaign, err = h.campSrv.CreateCampaign(ctx, campaign)
+	if err != nil {
+		sendCampaignError(rw, err)
+		return
+	}
+	resp, err := json.MarshalIndent(campaign, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusCreated, resp)
+}
+
+func (h Handlers) AdminGetCampaignsHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("AdminGetCampaignsHandler called")
+	rw.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	campaigns, err := h.campSrv.GetCampaigns(ctx)
+	if err != nil {
+		sendCampaignError(rw, err)
+		return
+	}
+	resp, err := json.MarshalIndent(campaigns, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+func (h Handlers) AdminGetCampaignHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("AdminGetCampaignHandler called")
+	rw.Header().Set("Content-Type", "application/json")
+
+	ID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	campaign, err := h.campSrv.GetCampaign(ctx, ID)
+	if err != nil {
+		sendCampaignError(rw, err)
+		return
+	}
+	resp, err := json.MarshalIndent(campaign, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+func (h Handlers) AdminUpdateCampaignHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("AdminUpdateCampaignHandler called")
+	if r.Header.Get("Content-Type") != "application/json" {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+
+	ID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	var campaign models.Campaign
+
+	err = json.NewDecoder(r.Body).Decode(&campaign)
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	campaign, err = h.campSrv.UpdateCampaign(ctx, ID, campaign)
+	if err != nil {
+		sendCampaignError(rw, err)
+		return
+	}
+	resp, err := json.MarshalIndent(campaign, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+func (h Handlers) AdminDeleteCampaignHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("AdminDeleteCampaignHandler called")
+
+	ID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = h.campSrv.DeleteCampaign(ctx, ID)
+	if err != nil {
+		sendCampaignError(rw, err)
+		return
+	}
+	SendResponse(rw, http.StatusOK, []byte{})
+}
+
+func sendCampaignError(rw http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrNoData):
+		SendResponse(rw, http.StatusNoContent, []byte{})
+	case errors.Is(err, models.ErrInvalidCampaign):
+		SendResponse(rw, http.StatusBadRequest, []byte(err.Error()))
+	case errors.Is(err, models.ErrCampaignNotFound), errors.Is(err, models.ErrInvalidPromoCode):
+		SendResponse(rw, http.StatusNotFound, []byte(err.Error()))
+	case errors.Is(err, models.ErrPromoNotActive):
+		SendResponse(rw, http.StatusUnprocessableEntity, []byte(err.Error()))
+	case errors.Is(err, models.ErrPromoLimitReached):
+		SendResponse(rw, http.StatusConflict, []byte(err.Error()))
+	default:
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+	}
+}
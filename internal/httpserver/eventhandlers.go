@@ -0,0 +1,71 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+const sseHeartbeat = 25 * time.Second
+
+// EventsHandler streams the caller's events as Server-Sent Events until the
+// client goes away.
+func (h Handlers) EventsHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("EventsHandler called")
+
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	UID, err := h.getUserID(ctx, r)
+	cancel()
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	events, unsubscribe := h.eventHub.Subscribe(UID)
+	defer unsubscribe()
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Connection", "keep-alive")
+	rw.Header().Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(http.StatusOK)
+	fmt.Fprint(rw, ": connected\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(rw, ": ping\n\n")
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				logger.Log.Error("can not marshal event", zap.Error(err))
+				continue
+			}
+			_, err = fmt.Fprintf(rw, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -5,32 +5,69 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/accounts"
+	"github.com/Fuonder/goptherstore.git/internal/admin"
+	"github.com/Fuonder/goptherstore.git/internal/apikeys"
 	"github.com/Fuonder/goptherstore.git/internal/auth"
+	"github.com/Fuonder/goptherstore.git/internal/campaigns"
 	"github.com/Fuonder/goptherstore.git/internal/dbservices"
+	"github.com/Fuonder/goptherstore.git/internal/events"
 	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/mfa"
 	"github.com/Fuonder/goptherstore.git/internal/models"
 	"github.com/Fuonder/goptherstore.git/internal/orders"
+	"github.com/Fuonder/goptherstore.git/internal/referrals"
+	"github.com/Fuonder/goptherstore.git/internal/sessions"
+	"github.com/Fuonder/goptherstore.git/internal/tiers"
 	"github.com/Fuonder/goptherstore.git/internal/users"
 	"github.com/Fuonder/goptherstore.git/internal/wallets"
+	"github.com/Fuonder/goptherstore.git/internal/webhooks"
+	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 	"io"
+	"math"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
-	"unicode"
 )
 
 type Handlers struct {
-	userSrv   users.UserService
-	walletSrv wallets.WalletService
-	orderSrv  orders.OrderService
-	authSrv   auth.AuthService
+	userSrv    users.UserService
+	walletSrv  wallets.WalletService
+	orderSrv   orders.OrderService
+	authSrv    auth.AuthService
+	mfaSrv     mfa.MFAService
+	adminSrv   admin.AdminService
+	keySrv     apikeys.APIKeyService
+	sessSrv    sessions.SessionService
+	accSrv     accounts.AccountService
+	eventHub   events.EventHub
+	webhookSrv webhooks.WebhookService
+	tierSrv    tiers.TierService
+	campSrv    campaigns.CampaignService
+	refSrv     referrals.ReferralService
 }
 
+type principalKey struct{}
+
 func NewHandlers(DBServices *dbservices.DatabaseServices) *Handlers {
 	return &Handlers{userSrv: DBServices.UserSrv,
-		walletSrv: DBServices.WalletSrv,
-		orderSrv:  DBServices.OrderSrv,
-		authSrv:   DBServices.AuthSrv}
+		walletSrv:  DBServices.WalletSrv,
+		orderSrv:   DBServices.OrderSrv,
+		authSrv:    DBServices.AuthSrv,
+		mfaSrv:     DBServices.MFASrv,
+		adminSrv:   DBServices.AdminSrv,
+		keySrv:     DBServices.KeySrv,
+		sessSrv:    DBServices.SessSrv,
+		accSrv:     DBServices.AccSrv,
+		eventHub:   DBServices.EventHub,
+		webhookSrv: DBServices.WebhookSrv,
+		tierSrv:    DBServices.TierSrv,
+		campSrv:    DBServices.CampSrv,
+		refSrv:     DBServices.RefSrv}
 }
 
 func (h Handlers) RootHandler(rw http.ResponseWriter, r *http.Request) {
@@ -56,22 +93,20 @@ func (h Handlers) RegisterHandler(rw http.ResponseWriter, r *http.Request) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	token, err := h.authSrv.Register(ctx, newUser)
+	token, err := h.authSrv.Register(ctx, newUser, clientInfo(r))
 	if err != nil {
 		if errors.Is(err, models.ErrUserAlreadyExists) {
 			SendResponse(rw, http.StatusConflict, []byte{})
 			return
 		}
+		if errors.Is(err, models.ErrPolicyViolation) || errors.Is(err, models.ErrInvalidReferralCode) {
+			SendResponse(rw, http.StatusBadRequest, []byte(err.Error()))
+			return
+		}
 		SendResponse(rw, http.StatusInternalServerError, []byte{})
 		return
 	}
-	http.SetCookie(rw, &http.Cookie{
-		Name:     "auth_token",
-		Value:    token,
-		HttpOnly: true,
-		SameSite: http.SameSiteStrictMode,
-		Path:     "/",
-	})
+	setAuthCookie(rw, token)
 	SendResponse(rw, http.StatusOK, []byte("User created successfully"))
 }
 func (h Handlers) LoginHandler(rw http.ResponseWriter, r *http.Request) {
@@ -91,9 +126,15 @@ func (h Handlers) LoginHandler(rw http.ResponseWriter, r *http.Request) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	token, err := h.authSrv.Login(ctx, user)
+	token, mfaRequired, err := h.authSrv.Login(ctx, user, clientInfo(r))
 	if err != nil {
 		logger.Log.Debug("error", zap.Error(err))
+		var lockErr *models.LockoutError
+		if errors.As(err, &lockErr) {
+			setRetryAfter(rw, lockErr.RetryAfter)
+			SendResponse(rw, http.StatusTooManyRequests, []byte{})
+			return
+		}
 		if errors.Is(err, models.ErrWrongCredentials) {
 			SendResponse(rw, http.StatusUnauthorized, []byte{})
 			return
@@ -101,16 +142,63 @@ func (h Handlers) LoginHandler(rw http.ResponseWriter, r *http.Request) {
 		SendResponse(rw, http.StatusInternalServerError, []byte{})
 		return
 	}
-	http.SetCookie(rw, &http.Cookie{
-		Name:     "auth_token",
-		Value:    token,
-		HttpOnly: true,
-		SameSite: http.SameSiteStrictMode,
-		Path:     "/",
-	})
+	if mfaRequired {
+		setMFACookie(rw, token)
+		SendResponse(rw, http.StatusAccepted, []byte("Second factor required"))
+		return
+	}
+	setAuthCookie(rw, token)
 
 	SendResponse(rw, http.StatusOK, []byte("User login success"))
 }
+func (h Handlers) ChangePasswordHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("ChangePasswordHandler called")
+	if r.Header.Get("Content-Type") != "application/json" {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	var change models.PasswordChange
+
+	err := json.NewDecoder(r.Body).Decode(&change)
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	token, err := h.authSrv.ChangePassword(ctx, UID, change, clientInfo(r))
+	if err != nil {
+		var lockErr *models.LockoutError
+		if errors.As(err, &lockErr) {
+			setRetryAfter(rw, lockErr.RetryAfter)
+			SendResponse(rw, http.StatusTooManyRequests, []byte{})
+			return
+		}
+		if errors.Is(err, models.ErrWrongCredentials) {
+			SendResponse(rw, http.StatusUnauthorized, []byte{})
+			return
+		}
+		if errors.Is(err, models.ErrPolicyViolation) {
+			SendResponse(rw, http.StatusBadRequest, []byte(err.Error()))
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	setAuthCookie(rw, token)
+
+	SendResponse(rw, http.StatusOK, []byte("Password changed"))
+}
+
 func (h Handlers) PostOrdersHandler(rw http.ResponseWriter, r *http.Request) {
 	logger.Log.Debug("PostOrdersHandler called")
 	if r.Header.Get("Content-Type") != "text/plain" {
@@ -125,11 +213,6 @@ func (h Handlers) PostOrdersHandler(rw http.ResponseWriter, r *http.Request) {
 	}
 	logger.Log.Info("GOT ORDER", zap.String("order", string(orderNumberBytes)))
 
-	if ok := isValidLuhn(string(orderNumberBytes)); !ok {
-		SendResponse(rw, http.StatusUnprocessableEntity, []byte{})
-		return
-	}
-
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -147,6 +230,9 @@ func (h Handlers) PostOrdersHandler(rw http.ResponseWriter, r *http.Request) {
 		} else if errors.Is(err, models.ErrOrderOfOtherUser) {
 			SendResponse(rw, http.StatusConflict, []byte{})
 			return
+		} else if errors.Is(err, models.ErrInvalidOrderNumber) {
+			SendResponse(rw, http.StatusUnprocessableEntity, []byte(err.Error()))
+			return
 		}
 		SendResponse(rw, http.StatusInternalServerError, []byte{})
 		return
@@ -155,6 +241,85 @@ func (h Handlers) PostOrdersHandler(rw http.ResponseWriter, r *http.Request) {
 	SendResponse(rw, http.StatusAccepted, []byte{})
 }
 
+// maxOrderBatch caps the numbers accepted by one batch upload,
+// maxOrderBatchBytes the body carrying them.
+const (
+	maxOrderBatch      = 1000
+	maxOrderBatchBytes = maxOrderBatch * 64
+)
+
+// PostOrdersBatchHandler accepts a JSON array or newline-separated text of
+// order numbers and reports the outcome of each one.
+func (h Handlers) PostOrdersBatchHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("PostOrdersBatchHandler called")
+
+	r.Body = http.MaxBytesReader(rw, r.Body, maxOrderBatchBytes)
+	var numbers []string
+	var tooLarge *http.MaxBytesError
+	switch r.Header.Get("Content-Type") {
+	case "application/json":
+		err := json.NewDecoder(r.Body).Decode(&numbers)
+		if errors.As(err, &tooLarge) {
+			SendResponse(rw, http.StatusRequestEntityTooLarge, []byte{})
+			return
+		}
+		if err != nil {
+			SendResponse(rw, http.StatusBadRequest, []byte{})
+			return
+		}
+	case "text/plain":
+		body, err := io.ReadAll(r.Body)
+		if errors.As(err, &tooLarge) {
+			SendResponse(rw, http.StatusRequestEntityTooLarge, []byte{})
+			return
+		}
+		if err != nil {
+			SendResponse(rw, http.StatusUnprocessableEntity, []byte{})
+			return
+		}
+		for _, line := range strings.Split(string(body), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" {
+				numbers = append(numbers, line)
+			}
+		}
+	default:
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+	if len(numbers) == 0 {
+		SendResponse(rw, http.StatusBadRequest, []byte("no order numbers"))
+		return
+	}
+	if len(numbers) > maxOrderBatch {
+		SendResponse(rw, http.StatusRequestEntityTooLarge, []byte(fmt.Sprintf("at most %d order numbers per batch", maxOrderBatch)))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	results, err := h.orderSrv.RegisterOrders(ctx, numbers, UID)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	resp, err := json.MarshalIndent(results, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
+
 func (h Handlers) GetOrdersHandler(rw http.ResponseWriter, r *http.Request) {
 	logger.Log.Debug("GetOrdersHandler called")
 	rw.Header().Set("Content-Type", "application/json")
@@ -162,21 +327,33 @@ func (h Handlers) GetOrdersHandler(rw http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	page, err := parsePageQuery(r)
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+	filter := models.OrderFilter{PageQuery: page, Status: r.URL.Query().Get("status")}
+
 	UID, err := h.getUserID(ctx, r)
 	if err != nil {
 		SendResponse(rw, http.StatusInternalServerError, []byte{})
 		return
 	}
 
-	ord, err := h.orderSrv.GetOrdersByUID(ctx, UID)
+	ord, next, err := h.orderSrv.FindOrders(ctx, UID, filter)
 	if err != nil {
 		if errors.Is(err, models.ErrNoData) {
 			SendResponse(rw, http.StatusNoContent, []byte{})
 			return
 		}
+		if errors.Is(err, models.ErrInvalidFilter) {
+			SendResponse(rw, http.StatusBadRequest, []byte(err.Error()))
+			return
+		}
 		SendResponse(rw, http.StatusInternalServerError, []byte{})
 		return
 	}
+	setNextPage(rw, r, next)
 
 	resp, err := json.MarshalIndent(ord, "", "    ")
 	if err != nil {
@@ -185,6 +362,41 @@ func (h Handlers) GetOrdersHandler(rw http.ResponseWriter, r *http.Request) {
 	}
 	SendResponse(rw, http.StatusOK, resp)
 }
+func (h Handlers) GetOrderHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("GetOrderHandler called")
+	rw.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	order, err := h.orderSrv.GetOrderDetail(ctx, UID, chi.URLParam(r, "number"))
+	if err != nil {
+		if errors.Is(err, models.ErrOrderNotFound) {
+			SendResponse(rw, http.StatusNotFound, []byte{})
+			return
+		}
+		if errors.Is(err, models.ErrOrderOfOtherUser) {
+			SendResponse(rw, http.StatusForbidden, []byte{})
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	resp, err := json.MarshalIndent(order, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
+
 func (h Handlers) GetBalanceHandler(rw http.ResponseWriter, r *http.Request) {
 	logger.Log.Debug("GetBalanceHandler called")
 	rw.Header().Set("Content-Type", "application/json")
@@ -203,6 +415,12 @@ func (h Handlers) GetBalanceHandler(rw http.ResponseWriter, r *http.Request) {
 		SendResponse(rw, http.StatusInternalServerError, []byte{})
 		return
 	}
+	tier, err := h.tierSrv.GetStatus(ctx, UID)
+	if err != nil {
+		logger.Log.Warn("can not get tier status", zap.Int("uid", UID), zap.Error(err))
+	} else {
+		wallet.Tier = &tier
+	}
 
 	resp, err := json.MarshalIndent(wallet, "", "    ")
 	if err != nil {
@@ -224,11 +442,6 @@ func (h Handlers) PostWithdrawHandler(rw http.ResponseWriter, r *http.Request) {
 		SendResponse(rw, http.StatusBadRequest, []byte{})
 		return
 	}
-	if ok := isValidLuhn(withdraw.OrderID); !ok {
-		SendResponse(rw, http.StatusUnprocessableEntity, []byte{})
-		return
-	}
-
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -240,13 +453,26 @@ func (h Handlers) PostWithdrawHandler(rw http.ResponseWriter, r *http.Request) {
 	withdraw.UserID = UID
 	withdraw.CreatedAt = time.Now()
 
+	err = h.mfaSrv.CheckWithdrawal(ctx, UID, withdraw.Amount, r.Header.Get("X-TOTP-Code"), clientInfo(r))
+	if err != nil {
+		sendMFACheckError(rw, err)
+		return
+	}
+
 	err = h.walletSrv.RegisterWithdraw(ctx, withdraw)
 	if err != nil {
+		var wErr *models.WithdrawalError
 		if errors.Is(err, models.ErrNotEnoughBonuses) {
 			SendResponse(rw, 402, []byte(err.Error()))
 			return
-		} else if errors.Is(err, models.ErrInvalidOrderNumber) {
-			SendResponse(rw, http.StatusUnprocessableEntity, []byte{})
+		} else if errors.As(err, &wErr) {
+			resp, err := json.MarshalIndent(wErr, "", "    ")
+			if err != nil {
+				SendResponse(rw, http.StatusInternalServerError, []byte{})
+				return
+			}
+			rw.Header().Set("Content-Type", "application/json")
+			SendResponse(rw, http.StatusUnprocessableEntity, resp)
 			return
 		}
 		SendResponse(rw, http.StatusInternalServerError, []byte{})
@@ -262,13 +488,21 @@ func (h Handlers) GetWithdrawalsHandler(rw http.ResponseWriter, r *http.Request)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	page, err := parsePageQuery(r)
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+	filter := models.WithdrawalFilter{PageQuery: page}
+	withTotals := r.URL.Query().Get("totals") == "true"
+
 	UID, err := h.getUserID(ctx, r)
 	if err != nil {
 		SendResponse(rw, http.StatusInternalServerError, []byte{})
 		return
 	}
-	withdrawals, err := h.walletSrv.GetWithdrawals(ctx, UID)
-	if err != nil {
+	withdrawals, next, err := h.walletSrv.FindWithdrawals(ctx, UID, filter)
+	if err != nil && !(withTotals && errors.Is(err, models.ErrNoData)) {
 		if errors.Is(err, models.ErrNoData) {
 			SendResponse(rw, http.StatusNoContent, []byte{})
 			return
@@ -276,8 +510,27 @@ func (h Handlers) GetWithdrawalsHandler(rw http.ResponseWriter, r *http.Request)
 		SendResponse(rw, http.StatusInternalServerError, []byte{})
 		return
 	}
+	setNextPage(rw, r, next)
+
+	// Totals change the body into an object, plain clients keep the list.
+	var body any = withdrawals
+	if withTotals {
+		totals, err := h.walletSrv.GetWithdrawalTotals(ctx, UID, filter)
+		if err != nil {
+			SendResponse(rw, http.StatusInternalServerError, []byte{})
+			return
+		}
+		result := models.WithdrawalPage{Withdrawals: withdrawals, Totals: &totals}
+		if result.Withdrawals == nil {
+			result.Withdrawals = make([]models.Withdrawal, 0)
+		}
+		if next != nil {
+			result.NextCursor = next.Encode()
+		}
+		body = result
+	}
 
-	resp, err := json.MarshalIndent(withdrawals, "", "    ")
+	resp, err := json.MarshalIndent(body, "", "    ")
 	if err != nil {
 		SendResponse(rw, http.StatusInternalServerError, []byte{})
 		return
@@ -309,7 +562,82 @@ func (h Handlers) AuthMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// ScopedAuth lets machine clients in with an API key carrying the given
+// scope. Requests without a key fall through to the cookie based AuthMiddleware.
+func (h Handlers) ScopedAuth(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			rawKey := apiKeyFromRequest(r)
+			if rawKey == "" {
+				h.AuthMiddleware(next).ServeHTTP(rw, r)
+				return
+			}
+			logger.Log.Debug("API key middleware")
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			principal, err := h.keySrv.Authenticate(ctx, rawKey)
+			if err != nil {
+				SendResponse(rw, http.StatusUnauthorized, []byte("Invalid api key"))
+				return
+			}
+			if !slices.Contains(principal.Scopes, scope) {
+				SendResponse(rw, http.StatusForbidden, []byte(models.ErrInsufficientScope.Error()))
+				return
+			}
+
+			next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+		})
+	}
+}
+
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(bearer)
+	}
+	return ""
+}
+
+// RequireRole must be mounted after AuthMiddleware, which has already
+// checked that the role claim still matches the account.
+func (h Handlers) RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return logger.HanlderWithLogger(func(rw http.ResponseWriter, r *http.Request) {
+			logger.Log.Debug("Role middleware")
+
+			cookie, err := r.Cookie("auth_token")
+			if err != nil || cookie == nil {
+				SendResponse(rw, http.StatusUnauthorized, []byte("Missing or invalid token"))
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			role, err := h.authSrv.GetRoleFromJWT(ctx, cookie.Value)
+			if err != nil {
+				SendResponse(rw, http.StatusUnauthorized, []byte("Invalid token"))
+				return
+			}
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(rw, r)
+					return
+				}
+			}
+			SendResponse(rw, http.StatusForbidden, []byte{})
+		})
+	}
+}
+
 func (h Handlers) getUserID(ctx context.Context, r *http.Request) (int, error) {
+	if principal, ok := r.Context().Value(principalKey{}).(models.Principal); ok {
+		return principal.UID, nil
+	}
 	cookie, err := r.Cookie("auth_token")
 	if err != nil {
 		if errors.Is(err, http.ErrNoCookie) {
@@ -326,31 +654,59 @@ func (h Handlers) getUserID(ctx context.Context, r *http.Request) (int, error) {
 	return UID, nil
 }
 
-func isValidLuhn(number string) bool {
-	var sum int
-	double := false
+func setAuthCookie(rw http.ResponseWriter, token string) {
+	http.SetCookie(rw, &http.Cookie{
+		Name:     "auth_token",
+		Value:    token,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/",
+	})
+}
 
-	for i := len(number) - 1; i >= 0; i-- {
-		r := rune(number[i])
+func setMFACookie(rw http.ResponseWriter, token string) {
+	http.SetCookie(rw, &http.Cookie{
+		Name:     "mfa_token",
+		Value:    token,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/api/user/login",
+	})
+}
 
-		if !unicode.IsDigit(r) {
-			return false
-		}
+func clearAuthCookie(rw http.ResponseWriter) {
+	http.SetCookie(rw, &http.Cookie{
+		Name:     "auth_token",
+		Value:    "",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/",
+		MaxAge:   -1,
+	})
+}
 
-		digit := int(r - '0')
+func clearMFACookie(rw http.ResponseWriter) {
+	http.SetCookie(rw, &http.Cookie{
+		Name:     "mfa_token",
+		Value:    "",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/api/user/login",
+		MaxAge:   -1,
+	})
+}
 
-		if double {
-			digit *= 2
-			if digit > 9 {
-				digit -= 9
-			}
-		}
+func setRetryAfter(rw http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	rw.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
 
-		sum += digit
-		double = !double
+func clientInfo(r *http.Request) models.ClientInfo {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
 	}
-
-	return sum%10 == 0
+	return models.ClientInfo{IP: ip, UserAgent: r.UserAgent()}
 }
 
 func SendResponse(rw http.ResponseWriter, status int, message []byte) {
@@ -0,0 +1,26 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPostOrdersBatchHandlerLimitsBody(t *testing.T) {
+	line := "12345678903\n"
+	body := strings.Repeat(line, maxOrderBatchBytes/len(line)+1)
+	for _, contentType := range []string{"text/plain", "application/json"} {
+		payload := body
+		if contentType == "application/json" {
+			payload = `["` + strings.Repeat(`12345678903","`, maxOrderBatchBytes/14+1) + `12345678903"]`
+		}
+		r := httptest.NewRequest(http.MethodPost, "/api/user/orders/batch", strings.NewReader(payload))
+		r.Header.Set("Content-Type", contentType)
+		rw := httptest.NewRecorder()
+		Handlers{}.PostOrdersBatchHandler(rw, r)
+		if rw.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: status %d, want %d", contentType, rw.Code, http.StatusRequestEntityTooLarge)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (h Handlers) PostHoldHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("PostHoldHandler called")
+	if r.Header.Get("Content-Type") != "application/json" {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	var req models.HoldRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	err = h.mfaSrv.CheckWithdrawal(ctx, UID, req.Amount, r.Header.Get("X-TOTP-Code"), clientInfo(r))
+	if err != nil {
+		sendMFACheckError(rw, err)
+		return
+	}
+
+	hold, err := h.walletSrv.CreateHold(ctx, UID, req)
+	if err != nil {
+		sendHoldError(rw, err)
+		return
+	}
+	sendHold(rw, http.StatusCreated, hold)
+}
+
+func (h Handlers) GetHoldsHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("GetHoldsHandler called")
+	rw.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	holds, err := h.walletSrv.GetHolds(ctx, UID)
+	if err != nil {
+		sendHoldError(rw, err)
+		return
+	}
+	resp, err := json.MarshalIndent(holds, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+func (h Handlers) CaptureHoldHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("CaptureHoldHandler called")
+	ID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	var req models.HoldCapture
+	if r.ContentLength != 0 {
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			SendResponse(rw, http.StatusBadRequest, []byte{})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	hold, err := h.walletSrv.CaptureHold(ctx, UID, ID, req)
+	if err != nil {
+		sendHoldError(rw, err)
+		return
+	}
+	sendHold(rw, http.StatusOK, hold)
+}
+
+func (h Handlers) ReleaseHoldHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("ReleaseHoldHandler called")
+	ID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	hold, err := h.walletSrv.ReleaseHold(ctx, UID, ID)
+	if err != nil {
+		sendHoldError(rw, err)
+		return
+	}
+	sendHold(rw, http.StatusOK, hold)
+}
+
+func sendHold(rw http.ResponseWriter, status int, hold models.BalanceHold) {
+	resp, err := json.MarshalIndent(hold, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	SendResponse(rw, status, resp)
+}
+
+func sendHoldError(rw http.ResponseWriter, err error) {
+	var wErr *models.WithdrawalError
+	switch {
+	case errors.As(err, &wErr):
+		resp, err := json.MarshalIndent(wErr, "", "    ")
+		if err != nil {
+			SendResponse(rw, http.StatusInternalServerError, []byte{})
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		SendResponse(rw, http.StatusUnprocessableEntity, resp)
+	case errors.Is(err, models.ErrNoData):
+		SendResponse(rw, http.StatusNoContent, []byte{})
+	case errors.Is(err, models.ErrInvalidHold):
+		SendResponse(rw, http.StatusBadRequest, []byte(err.Error()))
+	case errors.Is(err, models.ErrHoldNotFound):
+		SendResponse(rw, http.StatusNotFound, []byte(err.Error()))
+	case errors.Is(err, models.ErrHoldNotActive):
+		SendResponse(rw, http.StatusConflict, []byte(err.Error()))
+	case errors.Is(err, models.ErrNotEnoughBonuses):
+		SendResponse(rw, http.StatusPaymentRequired, []byte(err.Error()))
+	default:
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+	}
+}
@@ -0,0 +1,193 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (h Handlers) CreateAPIKeyHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("CreateAPIKeyHandler called")
+	if r.Header.Get("Content-Type") != "application/json" {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	var req models.APIKeyRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	key, err := h.keySrv.CreateKey(ctx, UID, req)
+	sendCreatedKey(rw, key, err)
+}
+
+func (h Handlers) GetAPIKeysHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("GetAPIKeysHandler called")
+	rw.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	keys, err := h.keySrv.GetKeys(ctx, UID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoData) {
+			SendResponse(rw, http.StatusNoContent, []byte{})
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	resp, err := json.MarshalIndent(keys, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+func (h Handlers) RevokeAPIKeyHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("RevokeAPIKeyHandler called")
+
+	keyID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	err = h.keySrv.RevokeKey(ctx, UID, keyID)
+	if err != nil {
+		if errors.Is(err, models.ErrAPIKeyNotFound) {
+			SendResponse(rw, http.StatusNotFound, []byte{})
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, []byte{})
+}
+
+func (h Handlers) AdminCreateAPIKeyHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("AdminCreateAPIKeyHandler called")
+	if r.Header.Get("Content-Type") != "application/json" {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	UID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	var req models.APIKeyRequest
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	key, err := h.adminSrv.CreateAPIKey(ctx, UID, req)
+	sendCreatedKey(rw, key, err)
+}
+
+func (h Handlers) AdminCreateServiceAccountHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("AdminCreateServiceAccountHandler called")
+	if r.Header.Get("Content-Type") != "application/json" {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	var req models.ServiceAccountRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	account, err := h.adminSrv.CreateServiceAccount(ctx, req.Login)
+	if err != nil {
+		if errors.Is(err, models.ErrUserAlreadyExists) {
+			SendResponse(rw, http.StatusConflict, []byte{})
+			return
+		} else if errors.Is(err, models.ErrUserCreationFailed) {
+			SendResponse(rw, http.StatusBadRequest, []byte{})
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	resp, err := json.MarshalIndent(account, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	SendResponse(rw, http.StatusCreated, resp)
+}
+
+func sendCreatedKey(rw http.ResponseWriter, key models.APIKeyCreated, err error) {
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidScope) || errors.Is(err, models.ErrInvalidAPIKeyRequest) {
+			SendResponse(rw, http.StatusUnprocessableEntity, []byte(err.Error()))
+			return
+		} else if errors.Is(err, models.ErrUserNotFound) {
+			SendResponse(rw, http.StatusNotFound, []byte{})
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	resp, err := json.MarshalIndent(key, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	SendResponse(rw, http.StatusCreated, resp)
+}
@@ -0,0 +1,225 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"net/http"
+	"time"
+)
+
+func (h Handlers) LoginMFAHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("LoginMFAHandler called")
+	if r.Header.Get("Content-Type") != "application/json" {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+	cookie, err := r.Cookie("mfa_token")
+	if err != nil || cookie == nil {
+		SendResponse(rw, http.StatusUnauthorized, []byte("Missing or invalid token"))
+		return
+	}
+
+	var code models.MFACode
+
+	err = json.NewDecoder(r.Body).Decode(&code)
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	token, err := h.authSrv.CompleteMFALogin(ctx, cookie.Value, code.Code, clientInfo(r))
+	if err != nil {
+		var lockErr *models.LockoutError
+		if errors.As(err, &lockErr) {
+			setRetryAfter(rw, lockErr.RetryAfter)
+			SendResponse(rw, http.StatusTooManyRequests, []byte{})
+			return
+		}
+		if errors.Is(err, models.ErrWrongCredentials) || errors.Is(err, models.ErrInvalidMFACode) {
+			SendResponse(rw, http.StatusUnauthorized, []byte{})
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	clearMFACookie(rw)
+	setAuthCookie(rw, token)
+
+	SendResponse(rw, http.StatusOK, []byte("User login success"))
+}
+
+func (h Handlers) StartTOTPHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("StartTOTPHandler called")
+	rw.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	enrollment, err := h.mfaSrv.StartEnrollment(ctx, UID)
+	if err != nil {
+		if errors.Is(err, models.ErrMFAAlreadyEnabled) {
+			SendResponse(rw, http.StatusConflict, []byte{})
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	resp, err := json.MarshalIndent(enrollment, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+func (h Handlers) GetTOTPQRHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("GetTOTPQRHandler called")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	png, err := h.mfaSrv.GetEnrollmentQR(ctx, UID)
+	if err != nil {
+		if errors.Is(err, models.ErrMFANotEnrolled) {
+			SendResponse(rw, http.StatusNotFound, []byte{})
+			return
+		} else if errors.Is(err, models.ErrMFAAlreadyEnabled) {
+			SendResponse(rw, http.StatusConflict, []byte{})
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	rw.Header().Set("Content-Type", "image/png")
+	rw.Header().Set("Cache-Control", "no-store")
+	SendResponse(rw, http.StatusOK, png)
+}
+
+func (h Handlers) ConfirmTOTPHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("ConfirmTOTPHandler called")
+	if r.Header.Get("Content-Type") != "application/json" {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	var code models.MFACode
+
+	err := json.NewDecoder(r.Body).Decode(&code)
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	codes, err := h.mfaSrv.ConfirmEnrollment(ctx, UID, code.Code)
+	if err != nil {
+		if errors.Is(err, models.ErrMFANotEnrolled) {
+			SendResponse(rw, http.StatusNotFound, []byte{})
+			return
+		} else if errors.Is(err, models.ErrMFAAlreadyEnabled) {
+			SendResponse(rw, http.StatusConflict, []byte{})
+			return
+		} else if errors.Is(err, models.ErrInvalidMFACode) {
+			SendResponse(rw, http.StatusUnprocessableEntity, []byte(err.Error()))
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	resp, err := json.MarshalIndent(codes, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+func (h Handlers) DisableTOTPHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("DisableTOTPHandler called")
+	if r.Header.Get("Content-Type") != "application/json" {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	var code models.MFACode
+
+	err := json.NewDecoder(r.Body).Decode(&code)
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	err = h.mfaSrv.Disable(ctx, UID, code.Code, clientInfo(r))
+	if err != nil {
+		var lockErr *models.LockoutError
+		if errors.As(err, &lockErr) {
+			setRetryAfter(rw, lockErr.RetryAfter)
+			SendResponse(rw, http.StatusTooManyRequests, []byte{})
+			return
+		}
+		if errors.Is(err, models.ErrMFANotEnrolled) {
+			SendResponse(rw, http.StatusNotFound, []byte{})
+			return
+		} else if errors.Is(err, models.ErrInvalidMFACode) {
+			SendResponse(rw, http.StatusForbidden, []byte(err.Error()))
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, []byte("Second factor disabled"))
+}
+
+// sendMFACheckError answers a failed CheckWithdrawal.
+func sendMFACheckError(rw http.ResponseWriter, err error) {
+	var lockErr *models.LockoutError
+	if errors.As(err, &lockErr) {
+		setRetryAfter(rw, lockErr.RetryAfter)
+		SendResponse(rw, http.StatusTooManyRequests, []byte{})
+		return
+	}
+	if errors.Is(err, models.ErrMFARequired) || errors.Is(err, models.ErrInvalidMFACode) {
+		SendResponse(rw, http.StatusForbidden, []byte(err.Error()))
+		return
+	}
+	SendResponse(rw, http.StatusInternalServerError, []byte{})
+}
@@ -0,0 +1,70 @@
+package httpserver
+
+import (
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultPageSize = 100
+
+// parsePageQuery reads limit, cursor, from, to and sort from the query
+// string. Without limit and cursor the whole range is returned.
+func parsePageQuery(r *http.Request) (models.PageQuery, error) {
+	q := r.URL.Query()
+	var page models.PageQuery
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > models.MaxPageSize {
+			return page, fmt.Errorf("%w: limit must be between 1 and %d", models.ErrInvalidFilter, models.MaxPageSize)
+		}
+		page.Limit = limit
+	}
+	if v := q.Get("cursor"); v != "" {
+		cursor, err := models.ParseCursor(v)
+		if err != nil {
+			return page, err
+		}
+		page.Cursor = &cursor
+		if page.Limit == 0 {
+			page.Limit = defaultPageSize
+		}
+	}
+	for name, dst := range map[string]*time.Time{"from": &page.From, "to": &page.To} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return page, fmt.Errorf("%w: %s must be an RFC 3339 timestamp", models.ErrInvalidFilter, name)
+			}
+			// The database compares wall clocks, so offsets are applied here.
+			*dst = t.UTC()
+		}
+	}
+	if !page.From.IsZero() && !page.To.IsZero() && !page.From.Before(page.To) {
+		return page, fmt.Errorf("%w: from must be before to", models.ErrInvalidFilter)
+	}
+	switch q.Get("sort") {
+	case "", "desc":
+	case "asc":
+		page.Ascending = true
+	default:
+		return page, fmt.Errorf("%w: sort must be asc or desc", models.ErrInvalidFilter)
+	}
+	return page, nil
+}
+
+// setNextPage advertises the next page both as a Link header and as
+// X-Next-Cursor, the body keeps its original shape.
+func setNextPage(rw http.ResponseWriter, r *http.Request, next *models.Cursor) {
+	if next == nil {
+		return
+	}
+	cursor := next.Encode()
+	q := r.URL.Query()
+	q.Set("cursor", cursor)
+	rw.Header().Set("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", r.URL.Path, q.Encode()))
+	rw.Header().Set("X-Next-Cursor", cursor)
+}
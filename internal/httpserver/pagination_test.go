@@ -0,0 +1,22 @@
+package httpserver
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParsePageQueryNormalizesOffsets(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/user/statement?from=2024-03-01T12:00:00%2B03:00&to=2024-03-01T10:00:00Z", nil)
+	page, err := parsePageQuery(r)
+	if err != nil {
+		t.Fatalf("parsePageQuery: %v", err)
+	}
+	wantFrom := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	if !page.From.Equal(wantFrom) || page.From.Location() != time.UTC {
+		t.Errorf("from = %v, want %v", page.From, wantFrom)
+	}
+	if page.To.Location() != time.UTC || page.To.Hour() != 10 {
+		t.Errorf("to = %v, want 10:00 UTC", page.To)
+	}
+}
@@ -0,0 +1,96 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+func (h Handlers) GetProfileHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("GetProfileHandler called")
+	rw.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	profile, err := h.userSrv.GetProfile(ctx, UID)
+	if err == nil {
+		profile.Tier = h.tierName(ctx, UID)
+	}
+	sendProfile(rw, profile, err)
+}
+
+// tierName leaves the tier out of the profile when it can not be read.
+func (h Handlers) tierName(ctx context.Context, UID int) string {
+	tier, err := h.tierSrv.GetStatus(ctx, UID)
+	if err != nil {
+		logger.Log.Warn("can not get tier status", zap.Int("uid", UID), zap.Error(err))
+		return ""
+	}
+	return tier.Name
+}
+
+func (h Handlers) UpdateProfileHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("UpdateProfileHandler called")
+	if r.Header.Get("Content-Type") != "application/json" {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+
+	var update models.ProfileUpdate
+
+	err := json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	profile, err := h.userSrv.UpdateProfile(ctx, UID, update)
+	if err == nil {
+		profile.Tier = h.tierName(ctx, UID)
+	}
+	sendProfile(rw, profile, err)
+}
+
+func sendProfile(rw http.ResponseWriter, profile models.Profile, err error) {
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidProfile) {
+			SendResponse(rw, http.StatusBadRequest, []byte(err.Error()))
+			return
+		}
+		if errors.Is(err, models.ErrUserNotFound) {
+			SendResponse(rw, http.StatusNotFound, []byte{})
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	resp, err := json.MarshalIndent(profile, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
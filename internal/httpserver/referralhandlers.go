@@ -0,0 +1,35 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"net/http"
+	"time"
+)
+
+func (h Handlers) GetReferralsHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("GetReferralsHandler called")
+	rw.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	summary, err := h.refSrv.GetSummary(ctx, UID)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	resp, err := json.MarshalIndent(summary, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
@@ -3,6 +3,7 @@ package httpserver
 import (
 	"fmt"
 	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
@@ -28,23 +29,119 @@ func (r *RouterObject) GetRouter() (chi.Router, error) {
 		})
 		router.Route("/login", func(router chi.Router) {
 			router.Post("/", logger.HanlderWithLogger(r.h.LoginHandler))
+			router.Post("/mfa", logger.HanlderWithLogger(r.h.LoginMFAHandler))
 		})
 
-		router.Route("/orders", func(router chi.Router) {
+		router.Route("/password", func(router chi.Router) {
 			router.Use(r.h.AuthMiddleware)
-			router.Post("/", logger.HanlderWithLogger(r.h.PostOrdersHandler))
-			router.Get("/", logger.HanlderWithLogger(r.h.GetOrdersHandler))
+			router.Put("/", logger.HanlderWithLogger(r.h.ChangePasswordHandler))
 		})
-		router.Route("/balance", func(router chi.Router) {
+
+		router.Route("/mfa/totp", func(router chi.Router) {
 			router.Use(r.h.AuthMiddleware)
-			router.Get("/", logger.HanlderWithLogger(r.h.GetBalanceHandler))
-			router.Post("/withdraw", logger.HanlderWithLogger(r.h.PostWithdrawHandler))
+			router.Post("/", logger.HanlderWithLogger(r.h.StartTOTPHandler))
+			router.Get("/qr", logger.HanlderWithLogger(r.h.GetTOTPQRHandler))
+			router.Post("/confirm", logger.HanlderWithLogger(r.h.ConfirmTOTPHandler))
+			router.Delete("/", logger.HanlderWithLogger(r.h.DisableTOTPHandler))
 		})
-		router.Route("/withdrawals", func(router chi.Router) {
+
+		router.Group(func(router chi.Router) {
+			router.Use(r.h.AuthMiddleware)
+			router.Get("/me", logger.HanlderWithLogger(r.h.GetProfileHandler))
+			router.Patch("/me", logger.HanlderWithLogger(r.h.UpdateProfileHandler))
+			router.Get("/events", logger.HanlderWithLogger(r.h.EventsHandler))
+			router.Get("/export", logger.HanlderWithLogger(r.h.ExportAccountHandler))
+			router.Delete("/", logger.HanlderWithLogger(r.h.DeleteAccountHandler))
+			router.Get("/deletion", logger.HanlderWithLogger(r.h.GetAccountDeletionHandler))
+			router.Delete("/deletion", logger.HanlderWithLogger(r.h.CancelAccountDeletionHandler))
+			router.Post("/promo", logger.HanlderWithLogger(r.h.RedeemPromoHandler))
+			router.Get("/referrals", logger.HanlderWithLogger(r.h.GetReferralsHandler))
+		})
+
+		router.Route("/sessions", func(router chi.Router) {
 			router.Use(r.h.AuthMiddleware)
-			router.Get("/", logger.HanlderWithLogger(r.h.GetWithdrawalsHandler))
+			router.Get("/", logger.HanlderWithLogger(r.h.GetSessionsHandler))
+			router.Delete("/", logger.HanlderWithLogger(r.h.RevokeAllSessionsHandler))
+			router.Delete("/{id}", logger.HanlderWithLogger(r.h.RevokeSessionHandler))
+		})
+
+		router.Route("/webhooks", func(router chi.Router) {
+			router.Use(r.h.AuthMiddleware)
+			router.Post("/", logger.HanlderWithLogger(r.h.userWebhook(r.h.createWebhook)))
+			router.Get("/", logger.HanlderWithLogger(r.h.userWebhook(r.h.getWebhooks)))
+			router.Delete("/{id}", logger.HanlderWithLogger(r.h.userWebhook(r.h.deleteWebhook)))
+			router.Get("/{id}/deliveries", logger.HanlderWithLogger(r.h.userWebhook(r.h.getDeliveries)))
+			router.Get("/{id}/deliveries/{delivery}", logger.HanlderWithLogger(r.h.userWebhook(r.h.getDelivery)))
+			router.Post("/{id}/deliveries/{delivery}/replay", logger.HanlderWithLogger(r.h.userWebhook(r.h.replayDelivery)))
+		})
+
+		router.Route("/keys", func(router chi.Router) {
+			router.Use(r.h.AuthMiddleware)
+			router.Post("/", logger.HanlderWithLogger(r.h.CreateAPIKeyHandler))
+			router.Get("/", logger.HanlderWithLogger(r.h.GetAPIKeysHandler))
+			router.Delete("/{id}", logger.HanlderWithLogger(r.h.RevokeAPIKeyHandler))
+		})
+
+		router.Route("/orders", func(router chi.Router) {
+			router.With(r.h.ScopedAuth(models.ScopeOrdersWrite)).Post("/", logger.HanlderWithLogger(r.h.PostOrdersHandler))
+			router.With(r.h.ScopedAuth(models.ScopeOrdersWrite)).Post("/batch", logger.HanlderWithLogger(r.h.PostOrdersBatchHandler))
+			router.With(r.h.ScopedAuth(models.ScopeOrdersRead)).Get("/", logger.HanlderWithLogger(r.h.GetOrdersHandler))
+			router.With(r.h.ScopedAuth(models.ScopeOrdersRead)).Get("/{number}", logger.HanlderWithLogger(r.h.GetOrderHandler))
+		})
+		router.Route("/balance", func(router chi.Router) {
+			router.With(r.h.ScopedAuth(models.ScopeBalanceRead)).Get("/", logger.HanlderWithLogger(r.h.GetBalanceHandler))
+			router.With(r.h.ScopedAuth(models.ScopeWithdraw)).Post("/withdraw", logger.HanlderWithLogger(r.h.PostWithdrawHandler))
+			router.With(r.h.ScopedAuth(models.ScopeTransfer)).Post("/transfer", logger.HanlderWithLogger(r.h.PostTransferHandler))
+			router.With(r.h.ScopedAuth(models.ScopeWithdraw)).Post("/holds", logger.HanlderWithLogger(r.h.PostHoldHandler))
+			router.With(r.h.ScopedAuth(models.ScopeBalanceRead)).Get("/holds", logger.HanlderWithLogger(r.h.GetHoldsHandler))
+			router.With(r.h.ScopedAuth(models.ScopeWithdraw)).Post("/holds/{id}/capture", logger.HanlderWithLogger(r.h.CaptureHoldHandler))
+			router.With(r.h.ScopedAuth(models.ScopeWithdraw)).Post("/holds/{id}/release", logger.HanlderWithLogger(r.h.ReleaseHoldHandler))
+		})
+		router.Route("/statement", func(router chi.Router) {
+			router.With(r.h.ScopedAuth(models.ScopeBalanceRead)).Get("/", logger.HanlderWithLogger(r.h.GetStatementHandler))
+		})
+		router.Route("/transfers", func(router chi.Router) {
+			router.With(r.h.ScopedAuth(models.ScopeBalanceRead)).Get("/", logger.HanlderWithLogger(r.h.GetTransfersHandler))
+		})
+		router.Route("/withdrawals", func(router chi.Router) {
+			router.With(r.h.ScopedAuth(models.ScopeWithdrawalsRead)).Get("/", logger.HanlderWithLogger(r.h.GetWithdrawalsHandler))
 		})
 	})
+	r.chRouter.Route("/api/admin", func(router chi.Router) {
+		router.Use(r.h.AuthMiddleware)
+		router.Use(r.h.RequireRole(models.RoleAdmin))
+		router.Get("/users", logger.HanlderWithLogger(r.h.AdminFindUserHandler))
+		router.Route("/users/{id}", func(router chi.Router) {
+			router.Get("/", logger.HanlderWithLogger(r.h.AdminGetUserHandler))
+			router.Get("/orders", logger.HanlderWithLogger(r.h.AdminGetUserOrdersHandler))
+			router.Get("/withdrawals", logger.HanlderWithLogger(r.h.AdminGetUserWithdrawalsHandler))
+			router.Post("/adjustments", logger.HanlderWithLogger(r.h.AdminAdjustBalanceHandler))
+			router.Put("/role", logger.HanlderWithLogger(r.h.AdminSetRoleHandler))
+			router.Post("/keys", logger.HanlderWithLogger(r.h.AdminCreateAPIKeyHandler))
+		})
+		router.Route("/webhooks", func(router chi.Router) {
+			router.Post("/", logger.HanlderWithLogger(r.h.adminWebhook(r.h.createWebhook)))
+			router.Get("/", logger.HanlderWithLogger(r.h.adminWebhook(r.h.getWebhooks)))
+			router.Delete("/{id}", logger.HanlderWithLogger(r.h.adminWebhook(r.h.deleteWebhook)))
+			router.Get("/{id}/deliveries", logger.HanlderWithLogger(r.h.adminWebhook(r.h.getDeliveries)))
+			router.Get("/{id}/deliveries/{delivery}", logger.HanlderWithLogger(r.h.adminWebhook(r.h.getDelivery)))
+			router.Post("/{id}/deliveries/{delivery}/replay", logger.HanlderWithLogger(r.h.adminWebhook(r.h.replayDelivery)))
+		})
+		router.Route("/campaigns", func(router chi.Router) {
+			router.Post("/", logger.HanlderWithLogger(r.h.AdminCreateCampaignHandler))
+			router.Get("/", logger.HanlderWithLogger(r.h.AdminGetCampaignsHandler))
+			router.Get("/{id}", logger.HanlderWithLogger(r.h.AdminGetCampaignHandler))
+			router.Put("/{id}", logger.HanlderWithLogger(r.h.AdminUpdateCampaignHandler))
+			router.Delete("/{id}", logger.HanlderWithLogger(r.h.AdminDeleteCampaignHandler))
+		})
+		router.Route("/withdrawals/{id}", func(router chi.Router) {
+			router.Post("/complete", logger.HanlderWithLogger(r.h.AdminCompleteWithdrawalHandler))
+			router.Post("/fail", logger.HanlderWithLogger(r.h.AdminFailWithdrawalHandler))
+			router.Post("/reverse", logger.HanlderWithLogger(r.h.AdminReverseWithdrawalHandler))
+		})
+		router.Post("/service-accounts", logger.HanlderWithLogger(r.h.AdminCreateServiceAccountHandler))
+		router.Post("/orders/{number}/requeue", logger.HanlderWithLogger(r.h.AdminRequeueOrderHandler))
+	})
 	logger.Log.Info("Successfully initialized Router")
 	return r.chRouter, nil
 }
@@ -52,9 +149,69 @@ func (r *RouterObject) GetRouter() (chi.Router, error) {
 /*
 POST /api/user/register
 POST /api/user/login
+POST /api/user/login/mfa
+PUT /api/user/password
+POST /api/user/mfa/totp
+GET /api/user/mfa/totp/qr
+POST /api/user/mfa/totp/confirm
+DELETE /api/user/mfa/totp
+GET /api/user/me
+PATCH /api/user/me
+GET /api/user/events
+GET /api/user/export
+DELETE /api/user
+GET /api/user/deletion
+DELETE /api/user/deletion
+GET /api/user/sessions
+DELETE /api/user/sessions
+DELETE /api/user/sessions/{id}
+POST /api/user/keys
+GET /api/user/keys
+DELETE /api/user/keys/{id}
+POST /api/user/webhooks
+GET /api/user/webhooks
+DELETE /api/user/webhooks/{id}
+GET /api/user/webhooks/{id}/deliveries
+GET /api/user/webhooks/{id}/deliveries/{delivery}
+POST /api/user/webhooks/{id}/deliveries/{delivery}/replay
 POST /api/user/orders
+POST /api/user/orders/batch
 GET /api/user/orders
+GET /api/user/orders/{number}
 GET /api/user/balance
 POST /api/user/balance/withdraw
+POST /api/user/balance/transfer
+POST /api/user/balance/holds
+GET /api/user/balance/holds
+POST /api/user/balance/holds/{id}/capture
+POST /api/user/balance/holds/{id}/release
+GET /api/user/transfers
+GET /api/user/statement?from=&to=
 GET /api/user/withdrawals
+POST /api/user/promo
+GET /api/user/referrals
+
+GET /api/admin/users?login=
+GET /api/admin/users/{id}
+GET /api/admin/users/{id}/orders
+GET /api/admin/users/{id}/withdrawals
+POST /api/admin/users/{id}/adjustments
+PUT /api/admin/users/{id}/role
+POST /api/admin/users/{id}/keys
+POST /api/admin/service-accounts
+POST /api/admin/webhooks
+GET /api/admin/webhooks
+DELETE /api/admin/webhooks/{id}
+GET /api/admin/webhooks/{id}/deliveries
+GET /api/admin/webhooks/{id}/deliveries/{delivery}
+POST /api/admin/webhooks/{id}/deliveries/{delivery}/replay
+POST /api/admin/orders/{number}/requeue
+POST /api/admin/campaigns
+GET /api/admin/campaigns
+GET /api/admin/campaigns/{id}
+PUT /api/admin/campaigns/{id}
+DELETE /api/admin/campaigns/{id}
+POST /api/admin/withdrawals/{id}/complete
+POST /api/admin/withdrawals/{id}/fail
+POST /api/admin/withdrawals/{id}/reverse
 */
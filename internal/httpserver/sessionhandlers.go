@@ -0,0 +1,128 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"time"
+)
+
+func (h Handlers) GetSessionsHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("GetSessionsHandler called")
+	rw.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	current, err := h.getSessionID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	list, err := h.sessSrv.GetSessions(ctx, UID, current)
+	if err != nil {
+		if errors.Is(err, models.ErrNoData) {
+			SendResponse(rw, http.StatusNoContent, []byte{})
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	resp, err := json.MarshalIndent(list, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+func (h Handlers) RevokeSessionHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("RevokeSessionHandler called")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	current, err := h.getSessionID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	ID := chi.URLParam(r, "id")
+	err = h.sessSrv.RevokeSession(ctx, UID, ID)
+	if err != nil {
+		if errors.Is(err, models.ErrSessionNotFound) {
+			SendResponse(rw, http.StatusNotFound, []byte{})
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	if ID == current {
+		clearAuthCookie(rw)
+	}
+	SendResponse(rw, http.StatusOK, []byte{})
+}
+
+// RevokeAllSessionsHandler signs the user out everywhere. With
+// ?keep_current=true the session making the request survives.
+func (h Handlers) RevokeAllSessionsHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("RevokeAllSessionsHandler called")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	keep := ""
+	if r.URL.Query().Get("keep_current") == "true" {
+		keep, err = h.getSessionID(ctx, r)
+		if err != nil {
+			SendResponse(rw, http.StatusInternalServerError, []byte{})
+			return
+		}
+	}
+
+	err = h.sessSrv.RevokeAll(ctx, UID, keep)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	if keep == "" {
+		clearAuthCookie(rw)
+	}
+	SendResponse(rw, http.StatusOK, []byte{})
+}
+
+func (h Handlers) getSessionID(ctx context.Context, r *http.Request) (string, error) {
+	cookie, err := r.Cookie("auth_token")
+	if err != nil {
+		return "", fmt.Errorf("error retrieving cookie: %v", err)
+	}
+	ID, err := h.authSrv.GetSessionIDFromJWT(ctx, cookie.Value)
+	if err != nil {
+		return "", fmt.Errorf("error retrieving session ID: %v", err)
+	}
+	return ID, nil
+}
@@ -0,0 +1,99 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GetStatementHandler answers in CSV when the client accepts text/csv.
+// Statements are always chronological, sort is ignored.
+func (h Handlers) GetStatementHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("GetStatementHandler called")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	page, err := parsePageQuery(r)
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+	page.Ascending = true
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	statement, next, err := h.walletSrv.GetStatement(ctx, UID, models.StatementFilter{PageQuery: page})
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidFilter) {
+			SendResponse(rw, http.StatusBadRequest, []byte(err.Error()))
+			return
+		}
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	setNextPage(rw, r, next)
+
+	if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		resp, err := statementCSV(statement)
+		if err != nil {
+			SendResponse(rw, http.StatusInternalServerError, []byte{})
+			return
+		}
+		rw.Header().Set("Content-Type", "text/csv")
+		SendResponse(rw, http.StatusOK, resp)
+		return
+	}
+
+	if next != nil {
+		statement.NextCursor = next.Encode()
+	}
+	resp, err := json.MarshalIndent(statement, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+// statementCSV writes the entries between an opening and a closing row,
+// those two only fill the balance column.
+func statementCSV(statement models.Statement) ([]byte, error) {
+	amount := func(v float32) string {
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	records := [][]string{
+		{"at", "type", "reference", "amount", "balance"},
+		{"", "opening_balance", "", "", amount(statement.OpeningBalance)},
+	}
+	for _, entry := range statement.Entries {
+		records = append(records, []string{
+			entry.At.Format(time.RFC3339),
+			entry.Kind,
+			entry.Reference,
+			amount(entry.Amount),
+			amount(entry.Balance),
+		})
+	}
+	records = append(records, []string{"", "closing_balance", "", "", amount(statement.ClosingBalance)})
+	err := w.WriteAll(records)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
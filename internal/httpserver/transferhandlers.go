@@ -0,0 +1,115 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"net/http"
+	"time"
+)
+
+func (h Handlers) PostTransferHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("PostTransferHandler called")
+	if r.Header.Get("Content-Type") != "application/json" {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	var req models.TransferRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	err = h.mfaSrv.CheckWithdrawal(ctx, UID, req.Amount, r.Header.Get("X-TOTP-Code"), clientInfo(r))
+	if err != nil {
+		sendMFACheckError(rw, err)
+		return
+	}
+
+	transfer, replayed, err := h.walletSrv.Transfer(ctx, UID, req)
+	if err != nil {
+		sendTransferError(rw, err)
+		return
+	}
+	resp, err := json.MarshalIndent(transfer, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	if replayed {
+		rw.Header().Set("Idempotent-Replayed", "true")
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+func (h Handlers) GetTransfersHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Log.Debug("GetTransfersHandler called")
+	rw.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	UID, err := h.getUserID(ctx, r)
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+
+	transfers, err := h.walletSrv.GetTransfers(ctx, UID)
+	if err != nil {
+		sendTransferError(rw, err)
+		return
+	}
+	resp, err := json.MarshalIndent(transfers, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+func sendTransferError(rw http.ResponseWriter, err error) {
+	var wErr *models.WithdrawalError
+	switch {
+	case errors.As(err, &wErr):
+		resp, err := json.MarshalIndent(wErr, "", "    ")
+		if err != nil {
+			SendResponse(rw, http.StatusInternalServerError, []byte{})
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		SendResponse(rw, http.StatusUnprocessableEntity, resp)
+	case errors.Is(err, models.ErrNoData):
+		SendResponse(rw, http.StatusNoContent, []byte{})
+	case errors.Is(err, models.ErrIdempotencyKey), errors.Is(err, models.ErrSelfTransfer):
+		SendResponse(rw, http.StatusBadRequest, []byte(err.Error()))
+	case errors.Is(err, models.ErrRecipientNotFound):
+		SendResponse(rw, http.StatusNotFound, []byte(err.Error()))
+	case errors.Is(err, models.ErrNotEnoughBonuses):
+		SendResponse(rw, http.StatusPaymentRequired, []byte(err.Error()))
+	case errors.Is(err, models.ErrIdempotencyConflict):
+		SendResponse(rw, http.StatusConflict, []byte(err.Error()))
+	case errors.Is(err, models.ErrTransferLimit):
+		SendResponse(rw, http.StatusTooManyRequests, []byte(err.Error()))
+	default:
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+	}
+}
@@ -0,0 +1,200 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// globalOwner is the owner of webhooks managed through the admin API.
+const globalOwner = 0
+
+type webhookAction func(ctx context.Context, rw http.ResponseWriter, r *http.Request, owner int)
+
+// userWebhook runs action on behalf of the caller's own webhooks.
+func (h Handlers) userWebhook(action webhookAction) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		UID, err := h.getUserID(ctx, r)
+		if err != nil {
+			SendResponse(rw, http.StatusInternalServerError, []byte{})
+			return
+		}
+		action(ctx, rw, r, UID)
+	}
+}
+
+// adminWebhook runs action on global webhooks.
+func (h Handlers) adminWebhook(action webhookAction) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		action(ctx, rw, r, globalOwner)
+	}
+}
+
+func (h Handlers) createWebhook(ctx context.Context, rw http.ResponseWriter, r *http.Request, owner int) {
+	logger.Log.Debug("createWebhook called")
+	if r.Header.Get("Content-Type") != "application/json" {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+
+	var req models.WebhookRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	hook, err := h.webhookSrv.CreateWebhook(ctx, owner, req)
+	if err != nil {
+		sendWebhookError(rw, err)
+		return
+	}
+	resp, err := json.MarshalIndent(hook, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusCreated, resp)
+}
+
+func (h Handlers) getWebhooks(ctx context.Context, rw http.ResponseWriter, r *http.Request, owner int) {
+	logger.Log.Debug("getWebhooks called")
+	rw.Header().Set("Content-Type", "application/json")
+
+	hooks, err := h.webhookSrv.GetWebhooks(ctx, owner)
+	if err != nil {
+		sendWebhookError(rw, err)
+		return
+	}
+	resp, err := json.MarshalIndent(hooks, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+func (h Handlers) deleteWebhook(ctx context.Context, rw http.ResponseWriter, r *http.Request, owner int) {
+	logger.Log.Debug("deleteWebhook called")
+
+	ID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	err = h.webhookSrv.DeleteWebhook(ctx, owner, ID)
+	if err != nil {
+		sendWebhookError(rw, err)
+		return
+	}
+	SendResponse(rw, http.StatusOK, []byte{})
+}
+
+func (h Handlers) getDeliveries(ctx context.Context, rw http.ResponseWriter, r *http.Request, owner int) {
+	logger.Log.Debug("getDeliveries called")
+	rw.Header().Set("Content-Type", "application/json")
+
+	ID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	deliveries, err := h.webhookSrv.GetDeliveries(ctx, owner, ID)
+	if err != nil {
+		sendWebhookError(rw, err)
+		return
+	}
+	resp, err := json.MarshalIndent(deliveries, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+func (h Handlers) getDelivery(ctx context.Context, rw http.ResponseWriter, r *http.Request, owner int) {
+	logger.Log.Debug("getDelivery called")
+	rw.Header().Set("Content-Type", "application/json")
+
+	ID, deliveryID, ok := deliveryParams(r)
+	if !ok {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	delivery, err := h.webhookSrv.GetDelivery(ctx, owner, ID, deliveryID)
+	if err != nil {
+		sendWebhookError(rw, err)
+		return
+	}
+	resp, err := json.MarshalIndent(delivery, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusOK, resp)
+}
+
+func (h Handlers) replayDelivery(ctx context.Context, rw http.ResponseWriter, r *http.Request, owner int) {
+	logger.Log.Debug("replayDelivery called")
+	rw.Header().Set("Content-Type", "application/json")
+
+	ID, deliveryID, ok := deliveryParams(r)
+	if !ok {
+		SendResponse(rw, http.StatusBadRequest, []byte{})
+		return
+	}
+
+	delivery, err := h.webhookSrv.ReplayDelivery(ctx, owner, ID, deliveryID)
+	if err != nil {
+		sendWebhookError(rw, err)
+		return
+	}
+	resp, err := json.MarshalIndent(delivery, "", "    ")
+	if err != nil {
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+		return
+	}
+	SendResponse(rw, http.StatusAccepted, resp)
+}
+
+func deliveryParams(r *http.Request) (ID int, deliveryID int, ok bool) {
+	ID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return 0, 0, false
+	}
+	deliveryID, err = strconv.Atoi(chi.URLParam(r, "delivery"))
+	if err != nil {
+		return 0, 0, false
+	}
+	return ID, deliveryID, true
+}
+
+func sendWebhookError(rw http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrInvalidWebhook):
+		SendResponse(rw, http.StatusBadRequest, []byte(err.Error()))
+	case errors.Is(err, models.ErrNoData):
+		SendResponse(rw, http.StatusNoContent, []byte{})
+	case errors.Is(err, models.ErrWebhookNotFound), errors.Is(err, models.ErrDeliveryNotFound):
+		SendResponse(rw, http.StatusNotFound, []byte{})
+	default:
+		SendResponse(rw, http.StatusInternalServerError, []byte{})
+	}
+}
@@ -34,3 +34,14 @@ func (r *LoggingResponseWriter) WriteHeader(statusCode int) {
 	r.rd.respContentType = r.ResponseWriter.Header().Get("Content-Type")
 	r.rd.statusCode = statusCode
 }
+
+// Flush lets streaming handlers push data through the logging wrapper.
+func (r *LoggingResponseWriter) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *LoggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,174 @@
+package mfa
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"sync"
+	"time"
+)
+
+const (
+	SaveTOTPEnrollmentQuery = `
+						INSERT INTO user_totp (user_id, secret, confirmed, last_used_step, created_at) 
+						VALUES ($1, $2, FALSE, 0, $3)
+						ON CONFLICT (user_id) DO UPDATE 
+						SET secret = EXCLUDED.secret, 
+						    last_used_step = 0, 
+						    created_at = EXCLUDED.created_at
+						WHERE user_totp.confirmed = FALSE;`
+	GetTOTPQuery            = `SELECT secret, confirmed, last_used_step, created_at FROM user_totp WHERE user_id = $1;`
+	ConfirmTOTPQuery        = `UPDATE user_totp SET confirmed = TRUE, last_used_step = $1 WHERE user_id = $2 AND confirmed = FALSE;`
+	UseTOTPStepQuery        = `UPDATE user_totp SET last_used_step = $1 WHERE user_id = $2 AND confirmed = TRUE AND last_used_step < $1;`
+	DeleteTOTPQuery         = `DELETE FROM user_totp WHERE user_id = $1;`
+	InsertRecoveryCodeQuery = `INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES ($1, $2);`
+	DeleteRecoveryCodes     = `DELETE FROM totp_recovery_codes WHERE user_id = $1;`
+	UseRecoveryCodeQuery    = `
+						UPDATE totp_recovery_codes SET used_at = $1 
+						WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL;`
+)
+
+type DatabaseMFA interface {
+	SaveEnrollment(ctx context.Context, UID int, sealedSecret string) error
+	GetTOTP(ctx context.Context, UID int) (models.TOTPState, error)
+	ConfirmTOTP(ctx context.Context, UID int, step int64, recoveryHashes []string) error
+	UseTOTPStep(ctx context.Context, UID int, step int64) error
+	UseRecoveryCode(ctx context.Context, UID int, codeHash string) error
+	DeleteTOTP(ctx context.Context, UID int) error
+}
+
+type DBMFA struct {
+	db *sql.DB
+	mu *sync.RWMutex
+}
+
+func NewDBMFA(db *sql.DB, mu *sync.RWMutex) (*DBMFA, error) {
+	return &DBMFA{db: db, mu: mu}, nil
+}
+
+func (m *DBMFA) SaveEnrollment(ctx context.Context, UID int, sealedSecret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res, err := m.db.ExecContext(ctx, SaveTOTPEnrollmentQuery, UID, sealedSecret, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save totp enrollment: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return models.ErrMFAAlreadyEnabled
+	}
+	return nil
+}
+
+func (m *DBMFA) GetTOTP(ctx context.Context, UID int) (models.TOTPState, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	state := models.TOTPState{UserID: UID}
+	err := m.db.QueryRowContext(ctx, GetTOTPQuery, UID).Scan(
+		&state.Secret,
+		&state.Confirmed,
+		&state.LastUsedStep,
+		&state.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.TOTPState{}, models.ErrMFANotEnrolled
+		}
+		return models.TOTPState{}, fmt.Errorf("failed to get totp state: %w", err)
+	}
+	return state, nil
+}
+
+func (m *DBMFA) ConfirmTOTP(ctx context.Context, UID int, step int64, recoveryHashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, ConfirmTOTPQuery, step, UID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return models.ErrMFAAlreadyEnabled
+	}
+
+	_, err = tx.ExecContext(ctx, DeleteRecoveryCodes, UID)
+	if err != nil {
+		return err
+	}
+	for _, codeHash := range recoveryHashes {
+		_, err = tx.ExecContext(ctx, InsertRecoveryCodeQuery, UID, codeHash)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (m *DBMFA) UseTOTPStep(ctx context.Context, UID int, step int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// A step at or below the last used one means the code was already spent.
+	res, err := m.db.ExecContext(ctx, UseTOTPStepQuery, step, UID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return models.ErrInvalidMFACode
+	}
+	return nil
+}
+
+func (m *DBMFA) UseRecoveryCode(ctx context.Context, UID int, codeHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res, err := m.db.ExecContext(ctx, UseRecoveryCodeQuery, time.Now(), UID, codeHash)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return models.ErrInvalidMFACode
+	}
+	return nil
+}
+
+func (m *DBMFA) DeleteTOTP(ctx context.Context, UID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, DeleteRecoveryCodes, UID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, DeleteTOTPQuery, UID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
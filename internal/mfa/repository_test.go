@@ -0,0 +1,42 @@
+package mfa
+
+import (
+	"context"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/testdb"
+	"sync"
+	"testing"
+)
+
+func TestDBUseTOTPStepRejectsReplay(t *testing.T) {
+	ctx := context.Background()
+	db := testdb.Open(t)
+	m, err := NewDBMFA(db, &sync.RWMutex{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	UID, _ := testdb.CreateUser(t, db)
+
+	if err = m.SaveEnrollment(ctx, UID, "sealed"); err != nil {
+		t.Fatalf("SaveEnrollment: %v", err)
+	}
+	if err = m.UseTOTPStep(ctx, UID, 101); !errors.Is(err, models.ErrInvalidMFACode) {
+		t.Errorf("unconfirmed enrollment: error = %v, want ErrInvalidMFACode", err)
+	}
+	if err = m.ConfirmTOTP(ctx, UID, 100, nil); err != nil {
+		t.Fatalf("ConfirmTOTP: %v", err)
+	}
+	// The confirming code is spent too.
+	if err = m.UseTOTPStep(ctx, UID, 100); !errors.Is(err, models.ErrInvalidMFACode) {
+		t.Errorf("confirming step: error = %v, want ErrInvalidMFACode", err)
+	}
+	if err = m.UseTOTPStep(ctx, UID, 102); err != nil {
+		t.Fatalf("fresh step: %v", err)
+	}
+	for _, step := range []int64{102, 101} {
+		if err = m.UseTOTPStep(ctx, UID, step); !errors.Is(err, models.ErrInvalidMFACode) {
+			t.Errorf("step %d after 102: error = %v, want ErrInvalidMFACode", step, err)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package mfa
+
+import (
+	"context"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+)
+
+type MFAService interface {
+	StartEnrollment(ctx context.Context, UID int) (models.TOTPEnrollment, error)
+	GetEnrollmentQR(ctx context.Context, UID int) ([]byte, error)
+	ConfirmEnrollment(ctx context.Context, UID int, code string) (models.RecoveryCodes, error)
+	Disable(ctx context.Context, UID int, code string, client models.ClientInfo) error
+	IsEnabled(ctx context.Context, UID int) (bool, error)
+	Verify(ctx context.Context, UID int, code string) error
+	CheckWithdrawal(ctx context.Context, UID int, amount float32, code string, client models.ClientInfo) error
+}
@@ -0,0 +1,224 @@
+package mfa
+
+import (
+	"context"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/throttling"
+	"github.com/Fuonder/goptherstore.git/internal/users"
+	"github.com/skip2/go-qrcode"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	recoveryCodesCount = 10
+	qrSize             = 256
+)
+
+type Config struct {
+	Issuer            string
+	WithdrawThreshold float32
+}
+
+type MService struct {
+	conn     DatabaseMFA
+	uConn    users.DatabaseUsers
+	throttle throttling.LoginThrottler
+	sealer   *sealer
+	cfg      Config
+}
+
+func NewMService(conn DatabaseMFA, uConn users.DatabaseUsers, throttle throttling.LoginThrottler, secret []byte, cfg Config) (*MService, error) {
+	s, err := newSealer(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &MService{conn: conn, uConn: uConn, throttle: throttle, sealer: s, cfg: cfg}, nil
+}
+
+func (s *MService) StartEnrollment(ctx context.Context, UID int) (models.TOTPEnrollment, error) {
+	login, err := s.uConn.GetLoginByUID(ctx, UID)
+	if err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+	secret, err := generateSecret()
+	if err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+	sealed, err := s.sealer.seal(secret)
+	if err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+	err = s.conn.SaveEnrollment(ctx, UID, sealed)
+	if err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+	return models.TOTPEnrollment{
+		Secret: secret,
+		URI:    otpauthURI(s.cfg.Issuer, login, secret),
+	}, nil
+}
+
+func (s *MService) GetEnrollmentQR(ctx context.Context, UID int) ([]byte, error) {
+	state, err := s.conn.GetTOTP(ctx, UID)
+	if err != nil {
+		return nil, err
+	}
+	if state.Confirmed {
+		return nil, models.ErrMFAAlreadyEnabled
+	}
+	login, err := s.uConn.GetLoginByUID(ctx, UID)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := s.sealer.open(state.Secret)
+	if err != nil {
+		return nil, err
+	}
+	return qrcode.Encode(otpauthURI(s.cfg.Issuer, login, secret), qrcode.Medium, qrSize)
+}
+
+func (s *MService) ConfirmEnrollment(ctx context.Context, UID int, code string) (models.RecoveryCodes, error) {
+	state, err := s.conn.GetTOTP(ctx, UID)
+	if err != nil {
+		return models.RecoveryCodes{}, err
+	}
+	if state.Confirmed {
+		return models.RecoveryCodes{}, models.ErrMFAAlreadyEnabled
+	}
+	secret, err := s.sealer.open(state.Secret)
+	if err != nil {
+		return models.RecoveryCodes{}, err
+	}
+	step, ok := validateTOTP(secret, code, time.Now())
+	if !ok {
+		return models.RecoveryCodes{}, models.ErrInvalidMFACode
+	}
+
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		recovery, err := generateRecoveryCode()
+		if err != nil {
+			return models.RecoveryCodes{}, err
+		}
+		codes = append(codes, recovery)
+		hashes = append(hashes, hashRecoveryCode(recovery))
+	}
+
+	err = s.conn.ConfirmTOTP(ctx, UID, step, hashes)
+	if err != nil {
+		return models.RecoveryCodes{}, err
+	}
+	return models.RecoveryCodes{Codes: codes}, nil
+}
+
+// Disable removes the second factor after checking code. Wrong codes count
+// against the login throttle.
+func (s *MService) Disable(ctx context.Context, UID int, code string, client models.ClientInfo) error {
+	err := s.throttled(ctx, UID, client, func() error {
+		return s.Verify(ctx, UID, code)
+	})
+	if err != nil {
+		return err
+	}
+	return s.conn.DeleteTOTP(ctx, UID)
+}
+
+func (s *MService) IsEnabled(ctx context.Context, UID int) (bool, error) {
+	state, err := s.conn.GetTOTP(ctx, UID)
+	if err != nil {
+		if errors.Is(err, models.ErrMFANotEnrolled) {
+			return false, nil
+		}
+		return false, err
+	}
+	return state.Confirmed, nil
+}
+
+// Verify accepts either a current TOTP code or an unused recovery code.
+func (s *MService) Verify(ctx context.Context, UID int, code string) error {
+	state, err := s.confirmedState(ctx, UID)
+	if err != nil {
+		return err
+	}
+	if len(code) == totpDigits {
+		return s.verifyTOTP(ctx, state, code)
+	}
+	return s.conn.UseRecoveryCode(ctx, UID, hashRecoveryCode(code))
+}
+
+// CheckWithdrawal demands a fresh TOTP code from enrolled users when the
+// amount exceeds the configured threshold. A zero threshold disables it.
+// Wrong codes count against the login throttle.
+func (s *MService) CheckWithdrawal(ctx context.Context, UID int, amount float32, code string, client models.ClientInfo) error {
+	if s.cfg.WithdrawThreshold <= 0 || amount <= s.cfg.WithdrawThreshold {
+		return nil
+	}
+	state, err := s.confirmedState(ctx, UID)
+	if err != nil {
+		if errors.Is(err, models.ErrMFANotEnrolled) {
+			return nil
+		}
+		return err
+	}
+	if code == "" {
+		return models.ErrMFARequired
+	}
+	return s.throttled(ctx, UID, client, func() error {
+		return s.verifyTOTP(ctx, state, code)
+	})
+}
+
+// throttled runs check under the login throttle of UID, the same one
+// logins and password checks go through, so a stolen session can not be
+// used to guess codes.
+func (s *MService) throttled(ctx context.Context, UID int, client models.ClientInfo, check func() error) error {
+	login, err := s.uConn.GetLoginByUID(ctx, UID)
+	if err != nil {
+		return err
+	}
+	err = s.throttle.Check(ctx, login, client.IP)
+	if err != nil {
+		return err
+	}
+	err = check()
+	if errors.Is(err, models.ErrInvalidMFACode) {
+		if tErr := s.throttle.RegisterFailure(ctx, login, client.IP); tErr != nil {
+			logger.Log.Error("can not register failed mfa check", zap.Error(tErr))
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if tErr := s.throttle.RegisterSuccess(ctx, login, client.IP); tErr != nil {
+		logger.Log.Error("can not reset login throttle", zap.Error(tErr))
+	}
+	return nil
+}
+
+func (s *MService) confirmedState(ctx context.Context, UID int) (models.TOTPState, error) {
+	state, err := s.conn.GetTOTP(ctx, UID)
+	if err != nil {
+		return models.TOTPState{}, err
+	}
+	if !state.Confirmed {
+		return models.TOTPState{}, models.ErrMFANotEnrolled
+	}
+	return state, nil
+}
+
+func (s *MService) verifyTOTP(ctx context.Context, state models.TOTPState, code string) error {
+	secret, err := s.sealer.open(state.Secret)
+	if err != nil {
+		return err
+	}
+	step, ok := validateTOTP(secret, code, time.Now())
+	if !ok {
+		return models.ErrInvalidMFACode
+	}
+	return s.conn.UseTOTPStep(ctx, state.UserID, step)
+}
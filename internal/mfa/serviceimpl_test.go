@@ -0,0 +1,177 @@
+package mfa
+
+import (
+	"context"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/throttling"
+	"github.com/Fuonder/goptherstore.git/internal/users"
+	"testing"
+	"time"
+)
+
+// fakeMFA keeps one confirmed enrollment and applies the replay guard of
+// UseTOTPStepQuery.
+type fakeMFA struct {
+	DatabaseMFA
+	state   models.TOTPState
+	deleted bool
+}
+
+func (f *fakeMFA) GetTOTP(_ context.Context, UID int) (models.TOTPState, error) {
+	if f.deleted || f.state.UserID != UID {
+		return models.TOTPState{}, models.ErrMFANotEnrolled
+	}
+	return f.state, nil
+}
+
+func (f *fakeMFA) UseTOTPStep(_ context.Context, _ int, step int64) error {
+	if step <= f.state.LastUsedStep {
+		return models.ErrInvalidMFACode
+	}
+	f.state.LastUsedStep = step
+	return nil
+}
+
+func (f *fakeMFA) UseRecoveryCode(context.Context, int, string) error {
+	return models.ErrInvalidMFACode
+}
+
+func (f *fakeMFA) DeleteTOTP(context.Context, int) error {
+	f.deleted = true
+	return nil
+}
+
+type fakeUsers struct {
+	users.DatabaseUsers
+}
+
+func (fakeUsers) GetLoginByUID(context.Context, int) (string, error) {
+	return "alice", nil
+}
+
+// nopThrottleStore is only asked to audit lockouts, states stay in memory.
+type nopThrottleStore struct {
+	throttling.DatabaseThrottle
+}
+
+func (nopThrottleStore) WriteLockout(context.Context, models.LockoutAudit) error {
+	return nil
+}
+
+const testUID = 7
+
+func newTestService(t *testing.T, maxAttempts int) (*MService, *fakeMFA) {
+	t.Helper()
+	secret := []byte("test secret")
+	s, err := NewMService(nil, fakeUsers{}, throttling.NewTService(nopThrottleStore{}, throttling.Config{
+		LoginMaxAttempts: maxAttempts,
+		BaseLockout:      time.Minute,
+		MaxLockout:       time.Hour,
+		ResetAfter:       time.Hour,
+	}), secret, Config{Issuer: "test", WithdrawThreshold: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := s.sealer.seal(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &fakeMFA{state: models.TOTPState{UserID: testUID, Secret: sealed, Confirmed: true}}
+	s.conn = db
+	return s, db
+}
+
+func currentCode(t *testing.T) string {
+	t.Helper()
+	code, err := totpCode(rfcSecret, timeStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func wrongCode(t *testing.T) string {
+	t.Helper()
+	if currentCode(t) == "000000" {
+		return "000001"
+	}
+	return "000000"
+}
+
+func TestCheckWithdrawalRejectsReplay(t *testing.T) {
+	s, _ := newTestService(t, 5)
+	ctx := context.Background()
+	client := models.ClientInfo{IP: "198.51.100.1"}
+
+	if err := s.CheckWithdrawal(ctx, testUID, 50, "", client); err != nil {
+		t.Fatalf("under the threshold: %v", err)
+	}
+	if err := s.CheckWithdrawal(ctx, testUID, 150, "", client); !errors.Is(err, models.ErrMFARequired) {
+		t.Fatalf("no code: error = %v, want ErrMFARequired", err)
+	}
+	code := currentCode(t)
+	if err := s.CheckWithdrawal(ctx, testUID, 150, code, client); err != nil {
+		t.Fatalf("fresh code: %v", err)
+	}
+	if err := s.CheckWithdrawal(ctx, testUID, 150, code, client); !errors.Is(err, models.ErrInvalidMFACode) {
+		t.Errorf("replayed code: error = %v, want ErrInvalidMFACode", err)
+	}
+}
+
+func TestCheckWithdrawalThrottlesGuesses(t *testing.T) {
+	const maxAttempts = 3
+	s, _ := newTestService(t, maxAttempts)
+	ctx := context.Background()
+	client := models.ClientInfo{IP: "198.51.100.2"}
+
+	for i := range maxAttempts {
+		if err := s.CheckWithdrawal(ctx, testUID, 150, wrongCode(t), client); !errors.Is(err, models.ErrInvalidMFACode) {
+			t.Fatalf("guess %d: error = %v, want ErrInvalidMFACode", i+1, err)
+		}
+	}
+	// Locked out, even the right code has to wait.
+	var lockErr *models.LockoutError
+	if err := s.CheckWithdrawal(ctx, testUID, 150, currentCode(t), client); !errors.As(err, &lockErr) {
+		t.Fatalf("after %d guesses: error = %v, want a lockout", maxAttempts, err)
+	}
+	if err := s.Disable(ctx, testUID, currentCode(t), client); !errors.As(err, &lockErr) {
+		t.Errorf("disable while locked out: error = %v, want a lockout", err)
+	}
+}
+
+func TestDisableThrottlesGuesses(t *testing.T) {
+	const maxAttempts = 2
+	s, db := newTestService(t, maxAttempts)
+	ctx := context.Background()
+	client := models.ClientInfo{IP: "198.51.100.3"}
+
+	for range maxAttempts {
+		if err := s.Disable(ctx, testUID, wrongCode(t), client); !errors.Is(err, models.ErrInvalidMFACode) {
+			t.Fatalf("wrong code: error = %v, want ErrInvalidMFACode", err)
+		}
+	}
+	var lockErr *models.LockoutError
+	if err := s.Disable(ctx, testUID, currentCode(t), client); !errors.As(err, &lockErr) {
+		t.Fatalf("after %d guesses: error = %v, want a lockout", maxAttempts, err)
+	}
+	if db.deleted {
+		t.Error("second factor removed while locked out")
+	}
+}
+
+func TestDisableResetsThrottleOnSuccess(t *testing.T) {
+	s, db := newTestService(t, 2)
+	ctx := context.Background()
+	client := models.ClientInfo{IP: "198.51.100.4"}
+
+	if err := s.Disable(ctx, testUID, wrongCode(t), client); !errors.Is(err, models.ErrInvalidMFACode) {
+		t.Fatalf("wrong code: error = %v, want ErrInvalidMFACode", err)
+	}
+	if err := s.Disable(ctx, testUID, currentCode(t), client); err != nil {
+		t.Fatalf("right code: %v", err)
+	}
+	if !db.deleted {
+		t.Error("second factor still enrolled")
+	}
+}
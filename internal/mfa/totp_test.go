@@ -0,0 +1,64 @@
+package mfa
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of the RFC 6238 test vectors.
+var rfcSecret = base32NoPadding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeVectors(t *testing.T) {
+	// RFC 6238 appendix B, truncated to six digits.
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, v := range vectors {
+		code, err := totpCode(rfcSecret, timeStep(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatalf("totpCode: %v", err)
+		}
+		if code != v.code {
+			t.Errorf("code at %d = %s, want %s", v.unix, code, v.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := timeStep(now)
+	codeAt := func(step int64) string {
+		code, err := totpCode(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	for delta := int64(-totpSkew); delta <= totpSkew; delta++ {
+		step, ok := validateTOTP(rfcSecret, codeAt(current+delta), now)
+		if !ok || step != current+delta {
+			t.Errorf("code of step %+d: got step %d ok %v, want %d", delta, step, ok, current+delta)
+		}
+	}
+	for _, delta := range []int64{-totpSkew - 1, totpSkew + 1} {
+		if _, ok := validateTOTP(rfcSecret, codeAt(current+delta), now); ok {
+			t.Errorf("code of step %+d accepted", delta)
+		}
+	}
+	for _, code := range []string{"", "00592", "0059244", "abcdef"} {
+		if _, ok := validateTOTP(rfcSecret, code, now); ok {
+			t.Errorf("code %q accepted", code)
+		}
+	}
+	if _, ok := validateTOTP("not base32!", codeAt(current), now); ok {
+		t.Error("broken secret accepted")
+	}
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+type AccountExport struct {
+	Profile     Profile             `json:"profile"`
+	Wallet      MartUserWallet      `json:"wallet"`
+	Orders      []MartOrder         `json:"orders"`
+	Withdrawals []Withdrawal        `json:"withdrawals"`
+	Ledger      []BalanceAdjustment `json:"ledger"`
+	ExportedAt  time.Time           `json:"exported_at"`
+}
+
+type DeletionRequest struct {
+	Password string `json:"pwd"`
+}
+
+type DeletionSchedule struct {
+	ScheduledFor time.Time `json:"scheduled_for"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+var (
+	ScopeOrdersRead      = "orders:read"
+	ScopeOrdersWrite     = "orders:write"
+	ScopeBalanceRead     = "balance:read"
+	ScopeWithdraw        = "withdraw"
+	ScopeWithdrawalsRead = "withdrawals:read"
+	ScopeTransfer        = "transfer"
+)
+
+var KnownScopes = []string{
+	ScopeOrdersRead,
+	ScopeOrdersWrite,
+	ScopeBalanceRead,
+	ScopeWithdraw,
+	ScopeWithdrawalsRead,
+	ScopeTransfer,
+}
+
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Hash       string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type APIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// APIKeyCreated is the only place the plain key is ever returned.
+type APIKeyCreated struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// Principal is the caller authenticated by an API key.
+type Principal struct {
+	UID      int
+	APIKeyID int
+	Scopes   []string
+}
+
+type ServiceAccountRequest struct {
+	Login string `json:"login"`
+}
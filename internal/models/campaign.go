@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+var (
+	CampaignRuleFirstOrder = "first_order"
+	CampaignRuleOrderCount = "order_count"
+	CampaignRulePromoCode  = "promo_code"
+
+	CampaignRules = []string{CampaignRuleFirstOrder, CampaignRuleOrderCount, CampaignRulePromoCode}
+)
+
+// Campaign credits Reward points once its rule matches inside the validity
+// window. Threshold is the processed order count of order_count campaigns,
+// Code the promo code of promo_code ones. A zero GlobalLimit or nil EndsAt
+// means no limit.
+type Campaign struct {
+	ID           int        `json:"id"`
+	Name         string     `json:"name"`
+	Rule         string     `json:"rule"`
+	Code         string     `json:"code,omitempty"`
+	Threshold    int        `json:"threshold,omitempty"`
+	Reward       float32    `json:"reward"`
+	PerUserLimit int        `json:"per_user_limit"`
+	GlobalLimit  int        `json:"global_limit"`
+	StartsAt     time.Time  `json:"starts_at"`
+	EndsAt       *time.Time `json:"ends_at,omitempty"`
+	Redemptions  int        `json:"redemptions"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+func (c Campaign) ActiveAt(t time.Time) bool {
+	return !t.Before(c.StartsAt) && (c.EndsAt == nil || t.Before(*c.EndsAt))
+}
+
+type CampaignCredit struct {
+	ID           int       `json:"-"`
+	CampaignID   int       `json:"-"`
+	CampaignName string    `json:"campaign"`
+	UserID       int       `json:"-"`
+	OrderID      string    `json:"order,omitempty"`
+	Amount       float32   `json:"amount"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type PromoRequest struct {
+	Code string `json:"code"`
+}
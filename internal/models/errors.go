@@ -6,12 +6,70 @@ var (
 	ErrUserAlreadyExists  = errors.New("user already exists")
 	ErrUserCreationFailed = errors.New("user creation failed")
 	ErrWrongCredentials   = errors.New("wrong credentials")
+	ErrTooManyAttempts    = errors.New("too many failed login attempts")
+	ErrPolicyViolation    = errors.New("credentials policy violation")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidProfile     = errors.New("invalid profile")
+
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionExpired  = errors.New("session expired or revoked")
+
+	ErrDeletionNotScheduled = errors.New("account deletion is not scheduled")
+
+	ErrMFARequired       = errors.New("second factor required")
+	ErrInvalidMFACode    = errors.New("invalid second factor code")
+	ErrMFANotEnrolled    = errors.New("second factor not enrolled")
+	ErrMFAAlreadyEnabled = errors.New("second factor already enabled")
 
 	ErrOrderAlreadyExists = errors.New("order already exists")
 	ErrOrderOfOtherUser   = errors.New("order already registered by other user")
 	ErrInvalidOrderNumber = errors.New("invalid order number")
-	
+	ErrOrderNotFound      = errors.New("order not found")
+	ErrOrderFinalized     = errors.New("order already processed")
+	ErrOrderInProgress    = errors.New("order is being processed")
+	ErrQueueFull          = errors.New("accrual queue is full")
+
 	ErrNotEnoughBonuses = errors.New("not enough bonuses")
+	ErrReasonRequired   = errors.New("reason is required")
+	ErrInvalidAmount    = errors.New("invalid amount")
+
+	ErrWithdrawalNotFound = errors.New("withdrawal not found")
+	ErrInvalidTransition  = errors.New("invalid withdrawal status transition")
+	ErrOrderAlreadyUsed   = errors.New("order already used for a withdrawal")
+
+	ErrInvalidRole = errors.New("invalid role")
+
+	ErrAPIKeyNotFound       = errors.New("api key not found")
+	ErrInvalidAPIKey        = errors.New("invalid api key")
+	ErrInvalidAPIKeyRequest = errors.New("invalid api key request")
+	ErrInvalidScope         = errors.New("invalid scope")
+	ErrInsufficientScope    = errors.New("insufficient scope")
+
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrInvalidWebhook   = errors.New("invalid webhook")
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+
+	ErrCampaignNotFound  = errors.New("campaign not found")
+	ErrInvalidCampaign   = errors.New("invalid campaign")
+	ErrInvalidPromoCode  = errors.New("unknown promo code")
+	ErrPromoNotActive    = errors.New("promo code is not active")
+	ErrPromoLimitReached = errors.New("campaign limit reached")
+
+	ErrInvalidReferralCode = errors.New("unknown referral code")
+	ErrReferralRejected    = errors.New("referral rejected")
+
+	ErrRecipientNotFound   = errors.New("recipient not found")
+	ErrSelfTransfer        = errors.New("can not transfer to yourself")
+	ErrTransferLimit       = errors.New("daily transfer limit reached")
+	ErrIdempotencyKey      = errors.New("idempotency key must be 1 to 64 characters")
+	ErrIdempotencyConflict = errors.New("idempotency key already used for another transfer")
+
+	ErrHoldNotFound  = errors.New("hold not found")
+	ErrHoldNotActive = errors.New("hold is no longer active")
+	ErrInvalidHold   = errors.New("invalid hold")
+
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidFilter = errors.New("invalid filter")
 
 	ErrNoData = errors.New("no data")
 )
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+var (
+	EventOrderStatusChanged = "order.status_changed"
+	EventBalanceChanged     = "balance.changed"
+	EventWithdrawalCreated  = "withdrawal.created"
+	EventWithdrawalUpdated  = "withdrawal.status_changed"
+)
+
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	UserID    int       `json:"-"`
+	Data      any       `json:"data"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OrderStatusData struct {
+	Number  string  `json:"number"`
+	Status  string  `json:"status"`
+	Accrual float32 `json:"accrual,omitempty"`
+}
+
+type BalanceData struct {
+	Current   float32 `json:"current"`
+	Withdrawn float32 `json:"withdrawn"`
+	Delta     float32 `json:"delta"`
+	Reason    string  `json:"reason"`
+}
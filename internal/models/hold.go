@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+var (
+	HoldStatusActive   = "ACTIVE"
+	HoldStatusCaptured = "CAPTURED"
+	HoldStatusReleased = "RELEASED"
+	HoldStatusExpired  = "EXPIRED"
+)
+
+type BalanceHold struct {
+	ID           int        `json:"id"`
+	UserID       int        `json:"-"`
+	Amount       float32    `json:"amount"`
+	Captured     float32    `json:"captured,omitempty"`
+	OrderID      string     `json:"order,omitempty"`
+	Status       string     `json:"status"`
+	WithdrawalID int        `json:"withdrawal_id,omitempty"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	SettledAt    *time.Time `json:"settled_at,omitempty"`
+	// LotsExpireAt is the soonest expiry of the held points, released
+	// points get it back.
+	LotsExpireAt time.Time `json:"-"`
+}
+
+type HoldRequest struct {
+	Amount float32 `json:"amount"`
+	// TTL is the hold lifetime in seconds, zero takes the default.
+	TTL   int    `json:"ttl"`
+	Order string `json:"order,omitempty"`
+}
+
+// HoldCapture finalizes a hold. A zero Amount captures the whole hold,
+// a smaller one releases the rest.
+type HoldCapture struct {
+	Amount float32 `json:"amount,omitempty"`
+	Order  string  `json:"order,omitempty"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+type TOTPState struct {
+	UserID       int
+	Secret       string
+	Confirmed    bool
+	LastUsedStep int64
+	CreatedAt    time.Time
+}
+
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type MFACode struct {
+	Code string `json:"code"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
@@ -7,6 +7,8 @@ var (
 	OrderStatusInvalid    = "INVALID"
 	OrderStatusProcessing = "PROCESSING"
 	OrderStatusProcessed  = "PROCESSED"
+
+	OrderStatuses = []string{OrderStatusNew, OrderStatusInvalid, OrderStatusProcessing, OrderStatusProcessed}
 )
 
 type MartOrder struct {
@@ -17,3 +19,38 @@ type MartOrder struct {
 	Bonus     float32   `json:"accrual,omitempty"`
 	CreatedAt time.Time `json:"uploaded_at,omitempty"`
 }
+
+var (
+	OrderEventUploaded      = "uploaded"
+	OrderEventPolled        = "polled"
+	OrderEventStatusChanged = "status_changed"
+	OrderEventCredited      = "credited"
+	OrderEventRequeued      = "requeued"
+)
+
+type OrderEvent struct {
+	ID        int       `json:"-"`
+	OrderID   string    `json:"-"`
+	Kind      string    `json:"type"`
+	Status    string    `json:"status,omitempty"`
+	Amount    float32   `json:"amount,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OrderDetail struct {
+	MartOrder
+	Timeline []OrderEvent `json:"timeline"`
+}
+
+var (
+	BatchResultAccepted        = "accepted"
+	BatchResultAlreadyUploaded = "already_uploaded"
+	BatchResultConflict        = "conflict"
+	BatchResultInvalid         = "invalid"
+)
+
+type OrderBatchItem struct {
+	Number string `json:"number"`
+	Result string `json:"result"`
+	Reason string `json:"reason,omitempty"`
+}
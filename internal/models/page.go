@@ -0,0 +1,74 @@
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MaxPageSize = 1000
+
+// Cursor points at the last row of a page in (created_at, id) order.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        int
+}
+
+func (c Cursor) Encode() string {
+	raw := fmt.Sprintf("%d:%d", c.CreatedAt.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func ParseCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return Cursor{}, ErrInvalidCursor
+	}
+	ts, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	ID, err := strconv.Atoi(id)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	// Timestamps are stored without a zone as UTC wall clock, a local time
+	// would be compared by its own wall clock.
+	return Cursor{CreatedAt: time.Unix(0, ts).UTC(), ID: ID}, nil
+}
+
+// PageQuery is the common part of list filters. A zero Limit means no limit.
+type PageQuery struct {
+	Limit     int
+	Cursor    *Cursor
+	From      time.Time
+	To        time.Time
+	Ascending bool
+}
+
+type WithdrawalFilter struct {
+	PageQuery
+}
+
+type WithdrawalTotals struct {
+	Count int     `json:"count"`
+	Sum   float32 `json:"sum"`
+}
+
+// WithdrawalPage is returned instead of a bare list when totals are requested.
+type WithdrawalPage struct {
+	Withdrawals []Withdrawal      `json:"withdrawals"`
+	NextCursor  string            `json:"next_cursor,omitempty"`
+	Totals      *WithdrawalTotals `json:"totals,omitempty"`
+}
+
+type OrderFilter struct {
+	PageQuery
+	Status string
+}
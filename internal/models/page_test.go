@@ -0,0 +1,28 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseCursorIsUTC(t *testing.T) {
+	local := time.Date(2024, 3, 1, 12, 30, 0, 123456000, time.FixedZone("UTC+3", 3*60*60))
+	cursor, err := ParseCursor(Cursor{CreatedAt: local, ID: 42}.Encode())
+	if err != nil {
+		t.Fatalf("ParseCursor: %v", err)
+	}
+	if cursor.CreatedAt.Location() != time.UTC {
+		t.Errorf("cursor time in %v, want UTC", cursor.CreatedAt.Location())
+	}
+	if !cursor.CreatedAt.Equal(local) || cursor.CreatedAt.Hour() != 9 || cursor.ID != 42 {
+		t.Errorf("cursor = %v/%d, want %v/42", cursor.CreatedAt, cursor.ID, local.UTC())
+	}
+
+	// Not base64, no separator, a bad timestamp and a bad id.
+	for _, s := range []string{"!!", "MTIz", "YTox", "MTpi"} {
+		if _, err := ParseCursor(s); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("ParseCursor(%q) accepted", s)
+		}
+	}
+}
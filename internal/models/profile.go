@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+type Profile struct {
+	ID          int          `json:"id"`
+	Login       string       `json:"login"`
+	DisplayName string       `json:"display_name"`
+	Email       string       `json:"email"`
+	Role        string       `json:"role"`
+	CreatedAt   time.Time    `json:"created_at"`
+	Tier        string       `json:"tier,omitempty"`
+	Stats       ProfileStats `json:"stats"`
+}
+
+type ProfileStats struct {
+	OrderCount        int     `json:"order_count"`
+	LifetimeAccrual   float32 `json:"lifetime_accrual"`
+	LifetimeWithdrawn float32 `json:"lifetime_withdrawn"`
+}
+
+// ProfileUpdate only touches the fields present in the request.
+type ProfileUpdate struct {
+	DisplayName *string `json:"display_name"`
+	Email       *string `json:"email"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+var (
+	ReferralStatusPending  = "pending"
+	ReferralStatusRewarded = "rewarded"
+	ReferralStatusRejected = "rejected"
+
+	ReferralReasonSameIP = "same_ip"
+	ReferralReasonCap    = "referrer_cap"
+	ReferralReasonSelf   = "self_referral"
+)
+
+type Referral struct {
+	ID            int        `json:"-"`
+	ReferrerID    int        `json:"-"`
+	RefereeID     int        `json:"-"`
+	RefereeLogin  string     `json:"invitee"`
+	SignupIP      string     `json:"-"`
+	Status        string     `json:"status"`
+	Reason        string     `json:"reason,omitempty"`
+	ReferrerBonus float32    `json:"reward,omitempty"`
+	RefereeBonus  float32    `json:"-"`
+	CreatedAt     time.Time  `json:"created_at"`
+	RewardedAt    *time.Time `json:"rewarded_at,omitempty"`
+}
+
+type ReferralSummary struct {
+	Code     string     `json:"code"`
+	Earned   float32    `json:"earned"`
+	Invitees []Referral `json:"invitees"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+var (
+	StatementKindAccrual     = "accrual"
+	StatementKindWithdrawal  = "withdrawal"
+	StatementKindRefund      = "refund"
+	StatementKindAdjustment  = "adjustment"
+	StatementKindCampaign    = "campaign"
+	StatementKindReferral    = "referral"
+	StatementKindTransfer    = "transfer"
+	StatementKindHold        = "hold"
+	StatementKindHoldRelease = "hold_release"
+)
+
+// StatementEntry is one balance movement. Balance is the running balance
+// right after it.
+type StatementEntry struct {
+	Seq       int       `json:"-"`
+	At        time.Time `json:"at"`
+	Kind      string    `json:"type"`
+	Reference string    `json:"reference,omitempty"`
+	Amount    float32   `json:"amount"`
+	Balance   float32   `json:"balance"`
+}
+
+type StatementFilter struct {
+	PageQuery
+}
+
+type Statement struct {
+	From           *time.Time       `json:"from,omitempty"`
+	To             *time.Time       `json:"to,omitempty"`
+	OpeningBalance float32          `json:"opening_balance"`
+	ClosingBalance float32          `json:"closing_balance"`
+	Entries        []StatementEntry `json:"entries"`
+	NextCursor     string           `json:"next_cursor,omitempty"`
+}
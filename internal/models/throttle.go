@@ -0,0 +1,40 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+type ThrottleState struct {
+	Key         string
+	Failures    int
+	LockedUntil time.Time
+	LastFailure time.Time
+}
+
+type LockoutAudit struct {
+	ID          int
+	Key         string
+	Failures    int
+	LockedUntil time.Time
+	CreatedAt   time.Time
+}
+
+// LockoutError is returned while a login or an IP address is locked out.
+// It unwraps to ErrTooManyAttempts.
+type LockoutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("%v: retry after %s", ErrTooManyAttempts, e.RetryAfter)
+}
+
+func (e *LockoutError) Unwrap() error {
+	return ErrTooManyAttempts
+}
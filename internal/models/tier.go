@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+var (
+	TierBasisAccrual = "accrual"
+	TierBasisSpend   = "spend"
+)
+
+// Tier is reached once the rolling amount meets Threshold. Multiplier is
+// applied to every accrual credited while the tier holds.
+type Tier struct {
+	Name       string
+	Threshold  float32
+	Multiplier float32
+}
+
+type UserTier struct {
+	UserID      int
+	Tier        string
+	Rolling     float32
+	EvaluatedAt time.Time
+}
+
+type TierStatus struct {
+	Name        string    `json:"name"`
+	Multiplier  float32   `json:"multiplier"`
+	Rolling     float32   `json:"rolling_amount"`
+	NextTier    string    `json:"next_tier,omitempty"`
+	ToNext      float32   `json:"to_next,omitempty"`
+	Progress    float32   `json:"progress"`
+	EvaluatedAt time.Time `json:"evaluated_at"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+var (
+	TransferDirectionIn  = "in"
+	TransferDirectionOut = "out"
+)
+
+type Transfer struct {
+	ID             int       `json:"id"`
+	SenderID       int       `json:"-"`
+	RecipientID    int       `json:"-"`
+	Direction      string    `json:"direction"`
+	Counterparty   string    `json:"counterparty"`
+	Amount         float32   `json:"amount"`
+	IdempotencyKey string    `json:"idempotency_key,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type TransferRequest struct {
+	Recipient      string  `json:"recipient"`
+	Amount         float32 `json:"amount"`
+	IdempotencyKey string  `json:"idempotency_key"`
+}
+
+// TransferLimit bounds what one user may send since Since, zero fields
+// are not checked.
+type TransferLimit struct {
+	Since  time.Time
+	Amount float32
+	Count  int
+}
@@ -5,8 +5,17 @@ import (
 	"time"
 )
 
+var (
+	RoleUser    = "user"
+	RoleAdmin   = "admin"
+	RoleService = "service"
+)
+
 type Claims struct {
-	Username string `json:"username"`
+	Username   string `json:"username"`
+	Role       string `json:"role,omitempty"`
+	SessionID  string `json:"sid,omitempty"`
+	MFAPending bool   `json:"mfa_pending,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -14,5 +23,33 @@ type MartUser struct {
 	ID        int       `json:"id"`
 	Login     string    `json:"login"`
 	Password  string    `json:"pwd"`
+	Role      string    `json:"-"`
 	CreatedAt time.Time `json:"created_at"`
+	// ReferralCode is only read on registration.
+	ReferralCode string `json:"referral_code,omitempty"`
+}
+
+// AuthState is what token validation needs to know about the account now.
+type AuthState struct {
+	UID               int
+	Role              string
+	PasswordChangedAt time.Time
+}
+
+type UserInfo struct {
+	ID        int       `json:"id"`
+	Login     string    `json:"login"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	Balance   float32   `json:"balance"`
+	Withdrawn float32   `json:"withdrawn"`
+}
+
+type RoleChange struct {
+	Role string `json:"role"`
+}
+
+type PasswordChange struct {
+	OldPassword string `json:"old_pwd"`
+	NewPassword string `json:"new_pwd"`
 }
@@ -7,14 +7,92 @@ type MartUserWallet struct {
 	OwnerID       int       `json:"-"`
 	Balance       float32   `json:"current"`
 	TotalWithdraw float32   `json:"withdrawn"`
+	Held          float32   `json:"held"`
 	CreatedAt     time.Time `json:"-"`
+
+	ExpiringSoon *ExpiringPoints `json:"expiring_soon,omitempty"`
+	Tier         *TierStatus     `json:"tier,omitempty"`
 }
 
+var (
+	WithdrawalStatusPending   = "PENDING"
+	WithdrawalStatusCompleted = "COMPLETED"
+	WithdrawalStatusFailed    = "FAILED"
+	WithdrawalStatusReversed  = "REVERSED"
+)
+
 type Withdrawal struct {
-	ID        int       `json:"-"`
+	ID        int       `json:"id,omitempty"`
 	UserID    int       `json:"-"`
 	OrderID   string    `json:"order"`
 	Amount    float32   `json:"sum"`
-	Status    string    `json:"-"`
+	Status    string    `json:"status,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
 	CreatedAt time.Time `json:"processed_at,omitempty"`
+	// UniqueOrder makes the repository refuse a second withdrawal that has
+	// not failed for the same order number.
+	UniqueOrder bool `json:"-"`
+}
+
+// WithdrawalError tells which field of a withdrawal request broke which
+// rule. Err is the underlying sentinel error.
+type WithdrawalError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	Err     error  `json:"-"`
+}
+
+func (e *WithdrawalError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+func (e *WithdrawalError) Unwrap() error {
+	return e.Err
+}
+
+// WithdrawalTransition is a settlement decision on a withdrawal.
+type WithdrawalTransition struct {
+	Reason string `json:"reason"`
+}
+
+type BalanceAdjustment struct {
+	ID        int       `json:"-"`
+	UserID    int       `json:"-"`
+	AdminID   int       `json:"-"`
+	Amount    float32   `json:"amount"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var (
+	LotSourceAccrual  = "accrual"
+	LotSourceCampaign = "campaign"
+	LotSourceReferral = "referral"
+	LotSourceTransfer = "transfer"
+	LotSourceHold     = "hold"
+	// LotSourceAdjustment is a positive admin adjustment.
+	LotSourceAdjustment = "adjustment"
+	// LotSourceLegacy holds a balance credited before lots existed.
+	LotSourceLegacy = "legacy"
+)
+
+// PointLot is one credit of points, spent oldest first. A zero ExpiresAt
+// never expires.
+type PointLot struct {
+	ID        int       `json:"-"`
+	UserID    int       `json:"-"`
+	OrderID   string    `json:"order,omitempty"`
+	Source    string    `json:"source"`
+	Amount    float32   `json:"amount"`
+	Remaining float32   `json:"remaining"`
+	EarnedAt  time.Time `json:"earned_at"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// ExpiringPoints sums the points expiring before Until.
+type ExpiringPoints struct {
+	Amount        float32   `json:"amount"`
+	NextExpiresAt time.Time `json:"next_expires_at"`
+	Until         time.Time `json:"until"`
 }
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+var (
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusFailed    = "failed"
+)
+
+var KnownEventTypes = []string{
+	EventOrderStatusChanged,
+	EventBalanceChanged,
+	EventWithdrawalCreated,
+	EventWithdrawalUpdated,
+}
+
+// Webhook belongs to a user, or is global when UserID is 0 and then
+// receives the events of every user.
+type Webhook struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"-"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"events"`
+	Secret     string    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// WebhookCreated is the only place the signing secret is ever returned.
+type WebhookCreated struct {
+	Webhook
+	Secret string `json:"secret"`
+}
+
+type WebhookDelivery struct {
+	ID             int              `json:"id"`
+	WebhookID      int              `json:"webhook_id"`
+	EventID        string           `json:"event_id"`
+	EventType      string           `json:"event"`
+	Payload        json.RawMessage  `json:"payload"`
+	Status         string           `json:"status"`
+	Attempts       int              `json:"attempts"`
+	NextAttemptAt  *time.Time       `json:"next_attempt_at,omitempty"`
+	LastStatusCode int              `json:"last_status_code,omitempty"`
+	LastError      string           `json:"last_error,omitempty"`
+	CreatedAt      time.Time        `json:"created_at"`
+	DeliveredAt    *time.Time       `json:"delivered_at,omitempty"`
+	Log            []WebhookAttempt `json:"log,omitempty"`
+}
+
+type WebhookAttempt struct {
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
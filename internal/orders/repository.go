@@ -7,7 +7,10 @@ import (
 	"fmt"
 	"github.com/Fuonder/goptherstore.git/internal/logger"
 	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/wallets"
 	"go.uber.org/zap"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -18,19 +21,60 @@ const (
 							VALUES ($1, $2, $3, $4, $5);`
 	SearchOrderByNumberQuery = `SELECT user_id from orders WHERE order_number = $1;`
 	GetOrdersByUID           = `
-						SELECT order_number, status, bonus_amount, created_at 
+						SELECT order_number, status, COALESCE(credited_amount, bonus_amount), created_at 
 						FROM orders 
 						WHERE user_id = $1 
 						ORDER BY created_at DESC;`
-	UpdateOrder      = `UPDATE orders SET created_at = $1, status = $2 WHERE order_number = $3;`
-	UpdateOrderBonus = `UPDATE orders SET bonus_amount = $1 WHERE order_number = $2`
+	SettleOrderQuery  = `UPDATE orders SET status = $1 WHERE order_number = $2 AND status <> 'PROCESSED';`
+	UpdateOrderBonus  = `UPDATE orders SET bonus_amount = $1 WHERE order_number = $2`
+	ClaimOrderQuery   = `UPDATE orders SET status = 'PROCESSING' WHERE order_number = $1 AND status = ANY($2);`
+	ReleaseOrderQuery = `UPDATE orders SET status = $2 WHERE order_number = $1 AND status = 'PROCESSING';`
+	FindOrdersBase    = `
+						SELECT id, order_number, status, COALESCE(credited_amount, bonus_amount), created_at 
+						FROM orders 
+						WHERE user_id = $1`
+	InsertOrderEventQuery = `
+						INSERT INTO order_events (order_id, kind, status, amount, created_at) 
+						SELECT id, $2, $3, $4, $5 FROM orders WHERE order_number = $1;`
+	InsertPollEventQuery = `
+						INSERT INTO order_events (order_id, kind, status, created_at) 
+						SELECT o.id, $2, $3, $4 FROM orders o 
+						WHERE o.order_number = $1 AND (
+							SELECT e.status FROM order_events e 
+							WHERE e.order_id = o.id 
+							ORDER BY e.id DESC LIMIT 1
+						) IS DISTINCT FROM $3;`
+	GetOrderEventsQuery = `
+						SELECT e.id, e.kind, COALESCE(e.status, ''), COALESCE(e.amount, 0), e.created_at 
+						FROM order_events e 
+						JOIN orders o ON o.id = e.order_id 
+						WHERE o.order_number = $1 
+						ORDER BY e.id;`
+	GetOrderByNumber = `
+						SELECT id, user_id, order_number, status, COALESCE(credited_amount, bonus_amount), created_at 
+						FROM orders 
+						WHERE order_number = $1;`
+	GetStaleNewOrdersQuery = `
+						SELECT id, user_id, order_number, status, bonus_amount, created_at 
+						FROM orders 
+						WHERE status = 'NEW' AND created_at < $1 
+						ORDER BY created_at 
+						LIMIT $2;`
 )
 
 type DatabaseOrders interface {
 	WriteNewOrder(ctx context.Context, order models.MartOrder) error
-	UpdateOrder(ctx context.Context, order models.MartOrder) error
+	WriteNewOrders(ctx context.Context, UID int, numbers []string) ([]models.OrderBatchItem, error)
+	SettleOrder(ctx context.Context, order models.MartOrder, lot models.PointLot) (settled bool, err error)
+	ClaimOrder(ctx context.Context, orderNumber string, from ...string) (bool, error)
+	ReleaseOrder(ctx context.Context, orderNumber string, status string) error
 	GetUserOrders(ctx context.Context, UID int) ([]models.MartOrder, error)
 	GetOrderOwner(ctx context.Context, orderNumber string) (UID int, err error)
+	GetOrder(ctx context.Context, orderNumber string) (models.MartOrder, error)
+	FindUserOrders(ctx context.Context, UID int, filter models.OrderFilter) ([]models.MartOrder, error)
+	WriteOrderEvent(ctx context.Context, event models.OrderEvent) error
+	GetOrderEvents(ctx context.Context, orderNumber string) ([]models.OrderEvent, error)
+	GetStaleNewOrders(ctx context.Context, before time.Time, limit int) ([]models.MartOrder, error)
 }
 
 type DBOrders struct {
@@ -72,6 +116,56 @@ func (o *DBOrders) WriteNewOrder(ctx context.Context, order models.MartOrder) er
 
 }
 
+// WriteNewOrders inserts every unknown number of the batch in a single
+// transaction and reports what happened to each one, in the same order.
+// Numbers repeated inside the batch are reported as already uploaded.
+func (o *DBOrders) WriteNewOrders(ctx context.Context, UID int, numbers []string) ([]models.OrderBatchItem, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	tx, err := o.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	results := make([]models.OrderBatchItem, 0, len(numbers))
+	seen := make(map[string]bool, len(numbers))
+	for _, number := range numbers {
+		item := models.OrderBatchItem{Number: number}
+		if seen[number] {
+			item.Result = models.BatchResultAlreadyUploaded
+			results = append(results, item)
+			continue
+		}
+		seen[number] = true
+
+		ownerID := 0
+		err = tx.QueryRowContext(ctx, SearchOrderByNumberQuery, number).Scan(&ownerID)
+		switch {
+		case err == nil && ownerID == UID:
+			item.Result = models.BatchResultAlreadyUploaded
+		case err == nil:
+			item.Result = models.BatchResultConflict
+		case errors.Is(err, sql.ErrNoRows):
+			_, err = tx.ExecContext(ctx, InsertNewOrderQuery, UID, number, now, models.OrderStatusNew, 0)
+			if err != nil {
+				return nil, fmt.Errorf("failed to insert order %s: %w", number, err)
+			}
+			_, err = tx.ExecContext(ctx, InsertOrderEventQuery, number, models.OrderEventUploaded, models.OrderStatusNew, nil, now)
+			if err != nil {
+				return nil, fmt.Errorf("failed to write order event: %w", err)
+			}
+			item.Result = models.BatchResultAccepted
+		default:
+			return nil, fmt.Errorf("failed to check order_number presence: %w", err)
+		}
+		results = append(results, item)
+	}
+	return results, tx.Commit()
+}
+
 func (o *DBOrders) isOrderExists(ctx context.Context, orderNumber string, UID int) error {
 	// true - exists (negative case), false - not exists (positive case)
 	o.mu.RLock()
@@ -138,38 +232,239 @@ func (o *DBOrders) GetOrderOwner(ctx context.Context, orderNumber string) (UID i
 	ownerID := 0
 	err = o.db.QueryRowContext(ctx, SearchOrderByNumberQuery, orderNumber).Scan(&ownerID)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, models.ErrOrderNotFound
+		}
 		return 0, fmt.Errorf("failed to check order_number presence: %w", err)
 	}
 	return ownerID, nil
 }
-func (o *DBOrders) UpdateOrder(ctx context.Context, order models.MartOrder) error {
+
+// SettleOrder stores the accrual answer for the order. Only the first
+// answer after which the order is PROCESSED counts, later ones leave the
+// order alone and report settled false. The lot of a PROCESSED order is
+// credited in the same transaction, so an order is credited once however
+// often it is polled.
+func (o *DBOrders) SettleOrder(ctx context.Context, order models.MartOrder, lot models.PointLot) (settled bool, err error) {
 	o.mu.Lock()
 	defer o.mu.Unlock()
 	tx, err := o.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer tx.Rollback()
-	_, err = tx.ExecContext(
-		ctx,
-		UpdateOrder,
-		time.Now(),
-		order.Status,
-		order.OrderID,
-	)
+	res, err := tx.ExecContext(ctx, SettleOrderQuery, order.Status, order.OrderID)
 	if err != nil {
-		return err
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected == 0 {
+		return false, nil
 	}
 	if order.Bonus > 0 {
-		_, err = tx.ExecContext(
-			ctx,
-			UpdateOrderBonus,
-			order.Bonus,
-			order.OrderID,
-		)
+		_, err = tx.ExecContext(ctx, UpdateOrderBonus, order.Bonus, order.OrderID)
+		if err != nil {
+			return false, err
+		}
+	}
+	if order.Status == models.OrderStatusProcessed {
+		err = wallets.AccrualTx(ctx, tx, lot)
+		if err != nil {
+			return false, err
+		}
 	}
+	return true, tx.Commit()
+}
+
+// ClaimOrder marks the order PROCESSING if its status is one of from. It
+// reports whether the order was claimed, a claimed order belongs to the
+// one accrual worker it is handed to.
+func (o *DBOrders) ClaimOrder(ctx context.Context, orderNumber string, from ...string) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	res, err := o.db.ExecContext(ctx, ClaimOrderQuery, orderNumber, from)
 	if err != nil {
-		return err
+		return false, fmt.Errorf("failed to claim order: %w", err)
 	}
-	return tx.Commit()
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// ReleaseOrder gives a claimed order that never reached a worker its
+// status back.
+func (o *DBOrders) ReleaseOrder(ctx context.Context, orderNumber string, status string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, err := o.db.ExecContext(ctx, ReleaseOrderQuery, orderNumber, status)
+	if err != nil {
+		return fmt.Errorf("failed to release order: %w", err)
+	}
+	return nil
+}
+
+func (o *DBOrders) GetOrder(ctx context.Context, orderNumber string) (models.MartOrder, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	var order models.MartOrder
+	var bonus sql.NullFloat64
+	err := o.db.QueryRowContext(ctx, GetOrderByNumber, orderNumber).Scan(
+		&order.ID,
+		&order.UserID,
+		&order.OrderID,
+		&order.Status,
+		&bonus,
+		&order.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.MartOrder{}, models.ErrOrderNotFound
+		}
+		return models.MartOrder{}, fmt.Errorf("failed to get order: %w", err)
+	}
+	if bonus.Valid {
+		order.Bonus = float32(bonus.Float64)
+	}
+	return order, nil
+}
+
+// FindUserOrders returns at most filter.Limit+1 orders after the cursor, the
+// extra row tells the caller whether another page follows.
+func (o *DBOrders) FindUserOrders(ctx context.Context, UID int, filter models.OrderFilter) ([]models.MartOrder, error) {
+	var query strings.Builder
+	query.WriteString(FindOrdersBase)
+	args := []any{UID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if filter.Status != "" {
+		query.WriteString(" AND status = " + arg(filter.Status))
+	}
+	if !filter.From.IsZero() {
+		query.WriteString(" AND created_at >= " + arg(filter.From))
+	}
+	if !filter.To.IsZero() {
+		query.WriteString(" AND created_at < " + arg(filter.To))
+	}
+	order, cmp := "DESC", "<"
+	if filter.Ascending {
+		order, cmp = "ASC", ">"
+	}
+	if filter.Cursor != nil {
+		query.WriteString(fmt.Sprintf(" AND (created_at, id) %s (%s, %s)", cmp, arg(filter.Cursor.CreatedAt), arg(filter.Cursor.ID)))
+	}
+	query.WriteString(fmt.Sprintf(" ORDER BY created_at %s, id %s", order, order))
+	if filter.Limit > 0 {
+		query.WriteString(" LIMIT " + arg(filter.Limit+1))
+	}
+
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	rows, err := o.db.QueryContext(ctx, query.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query orders: %v", err)
+	}
+	defer rows.Close()
+	orders := make([]models.MartOrder, 0)
+
+	for rows.Next() {
+		var order models.MartOrder
+		var bonus sql.NullFloat64
+		if err := rows.Scan(&order.ID, &order.OrderID, &order.Status, &bonus, &order.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		if bonus.Valid && bonus.Float64 > 0 {
+			order.Bonus = float32(bonus.Float64)
+		}
+		order.UserID = UID
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %v", err)
+	}
+	return orders, nil
+}
+
+// WriteOrderEvent appends to the order timeline. Poll results are only
+// written when the status differs from the latest event.
+func (o *DBOrders) WriteOrderEvent(ctx context.Context, event models.OrderEvent) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var err error
+	if event.Kind == models.OrderEventPolled {
+		_, err = o.db.ExecContext(ctx, InsertPollEventQuery, event.OrderID, event.Kind, event.Status, event.CreatedAt)
+	} else {
+		var status sql.NullString
+		if event.Status != "" {
+			status = sql.NullString{String: event.Status, Valid: true}
+		}
+		var amount sql.NullFloat64
+		if event.Amount != 0 {
+			amount = sql.NullFloat64{Float64: float64(event.Amount), Valid: true}
+		}
+		_, err = o.db.ExecContext(ctx, InsertOrderEventQuery, event.OrderID, event.Kind, status, amount, event.CreatedAt)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write order event: %w", err)
+	}
+	return nil
+}
+
+func (o *DBOrders) GetOrderEvents(ctx context.Context, orderNumber string) ([]models.OrderEvent, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	rows, err := o.db.QueryContext(ctx, GetOrderEventsQuery, orderNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query order events: %v", err)
+	}
+	defer rows.Close()
+	events := make([]models.OrderEvent, 0)
+
+	for rows.Next() {
+		event := models.OrderEvent{OrderID: orderNumber}
+		if err := rows.Scan(&event.ID, &event.Kind, &event.Status, &event.Amount, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %v", err)
+	}
+	return events, nil
+}
+
+// GetStaleNewOrders returns orders still NEW since before, the ones that
+// never made it into the accrual queue.
+func (o *DBOrders) GetStaleNewOrders(ctx context.Context, before time.Time, limit int) ([]models.MartOrder, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	rows, err := o.db.QueryContext(ctx, GetStaleNewOrdersQuery, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get new orders: %w", err)
+	}
+	defer rows.Close()
+	orders := make([]models.MartOrder, 0)
+	for rows.Next() {
+		var order models.MartOrder
+		var bonus sql.NullFloat64
+		err = rows.Scan(&order.ID, &order.UserID, &order.OrderID, &order.Status, &bonus, &order.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if bonus.Valid {
+			order.Bonus = float32(bonus.Float64)
+		}
+		orders = append(orders, order)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return orders, nil
 }
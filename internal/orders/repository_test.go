@@ -0,0 +1,101 @@
+package orders
+
+import (
+	"context"
+	"database/sql"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/testdb"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDBSettleOrderCreditsOnce(t *testing.T) {
+	ctx := context.Background()
+	db := testdb.Open(t)
+	o, err := NewDBOrders(db, &sync.RWMutex{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	UID, _ := testdb.CreateUser(t, db)
+	number := testdb.OrderNumber(t)
+	err = o.WriteNewOrder(ctx, models.MartOrder{UserID: UID, OrderID: number, Status: models.OrderStatusNew, CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("WriteNewOrder: %v", err)
+	}
+
+	claimed, err := o.ClaimOrder(ctx, number, models.OrderStatusNew, models.OrderStatusInvalid)
+	if err != nil || !claimed {
+		t.Fatalf("ClaimOrder: claimed %v, error %v", claimed, err)
+	}
+	claimed, err = o.ClaimOrder(ctx, number, models.OrderStatusNew, models.OrderStatusInvalid)
+	if err != nil || claimed {
+		t.Fatalf("second ClaimOrder: claimed %v, error %v", claimed, err)
+	}
+
+	order := models.MartOrder{OrderID: number, Status: models.OrderStatusProcessed, Bonus: 20}
+	lot := models.PointLot{UserID: UID, OrderID: number, Source: models.LotSourceAccrual, Amount: 20, EarnedAt: time.Now()}
+	// Two workers answering for the same order.
+	for i, want := range []bool{true, false} {
+		settled, err := o.SettleOrder(ctx, order, lot)
+		if err != nil {
+			t.Fatalf("SettleOrder %d: %v", i+1, err)
+		}
+		if settled != want {
+			t.Errorf("SettleOrder %d: settled %v, want %v", i+1, settled, want)
+		}
+	}
+
+	var balance, credited float32
+	var lots int
+	err = db.QueryRowContext(ctx, `SELECT balance FROM wallets WHERE user_id = $1;`, UID).Scan(&balance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.QueryRowContext(ctx, `SELECT credited_amount FROM orders WHERE order_number = $1;`, number).Scan(&credited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM point_lots WHERE order_number = $1;`, number).Scan(&lots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 20 || credited != 20 || lots != 1 {
+		t.Errorf("balance %.2f, credited %.2f, %d lots, want 20, 20 and 1", balance, credited, lots)
+	}
+
+	claimed, err = o.ClaimOrder(ctx, number, models.OrderStatusNew, models.OrderStatusInvalid)
+	if err != nil || claimed {
+		t.Errorf("claim a processed order: claimed %v, error %v", claimed, err)
+	}
+}
+
+func TestDBReleaseOrder(t *testing.T) {
+	ctx := context.Background()
+	db := testdb.Open(t)
+	o, err := NewDBOrders(db, &sync.RWMutex{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	UID, _ := testdb.CreateUser(t, db)
+	number := testdb.OrderNumber(t)
+	err = o.WriteNewOrder(ctx, models.MartOrder{UserID: UID, OrderID: number, Status: models.OrderStatusInvalid, CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("WriteNewOrder: %v", err)
+	}
+	if claimed, err := o.ClaimOrder(ctx, number, models.OrderStatusInvalid); err != nil || !claimed {
+		t.Fatalf("ClaimOrder: claimed %v, error %v", claimed, err)
+	}
+	if err = o.ReleaseOrder(ctx, number, models.OrderStatusInvalid); err != nil {
+		t.Fatalf("ReleaseOrder: %v", err)
+	}
+	var status string
+	var credited sql.NullFloat64
+	err = db.QueryRowContext(ctx, `SELECT status, credited_amount FROM orders WHERE order_number = $1;`, number).Scan(&status, &credited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != models.OrderStatusInvalid || credited.Valid {
+		t.Errorf("order is %s credited %v, want INVALID and never credited", status, credited)
+	}
+}
@@ -7,6 +7,12 @@ import (
 
 type OrderService interface {
 	RegisterOrder(ctx context.Context, orderNumber string, UID int) error
+	RegisterOrders(ctx context.Context, numbers []string, UID int) ([]models.OrderBatchItem, error)
 	GetOrdersByUID(ctx context.Context, UID int) (orders []models.MartOrder, err error)
+	FindOrders(ctx context.Context, UID int, filter models.OrderFilter) (orders []models.MartOrder, next *models.Cursor, err error)
 	UpdateOrder(ctx context.Context, order models.MartOrder) error
+	RequeueOrder(ctx context.Context, orderNumber string) error
+	RecordPoll(ctx context.Context, orderNumber string, status string)
+	GetOrderDetail(ctx context.Context, UID int, orderNumber string) (models.OrderDetail, error)
+	RunRequeue(ctx context.Context) error
 }
@@ -2,24 +2,51 @@ package orders
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/campaigns"
+	"github.com/Fuonder/goptherstore.git/internal/events"
 	"github.com/Fuonder/goptherstore.git/internal/logger"
 	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/referrals"
+	"github.com/Fuonder/goptherstore.git/internal/tiers"
 	"github.com/Fuonder/goptherstore.git/internal/wallets"
 	"go.uber.org/zap"
+	"slices"
 	"time"
 )
 
+const requeueBatchSize = 100
+
+type Config struct {
+	// PointsTTL is how long accrued points live, zero keeps them forever.
+	PointsTTL time.Duration
+	// RequeueInterval is how often NEW orders that did not fit into the
+	// accrual queue are offered to it again.
+	RequeueInterval time.Duration
+}
+
 type OService struct {
-	wConn wallets.DatabaseWallets
-	conn  DatabaseOrders
-	jobs  chan models.MartOrder
+	wConn     wallets.DatabaseWallets
+	conn      DatabaseOrders
+	jobs      chan models.MartOrder
+	events    events.Publisher
+	validator OrderNumberValidator
+	tiers     tiers.TierService
+	campaigns campaigns.CampaignService
+	referrals referrals.ReferralService
+	cfg       Config
 }
 
-func NewOService(conn DatabaseOrders, wConn wallets.DatabaseWallets, jobsCh chan models.MartOrder) *OService {
-	return &OService{conn: conn, wConn: wConn, jobs: jobsCh}
+func NewOService(conn DatabaseOrders, wConn wallets.DatabaseWallets, jobsCh chan models.MartOrder, events events.Publisher, validator OrderNumberValidator, tiers tiers.TierService, campaigns campaigns.CampaignService, referrals referrals.ReferralService, cfg Config) *OService {
+	return &OService{conn: conn, wConn: wConn, jobs: jobsCh, events: events, validator: validator, tiers: tiers, campaigns: campaigns, referrals: referrals, cfg: cfg}
 }
 
 func (s *OService) RegisterOrder(ctx context.Context, orderNumber string, UID int) error {
+	err := s.validator.Validate(orderNumber)
+	if err != nil {
+		return err
+	}
 	order := models.MartOrder{
 		UserID:    UID,
 		OrderID:   orderNumber,
@@ -27,19 +54,125 @@ func (s *OService) RegisterOrder(ctx context.Context, orderNumber string, UID in
 		Status:    models.OrderStatusNew,
 		Bonus:     0,
 	}
-	err := s.conn.WriteNewOrder(ctx, order)
+	err = s.conn.WriteNewOrder(ctx, order)
 	if err != nil {
 		return err
 	}
-	s.jobs <- order
-	order.Status = models.OrderStatusProcessing
-	err = s.conn.UpdateOrder(ctx, order)
+	s.recordEvent(ctx, orderNumber, models.OrderEventUploaded, order.Status, 0)
+	s.enqueueNew(ctx, order)
+	return nil
+}
+
+// RegisterOrders stores the valid numbers of a batch and hands the accepted
+// ones to the accrual workers once the batch is committed. Results follow
+// the order of numbers. Nothing after the commit fails the batch, orders
+// the queue can not take yet stay NEW for RunRequeue.
+func (s *OService) RegisterOrders(ctx context.Context, numbers []string, UID int) ([]models.OrderBatchItem, error) {
+	results := make([]models.OrderBatchItem, len(numbers))
+	valid := make([]string, 0, len(numbers))
+	positions := make([]int, 0, len(numbers))
+	for i, number := range numbers {
+		results[i] = models.OrderBatchItem{Number: number}
+		if err := s.validator.Validate(number); err != nil {
+			results[i].Result = models.BatchResultInvalid
+			results[i].Reason = err.Error()
+			continue
+		}
+		valid = append(valid, number)
+		positions = append(positions, i)
+	}
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	stored, err := s.conn.WriteNewOrders(ctx, UID, valid)
 	if err != nil {
+		return nil, err
+	}
+	for i, item := range stored {
+		results[positions[i]] = item
+		if item.Result != models.BatchResultAccepted {
+			continue
+		}
+		s.enqueueNew(ctx, models.MartOrder{
+			UserID:    UID,
+			OrderID:   item.Number,
+			CreatedAt: time.Now(),
+			Status:    models.OrderStatusNew,
+		})
+	}
+	return results, nil
+}
+
+// enqueue claims the order, moving it from one of from (NEW by default)
+// to PROCESSING, and offers it to the accrual workers without waiting. A
+// full queue gives the order its status back and returns ErrQueueFull.
+func (s *OService) enqueue(ctx context.Context, order models.MartOrder, from ...string) error {
+	if len(from) == 0 {
+		from = []string{models.OrderStatusNew}
+	}
+	claimed, err := s.conn.ClaimOrder(ctx, order.OrderID, from...)
+	if err != nil {
+		logger.Log.Error("can not claim order", zap.String("order", order.OrderID), zap.Error(err))
 		return err
 	}
+	if !claimed {
+		return models.ErrOrderInProgress
+	}
+	previous := order.Status
+	order.Status = models.OrderStatusProcessing
+	select {
+	case s.jobs <- order:
+	default:
+		err = s.conn.ReleaseOrder(ctx, order.OrderID, previous)
+		if err != nil {
+			logger.Log.Error("can not release order", zap.String("order", order.OrderID), zap.Error(err))
+		}
+		return models.ErrQueueFull
+	}
+	s.recordEvent(ctx, order.OrderID, models.OrderEventStatusChanged, order.Status, 0)
 	return nil
 }
 
+// enqueueNew enqueues a just registered order. Nothing fails the
+// registration, an order the queue can not take stays NEW for RunRequeue.
+func (s *OService) enqueueNew(ctx context.Context, order models.MartOrder) {
+	err := s.enqueue(ctx, order)
+	if errors.Is(err, models.ErrQueueFull) {
+		logger.Log.Warn("accrual queue is full, order left for requeue", zap.String("order", order.OrderID))
+	}
+}
+
+// RunRequeue periodically offers orders stuck in NEW to the accrual queue.
+// Only orders older than one interval are picked, so a registration still
+// enqueueing its own orders is not raced.
+func (s *OService) RunRequeue(ctx context.Context) error {
+	ticker := time.NewTicker(s.cfg.RequeueInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			s.requeueStale(ctx)
+		}
+	}
+}
+
+func (s *OService) requeueStale(ctx context.Context) {
+	orders, err := s.conn.GetStaleNewOrders(ctx, time.Now().Add(-s.cfg.RequeueInterval), requeueBatchSize)
+	if err != nil {
+		logger.Log.Error("can not load new orders", zap.Error(err))
+		return
+	}
+	for i, order := range orders {
+		if errors.Is(s.enqueue(ctx, order), models.ErrQueueFull) {
+			logger.Log.Warn("accrual queue is full, requeue postponed", zap.Int("waiting", len(orders)-i))
+			return
+		}
+	}
+}
+
 func (s *OService) GetOrdersByUID(ctx context.Context, UID int) (orders []models.MartOrder, err error) {
 	orders, err = s.conn.GetUserOrders(ctx, UID)
 	if err != nil {
@@ -49,22 +182,136 @@ func (s *OService) GetOrdersByUID(ctx context.Context, UID int) (orders []models
 	return orders, nil
 }
 
+// FindOrders returns one page of orders and the cursor of the next page,
+// nil when this page is the last one.
+func (s *OService) FindOrders(ctx context.Context, UID int, filter models.OrderFilter) (orders []models.MartOrder, next *models.Cursor, err error) {
+	if filter.Status != "" && !slices.Contains(models.OrderStatuses, filter.Status) {
+		return nil, nil, fmt.Errorf("%w: unknown status %q", models.ErrInvalidFilter, filter.Status)
+	}
+	orders, err = s.conn.FindUserOrders(ctx, UID, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if filter.Limit > 0 && len(orders) > filter.Limit {
+		orders = orders[:filter.Limit]
+		last := orders[len(orders)-1]
+		next = &models.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+	if len(orders) == 0 {
+		return nil, nil, models.ErrNoData
+	}
+	return orders, next, nil
+}
+
+// UpdateOrder stores the final accrual answer for the order and credits
+// it. An answer arriving for an order already PROCESSED is dropped, so an
+// order polled twice is credited, announced and rewarded once.
 func (s *OService) UpdateOrder(ctx context.Context, order models.MartOrder) error {
-	err := s.conn.UpdateOrder(ctx, order)
+	UID, err := s.conn.GetOrderOwner(ctx, order.OrderID)
+	if err != nil {
+		return err
+	}
+	var credited float32
+	if order.Status == models.OrderStatusProcessed && order.Bonus > 0 {
+		multiplier, err := s.tiers.Multiplier(ctx, UID)
+		if err != nil {
+			logger.Log.Warn("can not get tier multiplier", zap.Int("uid", UID), zap.Error(err))
+			multiplier = 1
+		}
+		credited = order.Bonus * multiplier
+	}
+	lot := models.PointLot{
+		UserID:   UID,
+		OrderID:  order.OrderID,
+		Source:   models.LotSourceAccrual,
+		Amount:   credited,
+		EarnedAt: time.Now(),
+	}
+	if s.cfg.PointsTTL > 0 {
+		lot.ExpiresAt = lot.EarnedAt.Add(s.cfg.PointsTTL)
+	}
+	settled, err := s.conn.SettleOrder(ctx, order, lot)
 	if err != nil {
 		return err
 	}
-	//1. update order
+	if !settled {
+		logger.Log.Warn("order already processed, accrual answer dropped", zap.String("order", order.OrderID))
+		return nil
+	}
 
-	//2. Get user_id from order SearchOrderByNumberQuery
-	UID, err := s.conn.GetOrderOwner(ctx, order.OrderID)
+	s.recordEvent(ctx, order.OrderID, models.OrderEventStatusChanged, order.Status, 0)
+	s.events.Publish(ctx, models.Event{
+		Type:   models.EventOrderStatusChanged,
+		UserID: UID,
+		Data:   models.OrderStatusData{Number: order.OrderID, Status: order.Status, Accrual: order.Bonus},
+	})
+	if credited > 0 {
+		s.recordEvent(ctx, order.OrderID, models.OrderEventCredited, "", credited)
+		wallets.PublishBalance(ctx, s.wConn, s.events, UID, credited, "accrual")
+	}
+	if order.Status == models.OrderStatusProcessed {
+		s.campaigns.OnOrderProcessed(ctx, UID, order.OrderID)
+		s.referrals.OnOrderProcessed(ctx, UID)
+	}
+	return nil
+}
+
+// RecordPoll keeps intermediate accrual answers in the order timeline.
+func (s *OService) RecordPoll(ctx context.Context, orderNumber string, status string) {
+	s.recordEvent(ctx, orderNumber, models.OrderEventPolled, status, 0)
+}
+
+// GetOrderDetail returns the order with its timeline if it belongs to UID.
+func (s *OService) GetOrderDetail(ctx context.Context, UID int, orderNumber string) (models.OrderDetail, error) {
+	owner, err := s.conn.GetOrderOwner(ctx, orderNumber)
+	if err != nil {
+		return models.OrderDetail{}, err
+	}
+	if owner != UID {
+		return models.OrderDetail{}, models.ErrOrderOfOtherUser
+	}
+	order, err := s.conn.GetOrder(ctx, orderNumber)
+	if err != nil {
+		return models.OrderDetail{}, err
+	}
+	events, err := s.conn.GetOrderEvents(ctx, orderNumber)
+	if err != nil {
+		return models.OrderDetail{}, err
+	}
+	return models.OrderDetail{MartOrder: order, Timeline: events}, nil
+}
+
+// recordEvent never fails the caller, a missing timeline entry is not
+// worth losing an order update over.
+func (s *OService) recordEvent(ctx context.Context, orderNumber string, kind string, status string, amount float32) {
+	err := s.conn.WriteOrderEvent(ctx, models.OrderEvent{
+		OrderID:   orderNumber,
+		Kind:      kind,
+		Status:    status,
+		Amount:    amount,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		logger.Log.Warn("can not write order event", zap.String("order", orderNumber), zap.Error(err))
+	}
+}
+
+// RequeueOrder sends a NEW or INVALID order back to the accrual workers.
+// The order is claimed first, so one a worker already holds is refused
+// with ErrOrderInProgress and a processed one with ErrOrderFinalized. A
+// full queue returns ErrQueueFull instead of waiting.
+func (s *OService) RequeueOrder(ctx context.Context, orderNumber string) error {
+	order, err := s.conn.GetOrder(ctx, orderNumber)
 	if err != nil {
 		return err
 	}
-	//3. change wallet balance AccrualUpdateBalance
-	err = s.wConn.Accrual(ctx, order.Bonus, UID)
+	if order.Status == models.OrderStatusProcessed {
+		return models.ErrOrderFinalized
+	}
+	err = s.enqueue(ctx, order, models.OrderStatusNew, models.OrderStatusInvalid)
 	if err != nil {
 		return err
 	}
+	s.recordEvent(ctx, orderNumber, models.OrderEventRequeued, order.Status, 0)
 	return nil
 }
@@ -0,0 +1,107 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"slices"
+	"testing"
+)
+
+// fakeOrders keeps order statuses and applies the conditional updates of
+// ClaimOrderQuery and ReleaseOrderQuery.
+type fakeOrders struct {
+	DatabaseOrders
+	statuses map[string]string
+}
+
+func (f *fakeOrders) GetOrder(_ context.Context, orderNumber string) (models.MartOrder, error) {
+	status, ok := f.statuses[orderNumber]
+	if !ok {
+		return models.MartOrder{}, models.ErrOrderNotFound
+	}
+	return models.MartOrder{OrderID: orderNumber, Status: status}, nil
+}
+
+func (f *fakeOrders) ClaimOrder(_ context.Context, orderNumber string, from ...string) (bool, error) {
+	if !slices.Contains(from, f.statuses[orderNumber]) {
+		return false, nil
+	}
+	f.statuses[orderNumber] = models.OrderStatusProcessing
+	return true, nil
+}
+
+func (f *fakeOrders) ReleaseOrder(_ context.Context, orderNumber string, status string) error {
+	if f.statuses[orderNumber] == models.OrderStatusProcessing {
+		f.statuses[orderNumber] = status
+	}
+	return nil
+}
+
+func (f *fakeOrders) WriteOrderEvent(context.Context, models.OrderEvent) error {
+	return nil
+}
+
+func TestRequeueOrder(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeOrders{statuses: map[string]string{
+		"1": models.OrderStatusNew,
+		"2": models.OrderStatusInvalid,
+		"3": models.OrderStatusProcessing,
+		"4": models.OrderStatusProcessed,
+		"5": models.OrderStatusInvalid,
+	}}
+	jobs := make(chan models.MartOrder, 2)
+	s := &OService{conn: db, jobs: jobs}
+
+	for _, number := range []string{"1", "2"} {
+		if err := s.RequeueOrder(ctx, number); err != nil {
+			t.Fatalf("requeue %s: %v", number, err)
+		}
+		if job := <-jobs; job.OrderID != number {
+			t.Errorf("queued %s, want %s", job.OrderID, number)
+		}
+		if db.statuses[number] != models.OrderStatusProcessing {
+			t.Errorf("order %s is %s, want PROCESSING", number, db.statuses[number])
+		}
+	}
+
+	if err := s.RequeueOrder(ctx, "3"); !errors.Is(err, models.ErrOrderInProgress) {
+		t.Errorf("order held by a worker: error = %v, want ErrOrderInProgress", err)
+	}
+	if err := s.RequeueOrder(ctx, "4"); !errors.Is(err, models.ErrOrderFinalized) {
+		t.Errorf("processed order: error = %v, want ErrOrderFinalized", err)
+	}
+	if len(jobs) != 0 {
+		t.Errorf("%d orders queued twice", len(jobs))
+	}
+
+	// A full queue is refused right away and the order is given back.
+	jobs <- models.MartOrder{}
+	jobs <- models.MartOrder{}
+	if err := s.RequeueOrder(ctx, "5"); !errors.Is(err, models.ErrQueueFull) {
+		t.Errorf("full queue: error = %v, want ErrQueueFull", err)
+	}
+	if db.statuses["5"] != models.OrderStatusInvalid {
+		t.Errorf("order 5 is %s after a full queue, want INVALID", db.statuses["5"])
+	}
+}
+
+func TestEnqueueClaimsOnce(t *testing.T) {
+	db := &fakeOrders{statuses: map[string]string{"1": models.OrderStatusNew, "2": models.OrderStatusNew}}
+	s := &OService{conn: db, jobs: make(chan models.MartOrder, 1)}
+
+	if err := s.enqueue(context.Background(), models.MartOrder{OrderID: "1", Status: models.OrderStatusNew}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if err := s.enqueue(context.Background(), models.MartOrder{OrderID: "2", Status: models.OrderStatusNew}); !errors.Is(err, models.ErrQueueFull) {
+		t.Fatalf("enqueue into a full queue: error = %v, want ErrQueueFull", err)
+	}
+	if db.statuses["1"] != models.OrderStatusProcessing || db.statuses["2"] != models.OrderStatusNew {
+		t.Errorf("statuses = %v, want 1 PROCESSING and 2 NEW", db.statuses)
+	}
+	// Claimed once, the order is never handed to a second worker.
+	if err := s.enqueue(context.Background(), models.MartOrder{OrderID: "1", Status: models.OrderStatusNew}); !errors.Is(err, models.ErrOrderInProgress) {
+		t.Errorf("enqueue a claimed order: error = %v, want ErrOrderInProgress", err)
+	}
+}
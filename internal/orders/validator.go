@@ -0,0 +1,173 @@
+package orders
+
+import (
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"regexp"
+	"strings"
+)
+
+// OrderNumberValidator decides whether a receipt number may be uploaded or
+// used for a withdrawal. Errors wrap models.ErrInvalidOrderNumber and name
+// the rule that rejected the number.
+type OrderNumberValidator interface {
+	Validate(number string) error
+}
+
+type ValidatorConfig struct {
+	MinLength int
+	MaxLength int
+	// Formats lists the receipt formats of the deployment as
+	// "name=regexp" or "name=prefix:P1,P2" entries separated by ";".
+	// A number has to match one of them, empty accepts any format.
+	// Patterns are matched against the whole number.
+	Formats string
+}
+
+// NewValidator builds the validator chain of a deployment: Luhn with length
+// bounds, followed by the format rules when any are configured.
+func NewValidator(cfg ValidatorConfig) (OrderNumberValidator, error) {
+	chain := ChainValidator{&LuhnValidator{MinLength: cfg.MinLength, MaxLength: cfg.MaxLength}}
+	if strings.TrimSpace(cfg.Formats) != "" {
+		rules, err := ParseFormatRules(cfg.Formats)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, &RuleSetValidator{Rules: rules})
+	}
+	return chain, nil
+}
+
+func invalidNumber(rule string, format string, args ...any) error {
+	return fmt.Errorf("%w: rule %s: %s", models.ErrInvalidOrderNumber, rule, fmt.Sprintf(format, args...))
+}
+
+// LuhnValidator accepts digit strings with a valid Luhn checksum whose
+// length lies within the bounds, a zero MaxLength means no upper bound.
+type LuhnValidator struct {
+	MinLength int
+	MaxLength int
+}
+
+func (v *LuhnValidator) Validate(number string) error {
+	minLength := max(v.MinLength, 1)
+	if len(number) < minLength {
+		return invalidNumber("length", "number must be at least %d digits", minLength)
+	}
+	if v.MaxLength > 0 && len(number) > v.MaxLength {
+		return invalidNumber("length", "number must be at most %d digits", v.MaxLength)
+	}
+	var sum int
+	double := false
+
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			return invalidNumber("digits", "number must contain digits only")
+		}
+
+		digit := int(c - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+
+		sum += digit
+		double = !double
+	}
+	if sum%10 != 0 {
+		return invalidNumber("luhn", "checksum mismatch")
+	}
+	return nil
+}
+
+// FormatRule describes the receipt numbers of one store chain, either by
+// regular expression or by a list of prefixes.
+type FormatRule struct {
+	Name     string
+	Pattern  *regexp.Regexp
+	Prefixes []string
+}
+
+func (r FormatRule) matches(number string) bool {
+	if r.Pattern != nil {
+		return r.Pattern.MatchString(number)
+	}
+	for _, prefix := range r.Prefixes {
+		if strings.HasPrefix(number, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFormatRules reads the ValidatorConfig.Formats syntax.
+func ParseFormatRules(spec string) ([]FormatRule, error) {
+	rules := make([]FormatRule, 0)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || value == "" {
+			return nil, fmt.Errorf("invalid order format %q, want name=regexp or name=prefix:P1,P2", entry)
+		}
+		rule := FormatRule{Name: name}
+		if prefixes, ok := strings.CutPrefix(value, "prefix:"); ok {
+			for _, prefix := range strings.Split(prefixes, ",") {
+				if prefix = strings.TrimSpace(prefix); prefix != "" {
+					rule.Prefixes = append(rule.Prefixes, prefix)
+				}
+			}
+			if len(rule.Prefixes) == 0 {
+				return nil, fmt.Errorf("order format %q has no prefixes", name)
+			}
+		} else {
+			// Patterns describe the whole number, "\d{12}" must not pass
+			// a longer number that merely contains twelve digits.
+			re, err := regexp.Compile("^(?:" + value + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern of order format %q: %w", name, err)
+			}
+			rule.Pattern = re
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// RuleSetValidator accepts numbers matching at least one of its rules.
+type RuleSetValidator struct {
+	Rules []FormatRule
+}
+
+func (v *RuleSetValidator) Validate(number string) error {
+	if len(v.Rules) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(v.Rules))
+	for _, rule := range v.Rules {
+		if rule.matches(number) {
+			return nil
+		}
+		names = append(names, rule.Name)
+	}
+	return invalidNumber("format", "number matches none of %s", strings.Join(names, ", "))
+}
+
+// ChainValidator runs every validator in order and reports the first
+// failure.
+type ChainValidator []OrderNumberValidator
+
+func (c ChainValidator) Validate(number string) error {
+	for _, v := range c {
+		if err := v.Validate(number); err != nil {
+			return err
+		}
+	}
+	return nil
+}
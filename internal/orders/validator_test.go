@@ -0,0 +1,48 @@
+package orders
+
+import (
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"testing"
+)
+
+func TestRuleSetValidatorMatchesWholeNumber(t *testing.T) {
+	rules, err := ParseFormatRules(`pos=\d{6}; web=WEB\d+|APP\d+; legacy=prefix:42,77`)
+	if err != nil {
+		t.Fatalf("ParseFormatRules: %v", err)
+	}
+	v := &RuleSetValidator{Rules: rules}
+
+	tests := []struct {
+		number string
+		valid  bool
+	}{
+		{"123456", true},
+		{"1234567", false},
+		{"x123456", false},
+		{"WEB12", true},
+		{"APP12", true},
+		{"xAPP12", false},
+		{"WEB12x", false},
+		{"420000", true},
+		{"4200001", true},
+		{"5200001", false},
+	}
+	for _, tt := range tests {
+		err := v.Validate(tt.number)
+		if tt.valid && err != nil {
+			t.Errorf("Validate(%q) = %v, want nil", tt.number, err)
+		}
+		if !tt.valid && !errors.Is(err, models.ErrInvalidOrderNumber) {
+			t.Errorf("Validate(%q) = %v, want ErrInvalidOrderNumber", tt.number, err)
+		}
+	}
+}
+
+func TestParseFormatRulesRejectsBadSpec(t *testing.T) {
+	for _, spec := range []string{"noequals", "=\\d+", "bad=(", "empty=prefix:,"} {
+		if _, err := ParseFormatRules(spec); err == nil {
+			t.Errorf("ParseFormatRules(%q) accepted", spec)
+		}
+	}
+}
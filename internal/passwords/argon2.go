@@ -0,0 +1,113 @@
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"strings"
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// Argon2idHasher encodes hashes in the PHC string format:
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+type Argon2idHasher struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func NewArgon2idHasher(memory uint32, time uint32, threads uint8) *Argon2idHasher {
+	if memory == 0 {
+		memory = 64 * 1024
+	}
+	if time == 0 {
+		time = 3
+	}
+	if threads == 0 {
+		threads = 2
+	}
+	return &Argon2idHasher{memory: memory, time: time, threads: threads}
+}
+
+func (a *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.time, a.memory, a.threads, argon2KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		a.memory,
+		a.time,
+		a.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a *Argon2idHasher) Verify(hash string, password string) (bool, error) {
+	params, err := decodeArgon2(hash)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), params.salt, params.time, params.memory, params.threads, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+func (a *Argon2idHasher) Recognizes(hash string) bool {
+	return hashPrefix(hash) == AlgorithmArgon2id
+}
+
+func (a *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, err := decodeArgon2(hash)
+	if err != nil {
+		return true
+	}
+	return params.memory < a.memory || params.time < a.time || params.threads != a.threads
+}
+
+func decodeArgon2(hash string) (argon2Params, error) {
+	var params argon2Params
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return params, fmt.Errorf("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, fmt.Errorf("invalid argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return params, fmt.Errorf("unsupported argon2id version %d", version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+
+	var err error
+	params.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	params.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, fmt.Errorf("invalid argon2id key: %w", err)
+	}
+	return params, nil
+}
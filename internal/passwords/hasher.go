@@ -0,0 +1,93 @@
+package passwords
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+// PasswordHasher produces self-describing hashes: every hash carries its
+// algorithm and parameters, so it can be verified after defaults change.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(hash string, password string) (bool, error)
+	Recognizes(hash string) bool
+	NeedsRehash(hash string) bool
+}
+
+type Config struct {
+	Algorithm     string
+	BcryptCost    int
+	Argon2Memory  uint32
+	Argon2Time    uint32
+	Argon2Threads uint8
+}
+
+// Manager hashes new passwords with the preferred hasher and verifies
+// stored hashes with whichever known hasher produced them.
+type Manager struct {
+	preferred PasswordHasher
+	known     []PasswordHasher
+}
+
+func NewManager(cfg Config) (*Manager, error) {
+	bcryptHasher, err := NewBcryptHasher(cfg.BcryptCost)
+	if err != nil {
+		return nil, err
+	}
+	argonHasher := NewArgon2idHasher(cfg.Argon2Memory, cfg.Argon2Time, cfg.Argon2Threads)
+
+	m := &Manager{known: []PasswordHasher{bcryptHasher, argonHasher}}
+	switch cfg.Algorithm {
+	case AlgorithmBcrypt, "":
+		m.preferred = bcryptHasher
+	case AlgorithmArgon2id:
+		m.preferred = argonHasher
+	default:
+		return nil, fmt.Errorf("unknown password hashing algorithm %q", cfg.Algorithm)
+	}
+	return m, nil
+}
+
+func (m *Manager) Hash(password string) (string, error) {
+	return m.preferred.Hash(password)
+}
+
+func (m *Manager) Verify(hash string, password string) (bool, error) {
+	for _, h := range m.known {
+		if h.Recognizes(hash) {
+			return h.Verify(hash, password)
+		}
+	}
+	return false, fmt.Errorf("unrecognized password hash format %q", hashPrefix(hash))
+}
+
+func (m *Manager) Recognizes(hash string) bool {
+	for _, h := range m.known {
+		if h.Recognizes(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsRehash reports whether the hash was made by another algorithm
+// or with weaker parameters than the preferred hasher uses now.
+func (m *Manager) NeedsRehash(hash string) bool {
+	if !m.preferred.Recognizes(hash) {
+		return true
+	}
+	return m.preferred.NeedsRehash(hash)
+}
+
+func hashPrefix(hash string) string {
+	parts := strings.SplitN(hash, "$", 3)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
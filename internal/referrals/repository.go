@@ -0,0 +1,245 @@
+package referrals
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/wallets"
+	"sync"
+	"time"
+)
+
+const (
+	GetCodeQuery      = `SELECT code FROM referral_codes WHERE user_id = $1;`
+	GetCodeOwnerQuery = `SELECT user_id FROM referral_codes WHERE code = $1;`
+	InsertCodeQuery   = `
+						INSERT INTO referral_codes (user_id, code, created_at) 
+						VALUES ($1, $2, $3) 
+						ON CONFLICT (user_id) DO NOTHING;`
+	InsertReferralQuery = `
+						INSERT INTO referrals (referrer_id, referee_id, signup_ip, status, reason, created_at) 
+						VALUES ($1, $2, $3, $4, $5, $6) 
+						ON CONFLICT (referee_id) DO NOTHING;`
+	SameIPQuery = `
+						SELECT EXISTS (SELECT 1 FROM sessions WHERE user_id = $1 AND ip = $2) 
+							OR EXISTS (SELECT 1 FROM referrals WHERE referrer_id = $1 AND signup_ip = $2);`
+	GetReferralsQuery = `
+						SELECT r.id, r.referrer_id, r.referee_id, u.login, r.signup_ip, r.status, COALESCE(r.reason, ''), 
+							r.referrer_bonus, r.referee_bonus, r.created_at, r.rewarded_at 
+						FROM referrals r 
+						JOIN users u ON u.id = r.referee_id 
+						WHERE r.referrer_id = $1 
+						ORDER BY r.created_at DESC, r.id DESC;`
+	LockReferrerQuery   = `SELECT user_id FROM referral_codes WHERE user_id = $1 FOR UPDATE;`
+	LockReferralQuery   = `SELECT id, referrer_id FROM referrals WHERE referee_id = $1 AND status = 'pending' FOR UPDATE;`
+	CountRewardedQuery  = `SELECT COUNT(*) FROM referrals WHERE referrer_id = $1 AND status = 'rewarded';`
+	RejectReferralQuery = `UPDATE referrals SET status = 'rejected', reason = $2 WHERE id = $1;`
+	RewardReferralQuery = `
+						UPDATE referrals 
+						SET status = 'rewarded', referrer_bonus = $2, referee_bonus = $3, rewarded_at = $4 
+						WHERE id = $1;`
+)
+
+type DatabaseReferrals interface {
+	GetCode(ctx context.Context, UID int) (string, error)
+	CreateCode(ctx context.Context, UID int, code string) error
+	GetCodeOwner(ctx context.Context, code string) (int, error)
+	CreateReferral(ctx context.Context, referral models.Referral) error
+	IsSuspiciousIP(ctx context.Context, referrerID int, ip string) (bool, error)
+	GetReferrals(ctx context.Context, referrerID int) ([]models.Referral, error)
+	Reward(ctx context.Context, refereeID int, maxRewards int, referrerLot models.PointLot, refereeLot models.PointLot) (models.Referral, error)
+}
+
+type DBReferrals struct {
+	db *sql.DB
+	mu *sync.RWMutex
+}
+
+func NewDBReferrals(db *sql.DB, mu *sync.RWMutex) (*DBReferrals, error) {
+	return &DBReferrals{db: db, mu: mu}, nil
+}
+
+// GetCode returns models.ErrNoData when the user has no code yet.
+func (r *DBReferrals) GetCode(ctx context.Context, UID int) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	code := ""
+	err := r.db.QueryRowContext(ctx, GetCodeQuery, UID).Scan(&code)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", models.ErrNoData
+		}
+		return "", fmt.Errorf("failed to get referral code: %w", err)
+	}
+	return code, nil
+}
+
+// CreateCode keeps the existing code if the user already has one.
+func (r *DBReferrals) CreateCode(ctx context.Context, UID int, code string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err := r.db.ExecContext(ctx, InsertCodeQuery, UID, code, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to create referral code: %w", err)
+	}
+	return nil
+}
+
+func (r *DBReferrals) GetCodeOwner(ctx context.Context, code string) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	UID := 0
+	err := r.db.QueryRowContext(ctx, GetCodeOwnerQuery, code).Scan(&UID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, models.ErrInvalidReferralCode
+		}
+		return 0, fmt.Errorf("failed to resolve referral code: %w", err)
+	}
+	return UID, nil
+}
+
+func (r *DBReferrals) CreateReferral(ctx context.Context, referral models.Referral) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var reason sql.NullString
+	if referral.Reason != "" {
+		reason = sql.NullString{String: referral.Reason, Valid: true}
+	}
+	_, err := r.db.ExecContext(ctx, InsertReferralQuery,
+		referral.ReferrerID,
+		referral.RefereeID,
+		referral.SignupIP,
+		referral.Status,
+		reason,
+		referral.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create referral: %w", err)
+	}
+	return nil
+}
+
+// IsSuspiciousIP reports whether ip was already used by the referrer or by
+// one of their earlier invitees.
+func (r *DBReferrals) IsSuspiciousIP(ctx context.Context, referrerID int, ip string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	suspicious := false
+	err := r.db.QueryRowContext(ctx, SameIPQuery, referrerID, ip).Scan(&suspicious)
+	if err != nil {
+		return false, fmt.Errorf("failed to check signup ip: %w", err)
+	}
+	return suspicious, nil
+}
+
+func (r *DBReferrals) GetReferrals(ctx context.Context, referrerID int) ([]models.Referral, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rows, err := r.db.QueryContext(ctx, GetReferralsQuery, referrerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query referrals: %v", err)
+	}
+	defer rows.Close()
+	referrals := make([]models.Referral, 0)
+	for rows.Next() {
+		var ref models.Referral
+		var rewardedAt sql.NullTime
+		err = rows.Scan(
+			&ref.ID,
+			&ref.ReferrerID,
+			&ref.RefereeID,
+			&ref.RefereeLogin,
+			&ref.SignupIP,
+			&ref.Status,
+			&ref.Reason,
+			&ref.ReferrerBonus,
+			&ref.RefereeBonus,
+			&ref.CreatedAt,
+			&rewardedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		if rewardedAt.Valid {
+			ref.RewardedAt = &rewardedAt.Time
+		}
+		referrals = append(referrals, ref)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %v", err)
+	}
+	return referrals, nil
+}
+
+// Reward settles the pending referral of refereeID. The referrer row is
+// locked so concurrent rewards can not overshoot maxRewards, a referral over the
+// cap is rejected and ErrReferralRejected is returned. models.ErrNoData
+// means there was nothing pending.
+func (r *DBReferrals) Reward(ctx context.Context, refereeID int, maxRewards int, referrerLot models.PointLot, refereeLot models.PointLot) (models.Referral, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Referral{}, err
+	}
+	defer tx.Rollback()
+
+	ref := models.Referral{RefereeID: refereeID}
+	err = tx.QueryRowContext(ctx, LockReferralQuery, refereeID).Scan(&ref.ID, &ref.ReferrerID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Referral{}, models.ErrNoData
+		}
+		return models.Referral{}, fmt.Errorf("failed to lock referral: %w", err)
+	}
+	var owner int
+	err = tx.QueryRowContext(ctx, LockReferrerQuery, ref.ReferrerID).Scan(&owner)
+	if err != nil {
+		return models.Referral{}, fmt.Errorf("failed to lock referrer: %w", err)
+	}
+
+	if maxRewards > 0 {
+		rewarded := 0
+		err = tx.QueryRowContext(ctx, CountRewardedQuery, ref.ReferrerID).Scan(&rewarded)
+		if err != nil {
+			return models.Referral{}, fmt.Errorf("failed to count referrals: %w", err)
+		}
+		if rewarded >= maxRewards {
+			_, err = tx.ExecContext(ctx, RejectReferralQuery, ref.ID, models.ReferralReasonCap)
+			if err != nil {
+				return models.Referral{}, fmt.Errorf("failed to reject referral: %w", err)
+			}
+			if err = tx.Commit(); err != nil {
+				return models.Referral{}, err
+			}
+			return models.Referral{}, models.ErrReferralRejected
+		}
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, RewardReferralQuery, ref.ID, referrerLot.Amount, refereeLot.Amount, now)
+	if err != nil {
+		return models.Referral{}, fmt.Errorf("failed to reward referral: %w", err)
+	}
+	referrerLot.UserID, refereeLot.UserID = ref.ReferrerID, refereeID
+	for _, lot := range []models.PointLot{referrerLot, refereeLot} {
+		if lot.Amount <= 0 {
+			continue
+		}
+		err = wallets.CreditTx(ctx, tx, lot)
+		if err != nil {
+			return models.Referral{}, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return models.Referral{}, err
+	}
+	ref.Status = models.ReferralStatusRewarded
+	ref.ReferrerBonus, ref.RefereeBonus = referrerLot.Amount, refereeLot.Amount
+	ref.RewardedAt = &now
+	return ref, nil
+}
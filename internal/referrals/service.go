@@ -0,0 +1,13 @@
+package referrals
+
+import (
+	"context"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+)
+
+type ReferralService interface {
+	ResolveCode(ctx context.Context, code string) (int, error)
+	Attach(ctx context.Context, referrerID int, refereeID int, ip string) error
+	GetSummary(ctx context.Context, UID int) (models.ReferralSummary, error)
+	OnOrderProcessed(ctx context.Context, UID int)
+}
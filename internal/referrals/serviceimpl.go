@@ -0,0 +1,151 @@
+package referrals
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/events"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/wallets"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+const codeAttempts = 3
+
+var codeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type Config struct {
+	ReferrerBonus float32
+	RefereeBonus  float32
+	// MaxPerReferrer caps the rewarded invitees of one user, zero is unlimited.
+	MaxPerReferrer int
+	// PointsTTL is how long referral bonuses live, zero keeps them forever.
+	PointsTTL time.Duration
+}
+
+type RService struct {
+	conn   DatabaseReferrals
+	wConn  wallets.DatabaseWallets
+	events events.Publisher
+	cfg    Config
+}
+
+func NewRService(conn DatabaseReferrals, wConn wallets.DatabaseWallets, events events.Publisher, cfg Config) *RService {
+	return &RService{conn: conn, wConn: wConn, events: events, cfg: cfg}
+}
+
+// ResolveCode returns the owner of a referral code.
+func (s *RService) ResolveCode(ctx context.Context, code string) (int, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return 0, models.ErrInvalidReferralCode
+	}
+	return s.conn.GetCodeOwner(ctx, code)
+}
+
+// Attach links a fresh account to its referrer. Referrals that look like
+// one person inviting themselves are stored as rejected, so they show up
+// in the referrer's list but never pay out.
+func (s *RService) Attach(ctx context.Context, referrerID int, refereeID int, ip string) error {
+	referral := models.Referral{
+		ReferrerID: referrerID,
+		RefereeID:  refereeID,
+		SignupIP:   ip,
+		Status:     models.ReferralStatusPending,
+		CreatedAt:  time.Now(),
+	}
+	switch {
+	case referrerID == refereeID:
+		referral.Status, referral.Reason = models.ReferralStatusRejected, models.ReferralReasonSelf
+	case ip != "":
+		suspicious, err := s.conn.IsSuspiciousIP(ctx, referrerID, ip)
+		if err != nil {
+			return err
+		}
+		if suspicious {
+			referral.Status, referral.Reason = models.ReferralStatusRejected, models.ReferralReasonSameIP
+		}
+	}
+	return s.conn.CreateReferral(ctx, referral)
+}
+
+func (s *RService) GetSummary(ctx context.Context, UID int) (models.ReferralSummary, error) {
+	code, err := s.getOrCreateCode(ctx, UID)
+	if err != nil {
+		return models.ReferralSummary{}, err
+	}
+	invitees, err := s.conn.GetReferrals(ctx, UID)
+	if err != nil {
+		return models.ReferralSummary{}, err
+	}
+	summary := models.ReferralSummary{Code: code, Invitees: invitees}
+	for _, ref := range invitees {
+		summary.Earned += ref.ReferrerBonus
+	}
+	return summary, nil
+}
+
+// OnOrderProcessed pays both sides of a pending referral. Only the first
+// processed order finds the referral pending. Failures are logged, the
+// accrual itself is already credited.
+func (s *RService) OnOrderProcessed(ctx context.Context, UID int) {
+	now := time.Now()
+	referrerLot := models.PointLot{Source: models.LotSourceReferral, Amount: s.cfg.ReferrerBonus, EarnedAt: now}
+	refereeLot := models.PointLot{Source: models.LotSourceReferral, Amount: s.cfg.RefereeBonus, EarnedAt: now}
+	if s.cfg.PointsTTL > 0 {
+		referrerLot.ExpiresAt = now.Add(s.cfg.PointsTTL)
+		refereeLot.ExpiresAt = referrerLot.ExpiresAt
+	}
+	ref, err := s.conn.Reward(ctx, UID, s.cfg.MaxPerReferrer, referrerLot, refereeLot)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoData):
+		case errors.Is(err, models.ErrReferralRejected):
+			logger.Log.Info("referral over the cap", zap.Int("uid", UID))
+		default:
+			logger.Log.Error("can not reward referral", zap.Int("uid", UID), zap.Error(err))
+		}
+		return
+	}
+	if ref.ReferrerBonus > 0 {
+		wallets.PublishBalance(ctx, s.wConn, s.events, ref.ReferrerID, ref.ReferrerBonus, "referral")
+	}
+	if ref.RefereeBonus > 0 {
+		wallets.PublishBalance(ctx, s.wConn, s.events, ref.RefereeID, ref.RefereeBonus, "referral")
+	}
+}
+
+// getOrCreateCode hands out codes lazily, so existing users get one the
+// first time they ask for it.
+func (s *RService) getOrCreateCode(ctx context.Context, UID int) (string, error) {
+	code, err := s.conn.GetCode(ctx, UID)
+	if !errors.Is(err, models.ErrNoData) {
+		return code, err
+	}
+	for i := 0; i < codeAttempts; i++ {
+		code, err = newCode()
+		if err != nil {
+			return "", err
+		}
+		// A colliding code fails on the unique index, try another one.
+		if err = s.conn.CreateCode(ctx, UID, code); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return s.conn.GetCode(ctx, UID)
+}
+
+func newCode() (string, error) {
+	raw := make([]byte, 5)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return codeEncoding.EncodeToString(raw), nil
+}
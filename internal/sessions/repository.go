@@ -0,0 +1,169 @@
+package sessions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"sync"
+	"time"
+)
+
+const (
+	InsertSessionQuery = `
+						INSERT INTO sessions (id, user_id, user_agent, ip, created_at, last_seen_at, expires_at) 
+						VALUES ($1, $2, $3, $4, $5, $6, $7);`
+	GetSessionQuery = `
+						SELECT id, user_id, user_agent, ip, created_at, last_seen_at, expires_at, revoked_at 
+						FROM sessions 
+						WHERE id = $1;`
+	GetActiveSessionsByUID = `
+						SELECT id, user_id, user_agent, ip, created_at, last_seen_at, expires_at, revoked_at 
+						FROM sessions 
+						WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2 
+						ORDER BY last_seen_at DESC;`
+	RevokeSessionQuery      = `UPDATE sessions SET revoked_at = $1 WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL;`
+	RevokeUserSessionsQuery = `UPDATE sessions SET revoked_at = $1 WHERE user_id = $2 AND id <> $3 AND revoked_at IS NULL;`
+	TouchSessionQuery       = `UPDATE sessions SET last_seen_at = $1 WHERE id = $2;`
+)
+
+type DatabaseSessions interface {
+	CreateSession(ctx context.Context, session models.Session) error
+	GetSession(ctx context.Context, ID string) (models.Session, error)
+	GetUserSessions(ctx context.Context, UID int) ([]models.Session, error)
+	RevokeSession(ctx context.Context, UID int, ID string) error
+	RevokeUserSessions(ctx context.Context, UID int, exceptID string) error
+	TouchSession(ctx context.Context, ID string, seenAt time.Time) error
+}
+
+type DBSessions struct {
+	db *sql.DB
+	mu *sync.RWMutex
+}
+
+func NewDBSessions(db *sql.DB, mu *sync.RWMutex) (*DBSessions, error) {
+	return &DBSessions{db: db, mu: mu}, nil
+}
+
+func (s *DBSessions) CreateSession(ctx context.Context, session models.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.db.ExecContext(
+		ctx, InsertSessionQuery,
+		session.ID,
+		session.UserID,
+		session.UserAgent,
+		session.IP,
+		session.CreatedAt,
+		session.LastSeenAt,
+		session.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+func (s *DBSessions) GetSession(ctx context.Context, ID string) (models.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session, err := scanSession(s.db.QueryRowContext(ctx, GetSessionQuery, ID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Session{}, models.ErrSessionNotFound
+		}
+		return models.Session{}, fmt.Errorf("failed to get session: %w", err)
+	}
+	return session, nil
+}
+
+func (s *DBSessions) GetUserSessions(ctx context.Context, UID int) ([]models.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rows, err := s.db.QueryContext(ctx, GetActiveSessionsByUID, UID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %v", err)
+	}
+	defer rows.Close()
+	sessions := make([]models.Session, 0)
+
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %v", err)
+	}
+	if len(sessions) == 0 {
+		return nil, models.ErrNoData
+	}
+	return sessions, nil
+}
+
+func (s *DBSessions) RevokeSession(ctx context.Context, UID int, ID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res, err := s.db.ExecContext(ctx, RevokeSessionQuery, time.Now(), ID, UID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return models.ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *DBSessions) RevokeUserSessions(ctx context.Context, UID int, exceptID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.db.ExecContext(ctx, RevokeUserSessionsQuery, time.Now(), UID, exceptID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
+
+func (s *DBSessions) TouchSession(ctx context.Context, ID string, seenAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.db.ExecContext(ctx, TouchSessionQuery, seenAt, ID)
+	if err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSession(row rowScanner) (models.Session, error) {
+	var session models.Session
+	var revokedAt sql.NullTime
+	err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.UserAgent,
+		&session.IP,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.ExpiresAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return models.Session{}, err
+	}
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+	return session, nil
+}
@@ -0,0 +1,14 @@
+package sessions
+
+import (
+	"context"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+)
+
+type SessionService interface {
+	StartSession(ctx context.Context, UID int, client models.ClientInfo) (models.Session, error)
+	CheckSession(ctx context.Context, UID int, ID string) error
+	GetSessions(ctx context.Context, UID int, currentID string) ([]models.Session, error)
+	RevokeSession(ctx context.Context, UID int, ID string) error
+	RevokeAll(ctx context.Context, UID int, exceptID string) error
+}
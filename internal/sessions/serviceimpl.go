@@ -0,0 +1,91 @@
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	SessionTTL       = 10 * time.Hour
+	touchGranularity = time.Minute
+	maxUserAgentLen  = 512
+)
+
+type SService struct {
+	conn DatabaseSessions
+}
+
+func NewSService(conn DatabaseSessions) *SService {
+	return &SService{conn: conn}
+}
+
+func (s *SService) StartSession(ctx context.Context, UID int, client models.ClientInfo) (models.Session, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return models.Session{}, err
+	}
+	userAgent := client.UserAgent
+	if len(userAgent) > maxUserAgentLen {
+		userAgent = userAgent[:maxUserAgentLen]
+	}
+
+	now := time.Now()
+	session := models.Session{
+		ID:         hex.EncodeToString(raw),
+		UserID:     UID,
+		UserAgent:  userAgent,
+		IP:         client.IP,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(SessionTTL),
+	}
+	err := s.conn.CreateSession(ctx, session)
+	if err != nil {
+		return models.Session{}, err
+	}
+	return session, nil
+}
+
+func (s *SService) CheckSession(ctx context.Context, UID int, ID string) error {
+	session, err := s.conn.GetSession(ctx, ID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if session.UserID != UID || session.RevokedAt != nil || now.After(session.ExpiresAt) {
+		return models.ErrSessionExpired
+	}
+	// Last-seen only needs minute precision, skip the write otherwise.
+	if now.Sub(session.LastSeenAt) > touchGranularity {
+		if err := s.conn.TouchSession(ctx, ID, now); err != nil {
+			logger.Log.Warn("can not update session last seen", zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func (s *SService) GetSessions(ctx context.Context, UID int, currentID string) ([]models.Session, error) {
+	sessions, err := s.conn.GetUserSessions(ctx, UID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+	return sessions, nil
+}
+
+func (s *SService) RevokeSession(ctx context.Context, UID int, ID string) error {
+	return s.conn.RevokeSession(ctx, UID, ID)
+}
+
+// RevokeAll signs the user out everywhere except the exceptID session,
+// pass an empty exceptID to revoke every session.
+func (s *SService) RevokeAll(ctx context.Context, UID int, exceptID string) error {
+	return s.conn.RevokeUserSessions(ctx, UID, exceptID)
+}
@@ -0,0 +1,107 @@
+// Package testdb gives repository tests a migrated PostgreSQL database.
+// Tests using it are skipped unless TEST_DATABASE_DSN is set; every test
+// works with freshly created users, so runs never clean up after
+// themselves and never see each other's rows.
+package testdb
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"github.com/Fuonder/goptherstore.git/internal/connection/postrge"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"os"
+	"testing"
+	"time"
+)
+
+const DSNEnv = "TEST_DATABASE_DSN"
+
+// migrationLock serializes migrations of test binaries run in parallel.
+const migrationLock = 7_340_032
+
+// Open connects to the test database and migrates it.
+func Open(t testing.TB) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv(DSNEnv)
+	if dsn == "" {
+		t.Skip(DSNEnv + " is not set")
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	Migrate(t, db)
+	return db
+}
+
+// Migrate runs the migration, tests of data fix-ups call it again.
+func Migrate(t testing.TB, db *sql.DB) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("connect to test database: %v", err)
+	}
+	defer conn.Close()
+	if _, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLock); err != nil {
+		t.Fatalf("lock migration: %v", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLock)
+	if _, err = conn.ExecContext(ctx, postrge.MigrationQuery); err != nil {
+		t.Fatalf("migrate test database: %v", err)
+	}
+}
+
+// CreateUser inserts a user with an empty wallet and returns its id and
+// login.
+func CreateUser(t testing.TB, db *sql.DB) (int, string) {
+	t.Helper()
+	raw := make([]byte, 6)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	login := "test_" + hex.EncodeToString(raw)
+
+	ctx := context.Background()
+	var UID int
+	err := db.QueryRowContext(ctx, `INSERT INTO users (login, password_hash) VALUES ($1, 'x') RETURNING id;`, login).Scan(&UID)
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	_, err = db.ExecContext(ctx, `INSERT INTO wallets (user_id) VALUES ($1);`, UID)
+	if err != nil {
+		t.Fatalf("create wallet: %v", err)
+	}
+	return UID, login
+}
+
+// OrderNumber returns a unique Luhn-valid order number.
+func OrderNumber(t testing.TB) string {
+	t.Helper()
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	digits := make([]byte, 0, 16)
+	for _, b := range raw {
+		digits = append(digits, '0'+b%10, '0'+(b/10)%10)
+	}
+	digits = digits[:15]
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		// Doubling starts next to the check digit appended below.
+		if (len(digits)-1-i)%2 == 0 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return string(append(digits, byte('0'+(10-sum%10)%10)))
+}
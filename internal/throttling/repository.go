@@ -0,0 +1,109 @@
+package throttling
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"sync"
+	"time"
+)
+
+const (
+	GetThrottleStateQuery    = `SELECT failures, locked_until, last_failure FROM login_throttle WHERE key = $1;`
+	UpsertThrottleStateQuery = `
+						INSERT INTO login_throttle (key, failures, locked_until, last_failure) 
+						VALUES ($1, $2, $3, $4)
+						ON CONFLICT (key) DO UPDATE 
+						SET failures = EXCLUDED.failures, 
+						    locked_until = EXCLUDED.locked_until, 
+						    last_failure = EXCLUDED.last_failure;`
+	DeleteThrottleStateQuery = `DELETE FROM login_throttle WHERE key = $1;`
+	InsertLockoutAuditQuery  = `INSERT INTO login_lockouts (key, failures, locked_until, created_at) VALUES ($1, $2, $3, $4);`
+)
+
+type DatabaseThrottle interface {
+	GetState(ctx context.Context, key string) (models.ThrottleState, error)
+	SaveState(ctx context.Context, state models.ThrottleState) error
+	DeleteState(ctx context.Context, key string) error
+	WriteLockout(ctx context.Context, audit models.LockoutAudit) error
+}
+
+type DBThrottle struct {
+	db *sql.DB
+	mu *sync.RWMutex
+}
+
+func NewDBThrottle(db *sql.DB, mu *sync.RWMutex) (*DBThrottle, error) {
+	return &DBThrottle{db: db, mu: mu}, nil
+}
+
+func (t *DBThrottle) GetState(ctx context.Context, key string) (models.ThrottleState, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	state := models.ThrottleState{Key: key}
+	var lockedUntil sql.NullTime
+	err := t.db.QueryRowContext(ctx, GetThrottleStateQuery, key).Scan(&state.Failures, &lockedUntil, &state.LastFailure)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ThrottleState{}, models.ErrNoData
+		}
+		return models.ThrottleState{}, fmt.Errorf("failed to get throttle state: %w", err)
+	}
+	if lockedUntil.Valid {
+		state.LockedUntil = lockedUntil.Time
+	}
+	return state, nil
+}
+
+func (t *DBThrottle) SaveState(ctx context.Context, state models.ThrottleState) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var lockedUntil sql.NullTime
+	if !state.LockedUntil.IsZero() {
+		lockedUntil = sql.NullTime{Time: state.LockedUntil, Valid: true}
+	}
+	_, err := t.db.ExecContext(
+		ctx, UpsertThrottleStateQuery,
+		state.Key,
+		state.Failures,
+		lockedUntil,
+		state.LastFailure,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save throttle state: %w", err)
+	}
+	return nil
+}
+
+func (t *DBThrottle) DeleteState(ctx context.Context, key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, err := t.db.ExecContext(ctx, DeleteThrottleStateQuery, key)
+	if err != nil {
+		return fmt.Errorf("failed to delete throttle state: %w", err)
+	}
+	return nil
+}
+
+func (t *DBThrottle) WriteLockout(ctx context.Context, audit models.LockoutAudit) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(
+		ctx, InsertLockoutAuditQuery,
+		audit.Key,
+		audit.Failures,
+		audit.LockedUntil,
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
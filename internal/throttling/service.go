@@ -0,0 +1,9 @@
+package throttling
+
+import "context"
+
+type LoginThrottler interface {
+	Check(ctx context.Context, login string, ip string) error
+	RegisterFailure(ctx context.Context, login string, ip string) error
+	RegisterSuccess(ctx context.Context, login string, ip string) error
+}
@@ -0,0 +1,235 @@
+package throttling
+
+import (
+	"context"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const pruneThreshold = 10000
+
+type Config struct {
+	LoginMaxAttempts int
+	IPMaxAttempts    int
+	BaseLockout      time.Duration
+	MaxLockout       time.Duration
+	ResetAfter       time.Duration
+	Persist          bool
+}
+
+type TService struct {
+	conn DatabaseThrottle
+	cfg  Config
+	// mu only guards the maps, no I/O happens while it is held.
+	mu     sync.Mutex
+	states map[string]models.ThrottleState
+	locks  map[string]*keyLock
+}
+
+// keyLock serializes the updates of one key, refs tells when it can go.
+type keyLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func NewTService(conn DatabaseThrottle, cfg Config) *TService {
+	return &TService{
+		conn:   conn,
+		cfg:    cfg,
+		states: make(map[string]models.ThrottleState),
+		locks:  make(map[string]*keyLock),
+	}
+}
+
+func loginKey(login string) string {
+	return "login:" + login
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func (s *TService) Check(ctx context.Context, login string, ip string) error {
+	now := time.Now()
+	var retryAfter time.Duration
+	for _, key := range s.keys(login, ip) {
+		unlock := s.lockKey(key)
+		state, err := s.loadState(ctx, key)
+		unlock()
+		if err != nil {
+			return err
+		}
+		if wait := state.LockedUntil.Sub(now); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	if retryAfter > 0 {
+		return &models.LockoutError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+func (s *TService) RegisterFailure(ctx context.Context, login string, ip string) error {
+	s.mu.Lock()
+	if len(s.states) > pruneThreshold {
+		s.prune()
+	}
+	s.mu.Unlock()
+
+	limits := map[string]int{}
+	if login != "" {
+		limits[loginKey(login)] = s.cfg.LoginMaxAttempts
+	}
+	if ip != "" {
+		limits[ipKey(ip)] = s.cfg.IPMaxAttempts
+	}
+
+	for key, limit := range limits {
+		err := s.registerFailure(ctx, key, limit)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *TService) registerFailure(ctx context.Context, key string, limit int) error {
+	unlock := s.lockKey(key)
+	defer unlock()
+
+	state, err := s.loadState(ctx, key)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if now.Sub(state.LastFailure) > s.cfg.ResetAfter {
+		state.Failures = 0
+	}
+	state.Failures++
+	state.LastFailure = now
+
+	if limit > 0 && state.Failures >= limit {
+		state.LockedUntil = now.Add(s.lockoutWindow(state.Failures - limit))
+		logger.Log.Warn("login locked out",
+			zap.String("key", key),
+			zap.Int("failures", state.Failures),
+			zap.Time("locked_until", state.LockedUntil))
+		err = s.conn.WriteLockout(ctx, models.LockoutAudit{
+			Key:         key,
+			Failures:    state.Failures,
+			LockedUntil: state.LockedUntil,
+		})
+		if err != nil {
+			logger.Log.Error("can not write lockout audit", zap.Error(err))
+		}
+	}
+
+	s.mu.Lock()
+	s.states[key] = state
+	s.mu.Unlock()
+
+	if s.cfg.Persist {
+		return s.conn.SaveState(ctx, state)
+	}
+	return nil
+}
+
+func (s *TService) RegisterSuccess(ctx context.Context, login string, ip string) error {
+	// The IP counter is left to decay on its own, otherwise a single valid
+	// account would let an attacker reset it between guesses.
+	key := loginKey(login)
+	unlock := s.lockKey(key)
+	defer unlock()
+
+	s.mu.Lock()
+	delete(s.states, key)
+	s.mu.Unlock()
+	if s.cfg.Persist {
+		return s.conn.DeleteState(ctx, key)
+	}
+	return nil
+}
+
+// lockKey takes the lock of one key and returns its release.
+func (s *TService) lockKey(key string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[key]
+	if !ok {
+		l = &keyLock{}
+		s.locks[key] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		s.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(s.locks, key)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// lockoutWindow doubles the base window for every failure past the limit.
+func (s *TService) lockoutWindow(overLimit int) time.Duration {
+	window := s.cfg.BaseLockout
+	for i := 0; i < overLimit; i++ {
+		window *= 2
+		if window >= s.cfg.MaxLockout {
+			return s.cfg.MaxLockout
+		}
+	}
+	return window
+}
+
+func (s *TService) keys(login string, ip string) []string {
+	keys := make([]string, 0, 2)
+	if login != "" {
+		keys = append(keys, loginKey(login))
+	}
+	if ip != "" {
+		keys = append(keys, ipKey(ip))
+	}
+	return keys
+}
+
+// loadState returns the state of key, the caller holds its key lock. Only
+// keys with failures are cached, a clean key costs nothing but the lookup.
+func (s *TService) loadState(ctx context.Context, key string) (models.ThrottleState, error) {
+	s.mu.Lock()
+	state, ok := s.states[key]
+	s.mu.Unlock()
+	if ok || !s.cfg.Persist {
+		state.Key = key
+		return state, nil
+	}
+
+	stored, err := s.conn.GetState(ctx, key)
+	if err != nil {
+		if errors.Is(err, models.ErrNoData) {
+			return models.ThrottleState{Key: key}, nil
+		}
+		return models.ThrottleState{}, err
+	}
+	s.mu.Lock()
+	s.states[key] = stored
+	s.mu.Unlock()
+	return stored, nil
+}
+
+// prune drops the states that no longer matter, the caller holds mu.
+func (s *TService) prune() {
+	now := time.Now()
+	for key, state := range s.states {
+		if now.After(state.LockedUntil) && now.Sub(state.LastFailure) > s.cfg.ResetAfter {
+			delete(s.states, key)
+		}
+	}
+}
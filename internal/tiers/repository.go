@@ -0,0 +1,110 @@
+package tiers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"sync"
+	"time"
+)
+
+const (
+	GetUserTierQuery = `SELECT tier, rolling_amount, evaluated_at FROM user_tiers WHERE user_id = $1;`
+	UpsertTierQuery  = `
+						INSERT INTO user_tiers (user_id, tier, rolling_amount, evaluated_at) 
+						VALUES ($1, $2, $3, $4) 
+						ON CONFLICT (user_id) DO UPDATE 
+						SET tier = EXCLUDED.tier, rolling_amount = EXCLUDED.rolling_amount, evaluated_at = EXCLUDED.evaluated_at;`
+	// RollingAccrualsQuery sums what the accrual service answered rather
+	// than credited_amount, which already carries the tier multiplier and
+	// would let a tier lift itself.
+	RollingAccrualsQuery = `
+						SELECT u.id, COALESCE(SUM(o.bonus_amount), 0) 
+						FROM users u 
+						LEFT JOIN orders o ON o.user_id = u.id AND o.status = 'PROCESSED' AND o.credited_at >= $1 
+						WHERE u.deleted_at IS NULL AND ($2 = 0 OR u.id = $2) 
+						GROUP BY u.id;`
+	RollingSpendQuery = `
+						SELECT u.id, COALESCE(SUM(w.amount), 0) 
+						FROM users u 
+						LEFT JOIN withdrawals w ON w.user_id = u.id AND w.status IN ('PENDING', 'COMPLETED') AND w.created_at >= $1 
+						WHERE u.deleted_at IS NULL AND ($2 = 0 OR u.id = $2) 
+						GROUP BY u.id;`
+)
+
+type DatabaseTiers interface {
+	GetUserTier(ctx context.Context, UID int) (models.UserTier, error)
+	SaveUserTiers(ctx context.Context, tiers []models.UserTier) error
+	// GetRollingAmounts sums the basis since the given time for UID, or for
+	// every active user when UID is 0.
+	GetRollingAmounts(ctx context.Context, basis string, since time.Time, UID int) (map[int]float32, error)
+}
+
+type DBTiers struct {
+	db *sql.DB
+	mu *sync.RWMutex
+}
+
+func NewDBTiers(db *sql.DB, mu *sync.RWMutex) (*DBTiers, error) {
+	return &DBTiers{db: db, mu: mu}, nil
+}
+
+func (t *DBTiers) GetUserTier(ctx context.Context, UID int) (models.UserTier, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	tier := models.UserTier{UserID: UID}
+	err := t.db.QueryRowContext(ctx, GetUserTierQuery, UID).Scan(&tier.Tier, &tier.Rolling, &tier.EvaluatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.UserTier{}, models.ErrNoData
+		}
+		return models.UserTier{}, fmt.Errorf("failed to get user tier: %w", err)
+	}
+	return tier, nil
+}
+
+func (t *DBTiers) SaveUserTiers(ctx context.Context, tiers []models.UserTier) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, tier := range tiers {
+		_, err = tx.ExecContext(ctx, UpsertTierQuery, tier.UserID, tier.Tier, tier.Rolling, tier.EvaluatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to save user tier: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (t *DBTiers) GetRollingAmounts(ctx context.Context, basis string, since time.Time, UID int) (map[int]float32, error) {
+	query := RollingAccrualsQuery
+	if basis == models.TierBasisSpend {
+		query = RollingSpendQuery
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	rows, err := t.db.QueryContext(ctx, query, since, UID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rolling amounts: %v", err)
+	}
+	defer rows.Close()
+	amounts := make(map[int]float32)
+	for rows.Next() {
+		var ID int
+		var amount float32
+		if err := rows.Scan(&ID, &amount); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		amounts[ID] = amount
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %v", err)
+	}
+	return amounts, nil
+}
@@ -0,0 +1,35 @@
+package tiers
+
+import (
+	"context"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/testdb"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDBRollingAccrualsIgnoreMultiplier(t *testing.T) {
+	ctx := context.Background()
+	db := testdb.Open(t)
+	tiers, err := NewDBTiers(db, &sync.RWMutex{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	UID, _ := testdb.CreateUser(t, db)
+	now := time.Now().UTC()
+	// The accrual service answered 100, a 1.5 multiplier credited 150.
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO orders (user_id, order_number, created_at, status, bonus_amount, credited_amount, credited_at) 
+		VALUES ($1, $2, $3, 'PROCESSED', 100, 150, $3);`, UID, testdb.OrderNumber(t), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	amounts, err := tiers.GetRollingAmounts(ctx, models.TierBasisAccrual, now.Add(-time.Hour), UID)
+	if err != nil {
+		t.Fatalf("GetRollingAmounts: %v", err)
+	}
+	if amounts[UID] != 100 {
+		t.Errorf("rolling amount = %v, want 100", amounts[UID])
+	}
+}
@@ -0,0 +1,13 @@
+package tiers
+
+import (
+	"context"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+)
+
+type TierService interface {
+	GetStatus(ctx context.Context, UID int) (models.TierStatus, error)
+	Multiplier(ctx context.Context, UID int) (float32, error)
+	Evaluate(ctx context.Context) (int, error)
+	RunEvaluator(ctx context.Context) error
+}
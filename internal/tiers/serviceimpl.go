@@ -0,0 +1,199 @@
+package tiers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"go.uber.org/zap"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTiers is used when a deployment does not configure its own.
+const DefaultTiers = "Bronze:0:1,Silver:1000:1.05,Gold:5000:1.1"
+
+type Config struct {
+	// Tiers in "name:threshold:multiplier" entries separated by ",".
+	Tiers string
+	// Basis is what the rolling amount sums: accrual or spend.
+	Basis            string
+	Window           time.Duration
+	EvaluateInterval time.Duration
+}
+
+type TService struct {
+	conn  DatabaseTiers
+	cfg   Config
+	tiers []models.Tier
+}
+
+func NewTService(conn DatabaseTiers, cfg Config) (*TService, error) {
+	if cfg.Basis != models.TierBasisAccrual && cfg.Basis != models.TierBasisSpend {
+		return nil, fmt.Errorf("unknown tier basis %q", cfg.Basis)
+	}
+	tiers, err := ParseTiers(cfg.Tiers)
+	if err != nil {
+		return nil, err
+	}
+	return &TService{conn: conn, cfg: cfg, tiers: tiers}, nil
+}
+
+// ParseTiers reads the Config.Tiers syntax and returns the tiers ordered by
+// threshold. The lowest tier is where every user starts.
+func ParseTiers(spec string) ([]models.Tier, error) {
+	tiers := make([]models.Tier, 0)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid tier %q, want name:threshold:multiplier", entry)
+		}
+		threshold, err := strconv.ParseFloat(parts[1], 32)
+		if err != nil || threshold < 0 {
+			return nil, fmt.Errorf("invalid threshold of tier %q", parts[0])
+		}
+		multiplier, err := strconv.ParseFloat(parts[2], 32)
+		if err != nil || multiplier <= 0 {
+			return nil, fmt.Errorf("invalid multiplier of tier %q", parts[0])
+		}
+		tiers = append(tiers, models.Tier{Name: parts[0], Threshold: float32(threshold), Multiplier: float32(multiplier)})
+	}
+	if len(tiers) == 0 {
+		return nil, errors.New("no tiers configured")
+	}
+	slices.SortFunc(tiers, func(a, b models.Tier) int {
+		if a.Threshold < b.Threshold {
+			return -1
+		} else if a.Threshold > b.Threshold {
+			return 1
+		}
+		return 0
+	})
+	return tiers, nil
+}
+
+// tierFor returns the index of the highest tier reached by rolling.
+func (s *TService) tierFor(rolling float32) int {
+	idx := 0
+	for i, tier := range s.tiers {
+		if rolling >= tier.Threshold {
+			idx = i
+		}
+	}
+	return idx
+}
+
+func (s *TService) tierIndex(name string) int {
+	for i, tier := range s.tiers {
+		if tier.Name == name {
+			return i
+		}
+	}
+	return 0
+}
+
+// userTier returns the stored tier, evaluating users the nightly job has
+// not seen yet.
+func (s *TService) userTier(ctx context.Context, UID int) (models.UserTier, error) {
+	tier, err := s.conn.GetUserTier(ctx, UID)
+	if err == nil {
+		return tier, nil
+	}
+	if !errors.Is(err, models.ErrNoData) {
+		return models.UserTier{}, err
+	}
+	evaluated, err := s.evaluate(ctx, UID)
+	if err != nil {
+		return models.UserTier{}, err
+	}
+	if len(evaluated) == 0 {
+		return models.UserTier{}, models.ErrUserNotFound
+	}
+	return evaluated[0], nil
+}
+
+func (s *TService) GetStatus(ctx context.Context, UID int) (models.TierStatus, error) {
+	tier, err := s.userTier(ctx, UID)
+	if err != nil {
+		return models.TierStatus{}, err
+	}
+	idx := s.tierIndex(tier.Tier)
+	status := models.TierStatus{
+		Name:        s.tiers[idx].Name,
+		Multiplier:  s.tiers[idx].Multiplier,
+		Rolling:     tier.Rolling,
+		Progress:    1,
+		EvaluatedAt: tier.EvaluatedAt,
+	}
+	if idx+1 < len(s.tiers) {
+		current, next := s.tiers[idx], s.tiers[idx+1]
+		status.NextTier = next.Name
+		status.ToNext = max(next.Threshold-tier.Rolling, 0)
+		status.Progress = min(max((tier.Rolling-current.Threshold)/(next.Threshold-current.Threshold), 0), 1)
+	}
+	return status, nil
+}
+
+func (s *TService) Multiplier(ctx context.Context, UID int) (float32, error) {
+	tier, err := s.userTier(ctx, UID)
+	if err != nil {
+		return 1, err
+	}
+	return s.tiers[s.tierIndex(tier.Tier)].Multiplier, nil
+}
+
+// Evaluate recomputes the tier of every active user.
+func (s *TService) Evaluate(ctx context.Context) (int, error) {
+	evaluated, err := s.evaluate(ctx, 0)
+	if err != nil {
+		return 0, err
+	}
+	return len(evaluated), nil
+}
+
+func (s *TService) evaluate(ctx context.Context, UID int) ([]models.UserTier, error) {
+	now := time.Now()
+	amounts, err := s.conn.GetRollingAmounts(ctx, s.cfg.Basis, now.Add(-s.cfg.Window), UID)
+	if err != nil {
+		return nil, err
+	}
+	evaluated := make([]models.UserTier, 0, len(amounts))
+	for ID, rolling := range amounts {
+		evaluated = append(evaluated, models.UserTier{
+			UserID:      ID,
+			Tier:        s.tiers[s.tierFor(rolling)].Name,
+			Rolling:     rolling,
+			EvaluatedAt: now,
+		})
+	}
+	err = s.conn.SaveUserTiers(ctx, evaluated)
+	if err != nil {
+		return nil, err
+	}
+	return evaluated, nil
+}
+
+func (s *TService) RunEvaluator(ctx context.Context) error {
+	ticker := time.NewTicker(s.cfg.EvaluateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			evaluated, err := s.Evaluate(ctx)
+			if err != nil {
+				logger.Log.Error("tier evaluation failed", zap.Error(err))
+				continue
+			}
+			logger.Log.Info("tiers evaluated", zap.Int("users", evaluated))
+		}
+	}
+}
@@ -6,8 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Fuonder/goptherstore.git/internal/models"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/Fuonder/goptherstore.git/internal/passwords"
 	"sync"
+	"time"
 )
 
 const (
@@ -17,24 +18,59 @@ const (
 						`
 	SearchUserQuery        = `SELECT COUNT(*) FROM users WHERE login = $1;`
 	GetUIDByUserLoginQuery = `SELECT id FROM users WHERE login = $1;`
+	GetLoginByUIDQuery     = `SELECT login FROM users WHERE id = $1;`
+	UpdatePasswordQuery    = `UPDATE users SET password_hash = $1, password_changed_at = $2 WHERE id = $3;`
+	GetAuthStateQuery      = `SELECT id, role, password_changed_at FROM users WHERE login = $1;`
+	GetUserByIDQuery       = `SELECT id, login, role, created_at FROM users WHERE id = $1;`
+	UpdateRoleQuery        = `UPDATE users SET role = $1 WHERE id = $2;`
+	UpdateRoleByLoginQuery = `UPDATE users SET role = $1 WHERE login = $2;`
+	GetProfileQuery        = `
+						SELECT id, login, COALESCE(display_name, ''), COALESCE(email, ''), role, created_at 
+						FROM users 
+						WHERE id = $1;`
+	UpdateProfileQuery = `
+						UPDATE users 
+						SET display_name = COALESCE($1, display_name), email = COALESCE($2, email) 
+						WHERE id = $3;`
+	GetProfileStatsQuery = `
+						SELECT 
+							(SELECT COUNT(*) FROM orders WHERE user_id = $1), 
+							(SELECT COALESCE(SUM(credited_amount), 0) FROM orders WHERE user_id = $1 AND status = $2), 
+							COALESCE((SELECT total_withdrawn FROM wallets WHERE user_id = $1), 0);`
 )
 
 type DatabaseUsers interface {
 	CreateUser(ctx context.Context, newUser models.MartUser) error
 	CheckLoginPresence(ctx context.Context, user models.MartUser) error
 	GetUIDByUsername(ctx context.Context, username string) (int, error)
+	GetLoginByUID(ctx context.Context, UID int) (string, error)
+	UpdatePassword(ctx context.Context, UID int, newPassword string) error
+	GetAuthState(ctx context.Context, login string) (models.AuthState, error)
+	GetUserByID(ctx context.Context, UID int) (models.MartUser, error)
+	SetRole(ctx context.Context, UID int, role string) error
+	SetRoleByLogin(ctx context.Context, login string, role string) error
+	GetProfile(ctx context.Context, UID int) (models.Profile, error)
+	UpdateProfile(ctx context.Context, UID int, update models.ProfileUpdate) error
+	GetProfileStats(ctx context.Context, UID int) (models.ProfileStats, error)
 }
 
 type DBUsers struct {
-	db *sql.DB
-	mu *sync.RWMutex
+	db     *sql.DB
+	mu     *sync.RWMutex
+	hasher passwords.PasswordHasher
 }
 
-func NewDBUsers(db *sql.DB, mu *sync.RWMutex) (*DBUsers, error) {
-	return &DBUsers{db: db, mu: mu}, nil
+func NewDBUsers(db *sql.DB, mu *sync.RWMutex, hasher passwords.PasswordHasher) (*DBUsers, error) {
+	return &DBUsers{db: db, mu: mu, hasher: hasher}, nil
 }
 
 func (u *DBUsers) CreateUser(ctx context.Context, newUser models.MartUser) error {
+	// Hashing is slow on purpose, it must not hold the shared lock.
+	hashedPassword, err := u.hasher.Hash(newUser.Password)
+	if err != nil {
+		return err
+	}
+
 	u.mu.Lock()
 	defer u.mu.Unlock()
 	tx, err := u.db.BeginTx(ctx, nil)
@@ -43,15 +79,11 @@ func (u *DBUsers) CreateUser(ctx context.Context, newUser models.MartUser) error
 	}
 	defer tx.Rollback()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newUser.Password), bcrypt.DefaultCost)
-	if err != nil {
-		return err
-	}
 	// login, pwd, date
 	_, err = tx.ExecContext(
 		ctx, InsertUserQuery,
 		newUser.Login,
-		string(hashedPassword),
+		hashedPassword,
 		newUser.CreatedAt,
 	)
 	if err != nil {
@@ -89,3 +121,150 @@ func (u *DBUsers) GetUIDByUsername(ctx context.Context, username string) (int, e
 	}
 	return UID, nil
 }
+
+func (u *DBUsers) GetLoginByUID(ctx context.Context, UID int) (string, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	var login string
+	err := u.db.QueryRowContext(ctx, GetLoginByUIDQuery, UID).Scan(&login)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", models.ErrUserNotFound
+		}
+		return "", err
+	}
+	return login, nil
+}
+
+func (u *DBUsers) UpdatePassword(ctx context.Context, UID int, newPassword string) error {
+	hashedPassword, err := u.hasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx, UpdatePasswordQuery,
+		hashedPassword,
+		time.Now(),
+		UID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (u *DBUsers) GetAuthState(ctx context.Context, login string) (models.AuthState, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	var state models.AuthState
+	var changedAt sql.NullTime
+	err := u.db.QueryRowContext(ctx, GetAuthStateQuery, login).Scan(&state.UID, &state.Role, &changedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.AuthState{}, models.ErrUserNotFound
+		}
+		return models.AuthState{}, err
+	}
+	state.PasswordChangedAt = changedAt.Time
+	return state, nil
+}
+
+func (u *DBUsers) GetUserByID(ctx context.Context, UID int) (models.MartUser, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	var user models.MartUser
+	err := u.db.QueryRowContext(ctx, GetUserByIDQuery, UID).Scan(&user.ID, &user.Login, &user.Role, &user.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.MartUser{}, models.ErrUserNotFound
+		}
+		return models.MartUser{}, err
+	}
+	return user, nil
+}
+
+func (u *DBUsers) SetRole(ctx context.Context, UID int, role string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	res, err := u.db.ExecContext(ctx, UpdateRoleQuery, role, UID)
+	if err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+	return userAffected(res)
+}
+
+func (u *DBUsers) SetRoleByLogin(ctx context.Context, login string, role string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	res, err := u.db.ExecContext(ctx, UpdateRoleByLoginQuery, role, login)
+	if err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+	return userAffected(res)
+}
+
+func (u *DBUsers) GetProfile(ctx context.Context, UID int) (models.Profile, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	var profile models.Profile
+	err := u.db.QueryRowContext(ctx, GetProfileQuery, UID).Scan(
+		&profile.ID,
+		&profile.Login,
+		&profile.DisplayName,
+		&profile.Email,
+		&profile.Role,
+		&profile.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Profile{}, models.ErrUserNotFound
+		}
+		return models.Profile{}, err
+	}
+	return profile, nil
+}
+
+func (u *DBUsers) UpdateProfile(ctx context.Context, UID int, update models.ProfileUpdate) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	res, err := u.db.ExecContext(ctx, UpdateProfileQuery, update.DisplayName, update.Email, UID)
+	if err != nil {
+		return fmt.Errorf("failed to update profile: %w", err)
+	}
+	return userAffected(res)
+}
+
+func (u *DBUsers) GetProfileStats(ctx context.Context, UID int) (models.ProfileStats, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	var stats models.ProfileStats
+	err := u.db.QueryRowContext(ctx, GetProfileStatsQuery, UID, models.OrderStatusProcessed).Scan(
+		&stats.OrderCount,
+		&stats.LifetimeAccrual,
+		&stats.LifetimeWithdrawn,
+	)
+	if err != nil {
+		return models.ProfileStats{}, fmt.Errorf("failed to get profile stats: %w", err)
+	}
+	return stats, nil
+}
+
+func userAffected(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return models.ErrUserNotFound
+	}
+	return nil
+}
@@ -1,7 +1,12 @@
 package users
 
-import "context"
+import (
+	"context"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+)
 
 type UserService interface {
 	GetUID(ctx context.Context, login string) (int, error)
+	GetProfile(ctx context.Context, UID int) (models.Profile, error)
+	UpdateProfile(ctx context.Context, UID int, update models.ProfileUpdate) (models.Profile, error)
 }
@@ -1,6 +1,16 @@
 package users
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const maxDisplayNameLen = 64
 
 type UService struct {
 	conn DatabaseUsers
@@ -17,3 +27,45 @@ func (s *UService) GetUID(ctx context.Context, login string) (int, error) {
 	}
 	return UID, nil
 }
+
+func (s *UService) GetProfile(ctx context.Context, UID int) (models.Profile, error) {
+	profile, err := s.conn.GetProfile(ctx, UID)
+	if err != nil {
+		return models.Profile{}, err
+	}
+	profile.Stats, err = s.conn.GetProfileStats(ctx, UID)
+	if err != nil {
+		return models.Profile{}, err
+	}
+	return profile, nil
+}
+
+func (s *UService) UpdateProfile(ctx context.Context, UID int, update models.ProfileUpdate) (models.Profile, error) {
+	if update.DisplayName != nil {
+		name := strings.TrimSpace(*update.DisplayName)
+		if utf8.RuneCountInString(name) > maxDisplayNameLen {
+			return models.Profile{}, fmt.Errorf("%w: display name must be at most %d characters", models.ErrInvalidProfile, maxDisplayNameLen)
+		}
+		if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+			return models.Profile{}, fmt.Errorf("%w: display name contains control characters", models.ErrInvalidProfile)
+		}
+		update.DisplayName = &name
+	}
+	if update.Email != nil {
+		email := strings.TrimSpace(*update.Email)
+		// An empty string clears the address.
+		if email != "" {
+			addr, err := mail.ParseAddress(email)
+			if err != nil || addr.Address != email {
+				return models.Profile{}, fmt.Errorf("%w: malformed email address", models.ErrInvalidProfile)
+			}
+		}
+		update.Email = &email
+	}
+
+	err := s.conn.UpdateProfile(ctx, UID, update)
+	if err != nil {
+		return models.Profile{}, err
+	}
+	return s.GetProfile(ctx, UID)
+}
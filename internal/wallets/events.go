@@ -0,0 +1,28 @@
+package wallets
+
+import (
+	"context"
+	"github.com/Fuonder/goptherstore.git/internal/events"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"go.uber.org/zap"
+)
+
+// PublishBalance announces the wallet state after a change of delta.
+func PublishBalance(ctx context.Context, conn DatabaseWallets, pub events.Publisher, UID int, delta float32, reason string) {
+	wallet, err := conn.GetUserWallet(ctx, UID)
+	if err != nil {
+		logger.Log.Warn("can not read wallet for balance event", zap.Int("uid", UID), zap.Error(err))
+		return
+	}
+	pub.Publish(ctx, models.Event{
+		Type:   models.EventBalanceChanged,
+		UserID: UID,
+		Data: models.BalanceData{
+			Current:   wallet.Balance,
+			Withdrawn: wallet.TotalWithdraw,
+			Delta:     delta,
+			Reason:    reason,
+		},
+	})
+}
@@ -0,0 +1,259 @@
+package wallets
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"time"
+)
+
+const (
+	holdColumns = `
+						id, user_id, amount, captured, COALESCE(order_number, ''), status, 
+						COALESCE(withdrawal_id, 0), lots_expire_at, expires_at, created_at, settled_at`
+	TakeHoldBalance = `UPDATE wallets SET balance = balance - $1, held = held + $1 WHERE user_id = $2 AND balance >= $1;`
+	InsertHoldQuery = `
+						INSERT INTO balance_holds (user_id, amount, order_number, status, lots_expire_at, expires_at, created_at) 
+						VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id;`
+	LockHoldQuery = `SELECT` + holdColumns + `
+						FROM balance_holds 
+						WHERE id = $1 
+						FOR UPDATE;`
+	GetHoldsQuery = `SELECT` + holdColumns + `
+						FROM balance_holds 
+						WHERE user_id = $1 
+						ORDER BY created_at DESC, id DESC;`
+	ExpiredHoldsQuery  = `SELECT id FROM balance_holds WHERE status = 'ACTIVE' AND expires_at <= $1 ORDER BY id;`
+	ReleaseHoldBalance = `
+						UPDATE wallets SET balance = balance + $1, held = held - $2, total_withdrawn = total_withdrawn + $3 
+						WHERE user_id = $4;`
+	SettleHoldQuery = `
+						UPDATE balance_holds 
+						SET status = $2, captured = $3, order_number = $4, withdrawal_id = $5, settled_at = $6 
+						WHERE id = $1;`
+)
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanHold(row rowScanner) (models.BalanceHold, error) {
+	var h models.BalanceHold
+	var lotsExpireAt, settledAt sql.NullTime
+	err := row.Scan(
+		&h.ID,
+		&h.UserID,
+		&h.Amount,
+		&h.Captured,
+		&h.OrderID,
+		&h.Status,
+		&h.WithdrawalID,
+		&lotsExpireAt,
+		&h.ExpiresAt,
+		&h.CreatedAt,
+		&settledAt,
+	)
+	if err != nil {
+		return models.BalanceHold{}, err
+	}
+	h.LotsExpireAt = lotsExpireAt.Time
+	if settledAt.Valid {
+		h.SettledAt = &settledAt.Time
+	}
+	return h, nil
+}
+
+// CreateHold moves the sum from the balance to held. The points leave
+// their lots right away, so expiry can not take held points.
+func (w *DBWallets) CreateHold(ctx context.Context, hold models.BalanceHold) (models.BalanceHold, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.BalanceHold{}, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, TakeHoldBalance, hold.Amount, hold.UserID)
+	if err != nil {
+		return models.BalanceHold{}, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return models.BalanceHold{}, err
+	}
+	if affected == 0 {
+		return models.BalanceHold{}, models.ErrNotEnoughBonuses
+	}
+	usages, err := consumeLots(ctx, tx, hold.UserID, hold.Amount, 0)
+	if err != nil {
+		return models.BalanceHold{}, err
+	}
+	hold.LotsExpireAt = soonestExpiry(usages)
+
+	var order sql.NullString
+	if hold.OrderID != "" {
+		order = sql.NullString{String: hold.OrderID, Valid: true}
+	}
+	var lotsExpireAt sql.NullTime
+	if !hold.LotsExpireAt.IsZero() {
+		lotsExpireAt = sql.NullTime{Time: hold.LotsExpireAt, Valid: true}
+	}
+	hold.Status = models.HoldStatusActive
+	err = tx.QueryRowContext(
+		ctx, InsertHoldQuery,
+		hold.UserID,
+		hold.Amount,
+		order,
+		hold.Status,
+		lotsExpireAt,
+		hold.ExpiresAt,
+		hold.CreatedAt,
+	).Scan(&hold.ID)
+	if err != nil {
+		return models.BalanceHold{}, fmt.Errorf("failed to create hold: %w", err)
+	}
+	return hold, tx.Commit()
+}
+
+// SettleHold finalizes an active hold of UID. A positive capture is booked
+// as a completed withdrawal of that sum on the hold's order, whatever is
+// left goes back to the balance. Capturing zero takes the whole hold. UID
+// 0 skips the owner check. uniqueOrder claims the order number like
+// Withdrawal.UniqueOrder does.
+func (w *DBWallets) SettleHold(ctx context.Context, ID int, UID int, status string, capture float32, orderNumber string, uniqueOrder bool, now time.Time) (models.BalanceHold, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.BalanceHold{}, err
+	}
+	defer tx.Rollback()
+
+	hold, err := scanHold(tx.QueryRowContext(ctx, LockHoldQuery, ID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.BalanceHold{}, models.ErrHoldNotFound
+		}
+		return models.BalanceHold{}, fmt.Errorf("failed to lock hold: %w", err)
+	}
+	if UID != 0 && hold.UserID != UID {
+		return models.BalanceHold{}, models.ErrHoldNotFound
+	}
+	if hold.Status != models.HoldStatusActive {
+		return models.BalanceHold{}, models.ErrHoldNotActive
+	}
+	// An expired hold waiting for the sweeper can only be released.
+	if status == models.HoldStatusCaptured && !hold.ExpiresAt.After(now) {
+		return models.BalanceHold{}, models.ErrHoldNotActive
+	}
+	if status == models.HoldStatusCaptured && capture == 0 {
+		capture = hold.Amount
+	}
+	if capture > hold.Amount {
+		return models.BalanceHold{}, fmt.Errorf("%w: capture exceeds the held amount", models.ErrInvalidHold)
+	}
+	if orderNumber == "" {
+		orderNumber = hold.OrderID
+	}
+	if capture > 0 && orderNumber == "" {
+		return models.BalanceHold{}, fmt.Errorf("%w: an order is required to capture", models.ErrInvalidHold)
+	}
+
+	if capture > 0 {
+		err = tx.QueryRowContext(
+			ctx, InsertWithdraw,
+			hold.UserID,
+			orderNumber,
+			capture,
+			now,
+			models.WithdrawalStatusCompleted,
+			uniqueOrder,
+		).Scan(&hold.WithdrawalID)
+		if err != nil {
+			if isOrderUsed(err) {
+				return models.BalanceHold{}, models.ErrOrderAlreadyUsed
+			}
+			return models.BalanceHold{}, err
+		}
+	}
+	rest := hold.Amount - capture
+	_, err = tx.ExecContext(ctx, ReleaseHoldBalance, rest, hold.Amount, capture, hold.UserID)
+	if err != nil {
+		return models.BalanceHold{}, err
+	}
+	err = insertLot(ctx, tx, models.PointLot{
+		UserID:    hold.UserID,
+		Source:    models.LotSourceHold,
+		Amount:    rest,
+		EarnedAt:  now,
+		ExpiresAt: hold.LotsExpireAt,
+	})
+	if err != nil {
+		return models.BalanceHold{}, err
+	}
+
+	var order sql.NullString
+	if orderNumber != "" {
+		order = sql.NullString{String: orderNumber, Valid: true}
+	}
+	var withdrawalID sql.NullInt64
+	if hold.WithdrawalID != 0 {
+		withdrawalID = sql.NullInt64{Int64: int64(hold.WithdrawalID), Valid: true}
+	}
+	_, err = tx.ExecContext(ctx, SettleHoldQuery, hold.ID, status, capture, order, withdrawalID, now)
+	if err != nil {
+		return models.BalanceHold{}, fmt.Errorf("failed to settle hold: %w", err)
+	}
+	hold.Status, hold.Captured, hold.OrderID, hold.SettledAt = status, capture, orderNumber, &now
+	return hold, tx.Commit()
+}
+
+func (w *DBWallets) GetHolds(ctx context.Context, UID int) ([]models.BalanceHold, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	rows, err := w.db.QueryContext(ctx, GetHoldsQuery, UID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query holds: %v", err)
+	}
+	defer rows.Close()
+	holds := make([]models.BalanceHold, 0)
+	for rows.Next() {
+		hold, err := scanHold(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		holds = append(holds, hold)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %v", err)
+	}
+	return holds, nil
+}
+
+// GetExpiredHolds lists the active holds past their expiry at now.
+func (w *DBWallets) GetExpiredHolds(ctx context.Context, now time.Time) ([]int, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	rows, err := w.db.QueryContext(ctx, ExpiredHoldsQuery, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expired holds: %v", err)
+	}
+	defer rows.Close()
+	IDs := make([]int, 0)
+	for rows.Next() {
+		var ID int
+		if err := rows.Scan(&ID); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		IDs = append(IDs, ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %v", err)
+	}
+	return IDs, nil
+}
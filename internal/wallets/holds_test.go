@@ -0,0 +1,180 @@
+package wallets
+
+import (
+	"context"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/testdb"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func hold(t *testing.T, w *DBWallets, UID int, amount float32, expiresAt time.Time) models.BalanceHold {
+	t.Helper()
+	h, err := w.CreateHold(context.Background(), models.BalanceHold{
+		UserID:    UID,
+		Amount:    amount,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("CreateHold: %v", err)
+	}
+	return h
+}
+
+func checkHeld(t *testing.T, w *DBWallets, UID int, held float32) {
+	t.Helper()
+	wallet, err := w.GetUserWallet(context.Background(), UID)
+	if err != nil {
+		t.Fatalf("GetUserWallet: %v", err)
+	}
+	if wallet.Held != held {
+		t.Errorf("held = %.2f, want %.2f", wallet.Held, held)
+	}
+}
+
+func TestDBCreateHold(t *testing.T) {
+	w, db := newTestDBWallets(t)
+	UID, _ := testdb.CreateUser(t, db)
+	soon := time.Now().UTC().Add(24 * time.Hour)
+	credit(t, w, UID, 30, time.Now().Add(-2*time.Hour), soon)
+	credit(t, w, UID, 70, time.Now().Add(-time.Hour), time.Time{})
+
+	_, err := w.CreateHold(context.Background(), models.BalanceHold{UserID: UID, Amount: 101, ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now()})
+	if !errors.Is(err, models.ErrNotEnoughBonuses) {
+		t.Fatalf("overdraft: error = %v, want ErrNotEnoughBonuses", err)
+	}
+	checkWallet(t, w, UID, 100, 0)
+	checkHeld(t, w, UID, 0)
+
+	h := hold(t, w, UID, 40, time.Now().Add(time.Hour))
+	if h.ID == 0 || h.Status != models.HoldStatusActive {
+		t.Errorf("hold = %+v", h)
+	}
+	if h.LotsExpireAt.Sub(soon).Abs() > time.Second {
+		t.Errorf("lots expire at %v, want %v", h.LotsExpireAt, soon)
+	}
+	checkWallet(t, w, UID, 60, 0)
+	checkHeld(t, w, UID, 40)
+	if got := lotsRemaining(t, db, UID); got != 60 {
+		t.Errorf("lots = %.2f, want 60", got)
+	}
+}
+
+func TestDBSettleHold(t *testing.T) {
+	ctx := context.Background()
+	w, db := newTestDBWallets(t)
+	UID, _ := testdb.CreateUser(t, db)
+	credit(t, w, UID, 100, time.Now(), time.Time{})
+
+	t.Run("partial capture", func(t *testing.T) {
+		h := hold(t, w, UID, 40, time.Now().Add(time.Hour))
+		captured, err := w.SettleHold(ctx, h.ID, UID, models.HoldStatusCaptured, 15, testdb.OrderNumber(t), false, time.Now())
+		if err != nil {
+			t.Fatalf("capture: %v", err)
+		}
+		if captured.Status != models.HoldStatusCaptured || captured.Captured != 15 || captured.WithdrawalID == 0 {
+			t.Errorf("captured hold = %+v", captured)
+		}
+		checkWallet(t, w, UID, 85, 15)
+		checkHeld(t, w, UID, 0)
+		if got := lotsRemaining(t, db, UID); got != 85 {
+			t.Errorf("lots = %.2f, want 85", got)
+		}
+		_, err = w.SettleHold(ctx, h.ID, UID, models.HoldStatusReleased, 0, "", false, time.Now())
+		if !errors.Is(err, models.ErrHoldNotActive) {
+			t.Errorf("settle twice: error = %v, want ErrHoldNotActive", err)
+		}
+	})
+
+	t.Run("release", func(t *testing.T) {
+		h := hold(t, w, UID, 25, time.Now().Add(time.Hour))
+		if _, err := w.SettleHold(ctx, h.ID, UID+1, models.HoldStatusReleased, 0, "", false, time.Now()); !errors.Is(err, models.ErrHoldNotFound) {
+			t.Errorf("foreign release: error = %v, want ErrHoldNotFound", err)
+		}
+		released, err := w.SettleHold(ctx, h.ID, UID, models.HoldStatusReleased, 0, "", false, time.Now())
+		if err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		if released.Status != models.HoldStatusReleased || released.Captured != 0 || released.WithdrawalID != 0 {
+			t.Errorf("released hold = %+v", released)
+		}
+		checkWallet(t, w, UID, 85, 15)
+		checkHeld(t, w, UID, 0)
+		if got := lotsRemaining(t, db, UID); got != 85 {
+			t.Errorf("lots = %.2f, want 85", got)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		h := hold(t, w, UID, 10, time.Now().Add(-time.Minute))
+		_, err := w.SettleHold(ctx, h.ID, UID, models.HoldStatusCaptured, 0, testdb.OrderNumber(t), false, time.Now())
+		if !errors.Is(err, models.ErrHoldNotActive) {
+			t.Errorf("capture expired: error = %v, want ErrHoldNotActive", err)
+		}
+		IDs, err := w.GetExpiredHolds(ctx, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Contains(IDs, h.ID) {
+			t.Fatalf("expired holds %v miss %d", IDs, h.ID)
+		}
+		expired, err := w.SettleHold(ctx, h.ID, 0, models.HoldStatusExpired, 0, "", false, time.Now())
+		if err != nil {
+			t.Fatalf("expire: %v", err)
+		}
+		if expired.Status != models.HoldStatusExpired {
+			t.Errorf("expired hold = %+v", expired)
+		}
+		checkWallet(t, w, UID, 85, 15)
+		checkHeld(t, w, UID, 0)
+	})
+}
+
+// TestDBHoldSweeperRacesCapture settles the same holds from a capture and
+// from the sweeper at once, exactly one of them has to win each time.
+func TestDBHoldSweeperRacesCapture(t *testing.T) {
+	ctx := context.Background()
+	w, db := newTestDBWallets(t)
+	UID, _ := testdb.CreateUser(t, db)
+	credit(t, w, UID, 100, time.Now(), time.Time{})
+
+	const rounds = 10
+	var captured float32
+	for range rounds {
+		expiresAt := time.Now().Add(time.Minute)
+		h := hold(t, w, UID, 5, expiresAt)
+		order := testdb.OrderNumber(t)
+
+		var captureErr, sweepErr error
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			replica := &DBWallets{db: db, mu: &sync.RWMutex{}}
+			_, captureErr = replica.SettleHold(ctx, h.ID, UID, models.HoldStatusCaptured, 0, order, false, time.Now())
+		}()
+		go func() {
+			defer wg.Done()
+			replica := &DBWallets{db: db, mu: &sync.RWMutex{}}
+			_, sweepErr = replica.SettleHold(ctx, h.ID, 0, models.HoldStatusExpired, 0, "", false, expiresAt.Add(time.Second))
+		}()
+		wg.Wait()
+
+		switch {
+		case captureErr == nil && errors.Is(sweepErr, models.ErrHoldNotActive):
+			captured += h.Amount
+		case sweepErr == nil && errors.Is(captureErr, models.ErrHoldNotActive):
+		default:
+			t.Fatalf("capture error %v, sweep error %v: want exactly one winner", captureErr, sweepErr)
+		}
+	}
+	checkWallet(t, w, UID, 100-captured, captured)
+	checkHeld(t, w, UID, 0)
+	if got := lotsRemaining(t, db, UID); got != 100-captured {
+		t.Errorf("lots = %.2f, want %.2f", got, 100-captured)
+	}
+}
@@ -0,0 +1,218 @@
+package wallets
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"time"
+)
+
+const (
+	InsertLotQuery = `
+						INSERT INTO point_lots (user_id, order_number, source, amount, remaining, earned_at, expires_at) 
+						VALUES ($1, $2, $3, $4, $4, $5, $6);`
+	LockActiveLotsQuery = `
+						SELECT id, remaining, expires_at FROM point_lots 
+						WHERE user_id = $1 AND remaining > 0 AND (expires_at IS NULL OR expires_at > $2) 
+						ORDER BY earned_at, id 
+						FOR UPDATE;`
+	ConsumeLotQuery     = `UPDATE point_lots SET remaining = remaining - $2 WHERE id = $1;`
+	InsertLotUsageQuery = `INSERT INTO withdrawal_lots (withdrawal_id, lot_id, amount) VALUES ($1, $2, $3);`
+	RestoreLotsQuery    = `
+						UPDATE point_lots l SET remaining = l.remaining + u.amount 
+						FROM withdrawal_lots u 
+						WHERE u.withdrawal_id = $1 AND u.lot_id = l.id;`
+	DeleteLotUsageQuery = `DELETE FROM withdrawal_lots WHERE withdrawal_id = $1;`
+	ExpireLotsQuery     = `
+						WITH due AS (
+							SELECT id, remaining FROM point_lots 
+							WHERE remaining > 0 AND expires_at <= $1 
+							FOR UPDATE
+						), expired AS (
+							UPDATE point_lots l SET expired_amount = l.expired_amount + due.remaining, remaining = 0 
+							FROM due 
+							WHERE l.id = due.id 
+							RETURNING l.user_id, due.remaining AS amount
+						) 
+						SELECT user_id, SUM(amount) FROM expired GROUP BY user_id;`
+	ScheduleLegacyLotsQuery = `
+						UPDATE point_lots SET expires_at = $1 
+						WHERE source = 'legacy' AND expires_at IS NULL AND remaining > 0;`
+	ExpireBalanceQuery  = `UPDATE wallets SET balance = balance - LEAST(balance, $1) WHERE user_id = $2;`
+	ExpiringPointsQuery = `
+						SELECT COALESCE(SUM(remaining), 0), MIN(expires_at) 
+						FROM point_lots 
+						WHERE user_id = $1 AND remaining > 0 AND expires_at > $2 AND expires_at <= $3;`
+	ExpiryAdjustmentReason = "points expired"
+)
+
+// insertLot records a credit as a lot, non-positive amounts are not lots.
+func insertLot(ctx context.Context, tx *sql.Tx, lot models.PointLot) error {
+	if lot.Amount <= 0 {
+		return nil
+	}
+	var order sql.NullString
+	if lot.OrderID != "" {
+		order = sql.NullString{String: lot.OrderID, Valid: true}
+	}
+	var expiresAt sql.NullTime
+	if !lot.ExpiresAt.IsZero() {
+		expiresAt = sql.NullTime{Time: lot.ExpiresAt, Valid: true}
+	}
+	_, err := tx.ExecContext(ctx, InsertLotQuery, lot.UserID, order, lot.Source, lot.Amount, lot.EarnedAt, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to write point lot: %w", err)
+	}
+	return nil
+}
+
+// lotUsage is the part of a lot spent by a debit.
+type lotUsage struct {
+	lotID     int
+	amount    float32
+	expiresAt time.Time
+}
+
+// consumeLots spends amount from the oldest live lots of the user. Running
+// out of lots is not an error, the balance check already passed and lots
+// may trail it by a rounding remainder. When withdrawalID is set the usage is remembered for refunds.
+// It returns what was taken from each lot, oldest first.
+func consumeLots(ctx context.Context, tx *sql.Tx, UID int, amount float32, withdrawalID int) ([]lotUsage, error) {
+	rows, err := tx.QueryContext(ctx, LockActiveLotsQuery, UID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock point lots: %w", err)
+	}
+	usages := make([]lotUsage, 0)
+	left := amount
+	for rows.Next() && left > 0 {
+		var u lotUsage
+		var remaining float32
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&u.lotID, &remaining, &expiresAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		u.expiresAt = expiresAt.Time
+		u.amount = min(remaining, left)
+		left -= u.amount
+		usages = append(usages, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %v", err)
+	}
+
+	for _, u := range usages {
+		_, err = tx.ExecContext(ctx, ConsumeLotQuery, u.lotID, u.amount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to consume point lot: %w", err)
+		}
+		if withdrawalID != 0 {
+			_, err = tx.ExecContext(ctx, InsertLotUsageQuery, withdrawalID, u.lotID, u.amount)
+			if err != nil {
+				return nil, fmt.Errorf("failed to record point lot usage: %w", err)
+			}
+		}
+	}
+	return usages, nil
+}
+
+// soonestExpiry returns the soonest expiry among the spent lots, zero if
+// none expire.
+func soonestExpiry(usages []lotUsage) time.Time {
+	var soonest time.Time
+	for _, u := range usages {
+		if !u.expiresAt.IsZero() && (soonest.IsZero() || u.expiresAt.Before(soonest)) {
+			soonest = u.expiresAt
+		}
+	}
+	return soonest
+}
+
+// restoreLots gives the points of a refunded withdrawal back to the lots
+// they came from. Lots that expired meanwhile are picked up by the next
+// expiry run.
+func restoreLots(ctx context.Context, tx *sql.Tx, withdrawalID int) error {
+	_, err := tx.ExecContext(ctx, RestoreLotsQuery, withdrawalID)
+	if err != nil {
+		return fmt.Errorf("failed to restore point lots: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, DeleteLotUsageQuery, withdrawalID)
+	if err != nil {
+		return fmt.Errorf("failed to clear point lot usage: %w", err)
+	}
+	return nil
+}
+
+// ExpireLots zeroes every lot expired at now, debits the remainders from
+// the wallets and records them in the adjustment ledger. It returns the
+// expired sum per user.
+func (w *DBWallets) ExpireLots(ctx context.Context, now time.Time) (map[int]float32, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, ExpireLotsQuery, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expire point lots: %w", err)
+	}
+	expired := make(map[int]float32)
+	for rows.Next() {
+		var UID int
+		var amount float32
+		if err := rows.Scan(&UID, &amount); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		expired[UID] = amount
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %v", err)
+	}
+
+	for UID, amount := range expired {
+		_, err = tx.ExecContext(ctx, ExpireBalanceQuery, amount, UID)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, InsertAdjustment, UID, nil, -amount, ExpiryAdjustmentReason, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return expired, tx.Commit()
+}
+
+// ScheduleLegacyLots gives legacy lots without an expiry the expiry date
+// expiresAt. Their earning date is unknown, so the clock starts when the
+// policy first applies to them.
+func (w *DBWallets) ScheduleLegacyLots(ctx context.Context, expiresAt time.Time) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := w.db.ExecContext(ctx, ScheduleLegacyLotsQuery, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to schedule legacy point lots: %w", err)
+	}
+	return nil
+}
+
+// GetExpiringPoints sums the live points of the user expiring in (now, until].
+func (w *DBWallets) GetExpiringPoints(ctx context.Context, UID int, now time.Time, until time.Time) (models.ExpiringPoints, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	expiring := models.ExpiringPoints{Until: until}
+	var next sql.NullTime
+	err := w.db.QueryRowContext(ctx, ExpiringPointsQuery, UID, now, until).Scan(&expiring.Amount, &next)
+	if err != nil {
+		return models.ExpiringPoints{}, fmt.Errorf("failed to get expiring points: %w", err)
+	}
+	expiring.NextExpiresAt = next.Time
+	return expiring, nil
+}
@@ -0,0 +1,139 @@
+package wallets
+
+import (
+	"context"
+	"database/sql"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/testdb"
+	"testing"
+	"time"
+)
+
+type lotRow struct {
+	source    string
+	remaining float32
+	expired   float32
+	earnedAt  time.Time
+	expiresAt sql.NullTime
+}
+
+func userLots(t *testing.T, db *sql.DB, UID int) []lotRow {
+	t.Helper()
+	rows, err := db.QueryContext(context.Background(), `
+		SELECT source, remaining, expired_amount, earned_at, expires_at 
+		FROM point_lots WHERE user_id = $1 ORDER BY earned_at, id;`, UID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var lots []lotRow
+	for rows.Next() {
+		var lot lotRow
+		if err := rows.Scan(&lot.source, &lot.remaining, &lot.expired, &lot.earnedAt, &lot.expiresAt); err != nil {
+			t.Fatal(err)
+		}
+		lots = append(lots, lot)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lots
+}
+
+func TestDBExpireLotsAccumulates(t *testing.T) {
+	ctx := context.Background()
+	w, db := newTestDBWallets(t)
+	UID, _ := testdb.CreateUser(t, db)
+	now := time.Now()
+	credit(t, w, UID, 100, now, now.Add(time.Hour))
+
+	ID := withdraw(t, w, UID, 30)
+	expired, err := w.ExpireLots(ctx, now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("ExpireLots: %v", err)
+	}
+	if expired[UID] != 70 {
+		t.Fatalf("expired = %.2f, want 70", expired[UID])
+	}
+	checkWallet(t, w, UID, 0, 0)
+
+	// The refund lands on the expired lot, the next run takes it again.
+	if _, err = w.SetWithdrawalStatus(ctx, ID, models.WithdrawalStatusPending, models.WithdrawalStatusFailed, "declined"); err != nil {
+		t.Fatalf("fail: %v", err)
+	}
+	checkWallet(t, w, UID, 30, 0)
+	expired, err = w.ExpireLots(ctx, now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("ExpireLots: %v", err)
+	}
+	if expired[UID] != 30 {
+		t.Fatalf("second run expired = %.2f, want 30", expired[UID])
+	}
+	checkWallet(t, w, UID, 0, 0)
+
+	lots := userLots(t, db, UID)
+	if len(lots) != 1 || lots[0].remaining != 0 || lots[0].expired != 100 {
+		t.Errorf("lots = %+v, want one lot with 100 expired", lots)
+	}
+}
+
+func TestDBLegacyLotBackfill(t *testing.T) {
+	ctx := context.Background()
+	w, db := newTestDBWallets(t)
+	UID, _ := testdb.CreateUser(t, db)
+	// A balance from before lots existed.
+	_, err := db.ExecContext(ctx, `UPDATE wallets SET balance = 50, created_at = $2 WHERE user_id = $1;`, UID, time.Now().Add(-48*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testdb.Migrate(t, db)
+	testdb.Migrate(t, db)
+
+	lots := userLots(t, db, UID)
+	if len(lots) != 1 || lots[0].source != models.LotSourceLegacy || lots[0].remaining != 50 || lots[0].expiresAt.Valid {
+		t.Fatalf("lots = %+v, want one legacy lot of 50", lots)
+	}
+
+	// FIFO takes the legacy lot before a newer accrual.
+	credit(t, w, UID, 20, time.Now(), time.Time{})
+	withdraw(t, w, UID, 40)
+	lots = userLots(t, db, UID)
+	if lots[0].remaining != 10 || lots[1].remaining != 20 {
+		t.Errorf("lots = %+v, want legacy 10 and accrual 20 left", lots)
+	}
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err = w.ScheduleLegacyLots(ctx, expiresAt); err != nil {
+		t.Fatalf("ScheduleLegacyLots: %v", err)
+	}
+	expired, err := w.ExpireLots(ctx, expiresAt.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("ExpireLots: %v", err)
+	}
+	if expired[UID] != 10 {
+		t.Errorf("expired = %.2f, want the 10 legacy points", expired[UID])
+	}
+	checkWallet(t, w, UID, 20, 0)
+}
+
+func TestDBPositiveAdjustmentIsLot(t *testing.T) {
+	ctx := context.Background()
+	w, db := newTestDBWallets(t)
+	UID, _ := testdb.CreateUser(t, db)
+	adminID, _ := testdb.CreateUser(t, db)
+	err := w.Adjust(ctx, models.BalanceAdjustment{UserID: UID, AdminID: adminID, Amount: 25, Reason: "goodwill", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("Adjust: %v", err)
+	}
+	if got := lotsRemaining(t, db, UID); got != 25 {
+		t.Errorf("lots = %.2f, want 25", got)
+	}
+	err = w.Adjust(ctx, models.BalanceAdjustment{UserID: UID, AdminID: adminID, Amount: -5, Reason: "correction", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("Adjust: %v", err)
+	}
+	if got := lotsRemaining(t, db, UID); got != 20 {
+		t.Errorf("lots = %.2f, want 20", got)
+	}
+	checkWallet(t, w, UID, 20, 0)
+}
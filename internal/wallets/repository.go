@@ -7,32 +7,91 @@ import (
 	"fmt"
 	"github.com/Fuonder/goptherstore.git/internal/logger"
 	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/jackc/pgx/v5/pgconn"
 	"go.uber.org/zap"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 const (
-	GetWalletByUID = `SELECT balance, total_withdrawn from wallets WHERE user_id = $1;`
+	GetWalletByUID = `SELECT balance, total_withdrawn, held from wallets WHERE user_id = $1;`
 
 	CreateUserWalletQuery = `INSERT INTO wallets (user_id, balance, total_withdrawn, created_at) VALUES ($1, $2, $3, $4);`
-	GetBalanceByUID       = `SELECT balance FROM wallets where user_id = $1;`
-	InsertWithdraw        = `INSERT INTO withdrawals (user_id, order_number, amount, created_at) VALUES ($1, $2, $3, $4);`
-	WithdrawUpdateBalance = `UPDATE wallets SET balance = balance - $1, total_withdrawn = total_withdrawn + $1 WHERE user_id = $2;`
-	GetWithdrawalsByUID   = `
-						SELECT order_number, amount, created_at 
+	// InsertWithdraw claims the order number in unique_order when $6 is set,
+	// the partial unique index on it then refuses a second claim.
+	InsertWithdraw = `
+						INSERT INTO withdrawals (user_id, order_number, amount, created_at, status, unique_order) 
+						VALUES ($1, $2, $3, $4, $5, CASE WHEN $6::boolean THEN $2 END) RETURNING id;`
+	HoldWithdrawBalance   = `UPDATE wallets SET balance = balance - $1 WHERE user_id = $2 AND balance >= $1;`
+	SettleWithdrawBalance = `
+						UPDATE wallets SET balance = balance + $1, total_withdrawn = total_withdrawn + $2 
+						WHERE user_id = $3;`
+	SetWithdrawalStatusQuery = `
+						UPDATE withdrawals SET status = $3, status_reason = $4, updated_at = $5 
+						WHERE id = $1 AND status = $2 
+						RETURNING user_id, order_number, amount, created_at;`
+	WithdrawalExistsQuery = `SELECT EXISTS (SELECT 1 FROM withdrawals WHERE id = $1);`
+	OrderWithdrawnQuery   = `
+						SELECT EXISTS (
+							SELECT 1 FROM withdrawals 
+							WHERE order_number = $1 AND status <> 'FAILED'
+						);`
+	GetWithdrawalQuery = `
+						SELECT user_id, order_number, amount, status, status_reason, created_at 
+						FROM withdrawals 
+						WHERE id = $1;`
+	GetWithdrawalsByUID = `
+						SELECT id, order_number, amount, status, COALESCE(status_reason, ''), created_at 
 						FROM withdrawals 
 						WHERE user_id = $1 
 						ORDER BY created_at DESC;`
+	FindWithdrawalsBase = `
+						SELECT id, order_number, amount, status, COALESCE(status_reason, ''), created_at 
+						FROM withdrawals 
+						WHERE user_id = $1`
+	WithdrawalTotalsBase = `
+						SELECT COUNT(*) FILTER (WHERE status IN ('PENDING', 'COMPLETED')), 
+							COALESCE(SUM(amount) FILTER (WHERE status IN ('PENDING', 'COMPLETED')), 0) 
+						FROM withdrawals 
+						WHERE user_id = $1`
 	AccrualUpdateBalance = `UPDATE wallets SET balance = balance + $1 WHERE user_id = $2;`
+	CreditOrderQuery     = `
+						UPDATE orders SET credited_amount = COALESCE(credited_amount, 0) + $1, credited_at = $2 
+						WHERE order_number = $3 AND user_id = $4;`
+	AdjustBalanceQuery = `UPDATE wallets SET balance = balance + $1 WHERE user_id = $2 AND balance + $1 >= 0;`
+	InsertAdjustment   = `
+						INSERT INTO balance_adjustments (user_id, admin_id, amount, reason, created_at) 
+						VALUES ($1, $2, $3, $4, $5);`
+
+	UniqueOrderIndex = "idx_withdrawals_unique_order"
+	uniqueViolation  = "23505"
 )
 
 type DatabaseWallets interface {
-	ProcessWithdraw(ctx context.Context, withdraw models.Withdrawal) error
+	ProcessWithdraw(ctx context.Context, withdraw models.Withdrawal) (ID int, err error)
+	SetWithdrawalStatus(ctx context.Context, ID int, from string, to string, reason string) (models.Withdrawal, error)
+	GetWithdrawal(ctx context.Context, ID int) (models.Withdrawal, error)
+	IsOrderWithdrawn(ctx context.Context, orderNumber string) (bool, error)
+	ExpireLots(ctx context.Context, now time.Time) (map[int]float32, error)
+	ScheduleLegacyLots(ctx context.Context, expiresAt time.Time) error
+	GetExpiringPoints(ctx context.Context, UID int, now time.Time, until time.Time) (models.ExpiringPoints, error)
 	GetUserWithdrawals(ctx context.Context, UID int) (withdrawals []models.Withdrawal, err error)
 	CreateUserWallet(ctx context.Context, UID int) error
-	Accrual(ctx context.Context, value float32, UID int) error
+	Accrual(ctx context.Context, lot models.PointLot) error
 	GetUserWallet(ctx context.Context, UID int) (wallet models.MartUserWallet, err error)
+	Adjust(ctx context.Context, adjustment models.BalanceAdjustment) error
+	FindUserWithdrawals(ctx context.Context, UID int, filter models.WithdrawalFilter) ([]models.Withdrawal, error)
+	GetWithdrawalTotals(ctx context.Context, UID int, filter models.WithdrawalFilter) (models.WithdrawalTotals, error)
+	Transfer(ctx context.Context, transfer models.Transfer, limit models.TransferLimit) (stored models.Transfer, replayed bool, err error)
+	GetTransfers(ctx context.Context, UID int) ([]models.Transfer, error)
+	CreateHold(ctx context.Context, hold models.BalanceHold) (models.BalanceHold, error)
+	SettleHold(ctx context.Context, ID int, UID int, status string, capture float32, orderNumber string, uniqueOrder bool, now time.Time) (models.BalanceHold, error)
+	GetHolds(ctx context.Context, UID int) ([]models.BalanceHold, error)
+	GetExpiredHolds(ctx context.Context, now time.Time) ([]int, error)
+	GetStatementBalances(ctx context.Context, UID int, filter models.StatementFilter) (opening float32, closing float32, err error)
+	FindStatementEntries(ctx context.Context, UID int, filter models.StatementFilter) ([]models.StatementEntry, error)
 }
 
 type DBWallets struct {
@@ -44,79 +103,152 @@ func NewDBWallets(db *sql.DB, mu *sync.RWMutex) (*DBWallets, error) {
 	return &DBWallets{db: db, mu: mu}, nil
 }
 
-func (w *DBWallets) WriteWithdraw(ctx context.Context, withdraw models.Withdrawal) error {
+// isOrderUsed tells whether err is the unique order index refusing a row.
+func isOrderUsed(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == UniqueOrderIndex
+}
+
+// ProcessWithdraw registers a PENDING withdrawal and holds its sum: the
+// balance is debited at once, total_withdrawn only grows on completion.
+// The sum is taken from the oldest point lots first.
+func (w *DBWallets) ProcessWithdraw(ctx context.Context, withdraw models.Withdrawal) (ID int, err error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
 	tx, err := w.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer tx.Rollback()
-	_, err = tx.ExecContext(
+
+	res, err := tx.ExecContext(ctx, HoldWithdrawBalance, withdraw.Amount, withdraw.UserID)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 {
+		return 0, models.ErrNotEnoughBonuses
+	}
+	err = tx.QueryRowContext(
 		ctx, InsertWithdraw,
 		withdraw.UserID,
 		withdraw.OrderID,
 		withdraw.Amount,
 		withdraw.CreatedAt,
-	)
+		models.WithdrawalStatusPending,
+		withdraw.UniqueOrder,
+	).Scan(&ID)
 	if err != nil {
-		return err
+		if isOrderUsed(err) {
+			return 0, models.ErrOrderAlreadyUsed
+		}
+		return 0, err
 	}
-	return tx.Commit()
+	_, err = consumeLots(ctx, tx, withdraw.UserID, withdraw.Amount, ID)
+	if err != nil {
+		return 0, err
+	}
+	return ID, tx.Commit()
 }
 
-func (w *DBWallets) UpdateWallet(ctx context.Context, value float32, UID int) error {
+// SetWithdrawalStatus moves a withdrawal from one status to another and
+// applies the balance effect of the transition in the same transaction.
+func (w *DBWallets) SetWithdrawalStatus(ctx context.Context, ID int, from string, to string, reason string) (models.Withdrawal, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+
 	tx, err := w.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return models.Withdrawal{}, err
 	}
 	defer tx.Rollback()
-	_, err = tx.ExecContext(
-		ctx, WithdrawUpdateBalance,
-		value,
-		UID,
+
+	withdrawal := models.Withdrawal{ID: ID, Status: to, Reason: reason}
+	var statusReason sql.NullString
+	if reason != "" {
+		statusReason = sql.NullString{String: reason, Valid: true}
+	}
+	err = tx.QueryRowContext(ctx, SetWithdrawalStatusQuery, ID, from, to, statusReason, time.Now()).Scan(
+		&withdrawal.UserID,
+		&withdrawal.OrderID,
+		&withdrawal.Amount,
+		&withdrawal.CreatedAt,
 	)
 	if err != nil {
-		return err
+		if errors.Is(err, sql.ErrNoRows) {
+			var exists bool
+			err = tx.QueryRowContext(ctx, WithdrawalExistsQuery, ID).Scan(&exists)
+			if err != nil {
+				return models.Withdrawal{}, fmt.Errorf("failed to check withdrawal: %w", err)
+			}
+			if !exists {
+				return models.Withdrawal{}, models.ErrWithdrawalNotFound
+			}
+			return models.Withdrawal{}, fmt.Errorf("%w: withdrawal is not %s", models.ErrInvalidTransition, from)
+		}
+		return models.Withdrawal{}, fmt.Errorf("failed to update withdrawal: %w", err)
 	}
-	return tx.Commit()
+
+	var balanceDelta, withdrawnDelta float32
+	switch to {
+	case models.WithdrawalStatusCompleted:
+		withdrawnDelta = withdrawal.Amount
+	case models.WithdrawalStatusFailed:
+		balanceDelta = withdrawal.Amount
+	case models.WithdrawalStatusReversed:
+		balanceDelta, withdrawnDelta = withdrawal.Amount, -withdrawal.Amount
+	}
+	_, err = tx.ExecContext(ctx, SettleWithdrawBalance, balanceDelta, withdrawnDelta, withdrawal.UserID)
+	if err != nil {
+		return models.Withdrawal{}, err
+	}
+	if balanceDelta > 0 {
+		err = restoreLots(ctx, tx, ID)
+		if err != nil {
+			return models.Withdrawal{}, err
+		}
+	}
+	return withdrawal, tx.Commit()
 }
 
-func (w *DBWallets) GetUserBalance(ctx context.Context, UID int) (float32, error) {
+func (w *DBWallets) GetWithdrawal(ctx context.Context, ID int) (models.Withdrawal, error) {
 	w.mu.RLock()
 	defer w.mu.RUnlock()
-	var balance float32
-	err := w.db.QueryRowContext(ctx, GetBalanceByUID, UID).Scan(&balance)
+	withdrawal := models.Withdrawal{ID: ID}
+	var reason sql.NullString
+	err := w.db.QueryRowContext(ctx, GetWithdrawalQuery, ID).Scan(
+		&withdrawal.UserID,
+		&withdrawal.OrderID,
+		&withdrawal.Amount,
+		&withdrawal.Status,
+		&reason,
+		&withdrawal.CreatedAt,
+	)
 	if err != nil {
-		return 0, err
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Withdrawal{}, models.ErrWithdrawalNotFound
+		}
+		return models.Withdrawal{}, fmt.Errorf("failed to get withdrawal: %w", err)
 	}
-	return balance, nil
+	withdrawal.Reason = reason.String
+	return withdrawal, nil
 }
 
-func (w *DBWallets) ProcessWithdraw(ctx context.Context, withdraw models.Withdrawal) error {
-	//err := w.isUserOrderPresent(ctx, withdraw.OrderID, withdraw.UserID)
-	//if err != nil {
-	//	return err
-	//}
-	balance, err := w.GetUserBalance(ctx, withdraw.UserID)
-	if err != nil {
-		return err
-	}
-	if balance < withdraw.Amount {
-		return models.ErrNotEnoughBonuses
-	}
-	err = w.WriteWithdraw(ctx, withdraw)
-	if err != nil {
-		return err
-	}
-	err = w.UpdateWallet(ctx, withdraw.Amount, withdraw.UserID)
+// IsOrderWithdrawn reports whether the order number backs a withdrawal that
+// did not fail, failed ones were refunded and free the number again.
+func (w *DBWallets) IsOrderWithdrawn(ctx context.Context, orderNumber string) (bool, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	var used bool
+	err := w.db.QueryRowContext(ctx, OrderWithdrawnQuery, orderNumber).Scan(&used)
 	if err != nil {
-		return err
+		return false, fmt.Errorf("failed to check order withdrawals: %w", err)
 	}
-	return nil
+	return used, nil
 }
 
 func (w *DBWallets) GetUserWithdrawals(ctx context.Context, UID int) (withdrawals []models.Withdrawal, err error) {
@@ -134,7 +266,7 @@ func (w *DBWallets) GetUserWithdrawals(ctx context.Context, UID int) (withdrawal
 		var amount sql.NullFloat64
 		//var createdAt time.Time
 
-		if err := rows.Scan(&withdrawal.OrderID, &amount, &withdrawal.CreatedAt); err != nil {
+		if err := rows.Scan(&withdrawal.ID, &withdrawal.OrderID, &amount, &withdrawal.Status, &withdrawal.Reason, &withdrawal.CreatedAt); err != nil {
 			return nil, fmt.Errorf("failed to scan row: %v", err)
 		}
 
@@ -165,7 +297,7 @@ func (w *DBWallets) GetUserWallet(ctx context.Context, UID int) (wallet models.M
 
 	wallet = models.MartUserWallet{}
 
-	err = w.db.QueryRowContext(ctx, GetWalletByUID, UID).Scan(&wallet.Balance, &wallet.TotalWithdraw)
+	err = w.db.QueryRowContext(ctx, GetWalletByUID, UID).Scan(&wallet.Balance, &wallet.TotalWithdraw, &wallet.Held)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.MartUserWallet{}, err
@@ -199,7 +331,9 @@ func (w *DBWallets) CreateUserWallet(ctx context.Context, UID int) error {
 
 }
 
-func (w *DBWallets) Accrual(ctx context.Context, value float32, UID int) error {
+// Accrual credits the wallet and keeps the credit as a point lot. The
+// credited amount is also stored on the order of the lot.
+func (w *DBWallets) Accrual(ctx context.Context, lot models.PointLot) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	tx, err := w.db.BeginTx(ctx, nil)
@@ -207,13 +341,172 @@ func (w *DBWallets) Accrual(ctx context.Context, value float32, UID int) error {
 		return err
 	}
 	defer tx.Rollback()
-	_, err = tx.ExecContext(
+	err = AccrualTx(ctx, tx, lot)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AccrualTx is Accrual inside a transaction owned by the caller, who also
+// holds the repository lock.
+func AccrualTx(ctx context.Context, tx *sql.Tx, lot models.PointLot) error {
+	err := CreditTx(ctx, tx, lot)
+	if err != nil {
+		return err
+	}
+	if lot.OrderID != "" && lot.Amount > 0 {
+		_, err = tx.ExecContext(ctx, CreditOrderQuery, lot.Amount, lot.EarnedAt, lot.OrderID, lot.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to record credited amount: %w", err)
+		}
+	}
+	return nil
+}
+
+// CreditTx credits the wallet inside a transaction owned by the caller, who
+// also holds the repository lock.
+func CreditTx(ctx context.Context, tx *sql.Tx, lot models.PointLot) error {
+	_, err := tx.ExecContext(
 		ctx, AccrualUpdateBalance,
-		value,
-		UID,
+		lot.Amount,
+		lot.UserID,
+	)
+	if err != nil {
+		return err
+	}
+	return insertLot(ctx, tx, lot)
+}
+
+func (w *DBWallets) Adjust(ctx context.Context, adjustment models.BalanceAdjustment) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(
+		ctx, AdjustBalanceQuery,
+		adjustment.Amount,
+		adjustment.UserID,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return models.ErrNotEnoughBonuses
+	}
+	if adjustment.Amount < 0 {
+		_, err = consumeLots(ctx, tx, adjustment.UserID, -adjustment.Amount, 0)
+	} else {
+		err = insertLot(ctx, tx, models.PointLot{
+			UserID:   adjustment.UserID,
+			Source:   models.LotSourceAdjustment,
+			Amount:   adjustment.Amount,
+			EarnedAt: adjustment.CreatedAt,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(
+		ctx, InsertAdjustment,
+		adjustment.UserID,
+		adjustment.AdminID,
+		adjustment.Amount,
+		adjustment.Reason,
+		adjustment.CreatedAt,
 	)
 	if err != nil {
 		return err
 	}
 	return tx.Commit()
 }
+
+// withdrawalConditions appends the range filters shared by listing and totals.
+func withdrawalConditions(query *strings.Builder, filter models.WithdrawalFilter, arg func(v any) string) {
+	if !filter.From.IsZero() {
+		query.WriteString(" AND created_at >= " + arg(filter.From))
+	}
+	if !filter.To.IsZero() {
+		query.WriteString(" AND created_at < " + arg(filter.To))
+	}
+}
+
+// FindUserWithdrawals pages on (created_at, id) and returns at most
+// filter.Limit+1 rows, the extra one signals a next page.
+func (w *DBWallets) FindUserWithdrawals(ctx context.Context, UID int, filter models.WithdrawalFilter) ([]models.Withdrawal, error) {
+	var query strings.Builder
+	query.WriteString(FindWithdrawalsBase)
+	args := []any{UID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	withdrawalConditions(&query, filter, arg)
+	order, cmp := "DESC", "<"
+	if filter.Ascending {
+		order, cmp = "ASC", ">"
+	}
+	if filter.Cursor != nil {
+		query.WriteString(fmt.Sprintf(" AND (created_at, id) %s (%s, %s)", cmp, arg(filter.Cursor.CreatedAt), arg(filter.Cursor.ID)))
+	}
+	query.WriteString(fmt.Sprintf(" ORDER BY created_at %s, id %s", order, order))
+	if filter.Limit > 0 {
+		query.WriteString(" LIMIT " + arg(filter.Limit+1))
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	rows, err := w.db.QueryContext(ctx, query.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query withdrawals: %v", err)
+	}
+	defer rows.Close()
+	withdrawals := make([]models.Withdrawal, 0)
+
+	for rows.Next() {
+		var withdrawal models.Withdrawal
+		var amount sql.NullFloat64
+		if err := rows.Scan(&withdrawal.ID, &withdrawal.OrderID, &amount, &withdrawal.Status, &withdrawal.Reason, &withdrawal.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		if amount.Valid && amount.Float64 > 0 {
+			withdrawal.Amount = float32(amount.Float64)
+		}
+		withdrawal.UserID = UID
+		withdrawals = append(withdrawals, withdrawal)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %v", err)
+	}
+	return withdrawals, nil
+}
+
+// GetWithdrawalTotals counts and sums the withdrawals matching filter that
+// still hold their points, failed and reversed ones gave them back.
+func (w *DBWallets) GetWithdrawalTotals(ctx context.Context, UID int, filter models.WithdrawalFilter) (models.WithdrawalTotals, error) {
+	var query strings.Builder
+	query.WriteString(WithdrawalTotalsBase)
+	args := []any{UID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	withdrawalConditions(&query, filter, arg)
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	var totals models.WithdrawalTotals
+	err := w.db.QueryRowContext(ctx, query.String(), args...).Scan(&totals.Count, &totals.Sum)
+	if err != nil {
+		return models.WithdrawalTotals{}, fmt.Errorf("failed to get withdrawal totals: %w", err)
+	}
+	return totals, nil
+}
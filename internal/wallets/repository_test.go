@@ -0,0 +1,304 @@
+package wallets
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/testdb"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestDBWallets(t *testing.T) (*DBWallets, *sql.DB) {
+	t.Helper()
+	db := testdb.Open(t)
+	w, err := NewDBWallets(db, &sync.RWMutex{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w, db
+}
+
+// credit adds an accrual lot, a zero expiresAt never expires.
+func credit(t *testing.T, w *DBWallets, UID int, amount float32, earnedAt time.Time, expiresAt time.Time) {
+	t.Helper()
+	err := w.Accrual(context.Background(), models.PointLot{
+		UserID:    UID,
+		Source:    models.LotSourceAccrual,
+		Amount:    amount,
+		EarnedAt:  earnedAt,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("credit: %v", err)
+	}
+}
+
+func checkWallet(t *testing.T, w *DBWallets, UID int, balance float32, withdrawn float32) {
+	t.Helper()
+	wallet, err := w.GetUserWallet(context.Background(), UID)
+	if err != nil {
+		t.Fatalf("GetUserWallet: %v", err)
+	}
+	if wallet.Balance != balance || wallet.TotalWithdraw != withdrawn {
+		t.Errorf("wallet = %.2f/%.2f withdrawn, want %.2f/%.2f", wallet.Balance, wallet.TotalWithdraw, balance, withdrawn)
+	}
+}
+
+func lotsRemaining(t *testing.T, db *sql.DB, UID int) float32 {
+	t.Helper()
+	var remaining float32
+	err := db.QueryRowContext(context.Background(), `SELECT COALESCE(SUM(remaining), 0) FROM point_lots WHERE user_id = $1;`, UID).Scan(&remaining)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return remaining
+}
+
+func withdraw(t *testing.T, w *DBWallets, UID int, amount float32) int {
+	t.Helper()
+	ID, err := w.ProcessWithdraw(context.Background(), models.Withdrawal{
+		UserID:    UID,
+		OrderID:   testdb.OrderNumber(t),
+		Amount:    amount,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("ProcessWithdraw: %v", err)
+	}
+	return ID
+}
+
+func TestDBWithdrawalTransitions(t *testing.T) {
+	ctx := context.Background()
+	w, db := newTestDBWallets(t)
+	UID, _ := testdb.CreateUser(t, db)
+	credit(t, w, UID, 100, time.Now(), time.Time{})
+
+	_, err := w.ProcessWithdraw(ctx, models.Withdrawal{UserID: UID, OrderID: testdb.OrderNumber(t), Amount: 101, CreatedAt: time.Now()})
+	if !errors.Is(err, models.ErrNotEnoughBonuses) {
+		t.Fatalf("overdraft: error = %v, want ErrNotEnoughBonuses", err)
+	}
+
+	failed := withdraw(t, w, UID, 40)
+	checkWallet(t, w, UID, 60, 0)
+	if got := lotsRemaining(t, db, UID); got != 60 {
+		t.Errorf("lots after hold = %.2f, want 60", got)
+	}
+	if _, err = w.SetWithdrawalStatus(ctx, failed, models.WithdrawalStatusPending, models.WithdrawalStatusFailed, "declined"); err != nil {
+		t.Fatalf("fail: %v", err)
+	}
+	checkWallet(t, w, UID, 100, 0)
+	if got := lotsRemaining(t, db, UID); got != 100 {
+		t.Errorf("lots after refund = %.2f, want 100", got)
+	}
+	_, err = w.SetWithdrawalStatus(ctx, failed, models.WithdrawalStatusPending, models.WithdrawalStatusCompleted, "")
+	if !errors.Is(err, models.ErrInvalidTransition) {
+		t.Errorf("complete failed: error = %v, want ErrInvalidTransition", err)
+	}
+
+	completed := withdraw(t, w, UID, 30)
+	if _, err = w.SetWithdrawalStatus(ctx, completed, models.WithdrawalStatusPending, models.WithdrawalStatusCompleted, ""); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	checkWallet(t, w, UID, 70, 30)
+	_, err = w.SetWithdrawalStatus(ctx, completed, models.WithdrawalStatusPending, models.WithdrawalStatusFailed, "late")
+	if !errors.Is(err, models.ErrInvalidTransition) {
+		t.Errorf("fail completed: error = %v, want ErrInvalidTransition", err)
+	}
+	checkWallet(t, w, UID, 70, 30)
+
+	reversed, err := w.SetWithdrawalStatus(ctx, completed, models.WithdrawalStatusCompleted, models.WithdrawalStatusReversed, "refund")
+	if err != nil {
+		t.Fatalf("reverse: %v", err)
+	}
+	if reversed.Amount != 30 || reversed.UserID != UID {
+		t.Errorf("reversed withdrawal = %+v", reversed)
+	}
+	checkWallet(t, w, UID, 100, 0)
+	if got := lotsRemaining(t, db, UID); got != 100 {
+		t.Errorf("lots after reversal = %.2f, want 100", got)
+	}
+	stored, err := w.GetWithdrawal(ctx, completed)
+	if err != nil || stored.Status != models.WithdrawalStatusReversed || stored.Reason != "refund" {
+		t.Errorf("stored withdrawal = %+v, %v", stored, err)
+	}
+
+	_, err = w.SetWithdrawalStatus(ctx, 1<<30, models.WithdrawalStatusPending, models.WithdrawalStatusCompleted, "")
+	if !errors.Is(err, models.ErrWithdrawalNotFound) {
+		t.Errorf("unknown withdrawal: error = %v, want ErrWithdrawalNotFound", err)
+	}
+}
+
+func TestDBUniqueOrders(t *testing.T) {
+	ctx := context.Background()
+	w, db := newTestDBWallets(t)
+	UID, _ := testdb.CreateUser(t, db)
+	credit(t, w, UID, 100, time.Now(), time.Time{})
+	order := testdb.OrderNumber(t)
+
+	// Two replicas, each with its own lock, race for the same order.
+	replicas := []*DBWallets{w, {db: db, mu: &sync.RWMutex{}}}
+	errs := make([]error, len(replicas))
+	var wg sync.WaitGroup
+	for i, replica := range replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = replica.ProcessWithdraw(ctx, models.Withdrawal{UserID: UID, OrderID: order, Amount: 10, CreatedAt: time.Now(), UniqueOrder: true})
+		}()
+	}
+	wg.Wait()
+	var used int
+	for _, err := range errs {
+		switch {
+		case errors.Is(err, models.ErrOrderAlreadyUsed):
+			used++
+		case err != nil:
+			t.Fatalf("ProcessWithdraw: %v", err)
+		}
+	}
+	if used != 1 {
+		t.Fatalf("errors = %v, want exactly one ErrOrderAlreadyUsed", errs)
+	}
+	checkWallet(t, w, UID, 90, 0)
+
+	var ID int
+	err := db.QueryRowContext(ctx, `SELECT id FROM withdrawals WHERE order_number = $1;`, order).Scan(&ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.SetWithdrawalStatus(ctx, ID, models.WithdrawalStatusPending, models.WithdrawalStatusFailed, "declined"); err != nil {
+		t.Fatalf("fail: %v", err)
+	}
+	// A failed withdrawal frees its order.
+	if _, err = w.ProcessWithdraw(ctx, models.Withdrawal{UserID: UID, OrderID: order, Amount: 10, CreatedAt: time.Now(), UniqueOrder: true}); err != nil {
+		t.Errorf("order of a failed withdrawal refused: %v", err)
+	}
+	// Without UniqueOrder the same order may back another withdrawal.
+	if _, err = w.ProcessWithdraw(ctx, models.Withdrawal{UserID: UID, OrderID: order, Amount: 10, CreatedAt: time.Now()}); err != nil {
+		t.Errorf("duplicate order refused without UniqueOrder: %v", err)
+	}
+}
+
+func TestDBAccrualStoresCreditedAmount(t *testing.T) {
+	ctx := context.Background()
+	w, db := newTestDBWallets(t)
+	UID, _ := testdb.CreateUser(t, db)
+	order := testdb.OrderNumber(t)
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO orders (user_id, order_number, created_at, status, bonus_amount) 
+		VALUES ($1, $2, $3, 'PROCESSED', 10);`, UID, order, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A Gold member earns 1.5 times the accrual.
+	err = w.Accrual(ctx, models.PointLot{UserID: UID, OrderID: order, Source: models.LotSourceAccrual, Amount: 15, EarnedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("Accrual: %v", err)
+	}
+	var bonus, credited float32
+	var at sql.NullTime
+	err = db.QueryRowContext(ctx, `SELECT bonus_amount, credited_amount, credited_at FROM orders WHERE order_number = $1;`, order).Scan(&bonus, &credited, &at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bonus != 10 || credited != 15 || !at.Valid {
+		t.Errorf("order bonus %.2f credited %.2f at %v, want 10 and 15 with a credit time", bonus, credited, at)
+	}
+	checkWallet(t, w, UID, 15, 0)
+}
+
+func TestDBStatementAccrualsFromLots(t *testing.T) {
+	ctx := context.Background()
+	w, db := newTestDBWallets(t)
+	UID, _ := testdb.CreateUser(t, db)
+	order := testdb.OrderNumber(t)
+	earnedAt := time.Now().UTC().Add(-time.Hour)
+	err := w.Accrual(ctx, models.PointLot{UserID: UID, OrderID: order, Source: models.LotSourceAccrual, Amount: 12.5, EarnedAt: earnedAt})
+	if err != nil {
+		t.Fatalf("Accrual: %v", err)
+	}
+	withdraw(t, w, UID, 2.5)
+
+	entries, err := w.FindStatementEntries(ctx, UID, models.StatementFilter{})
+	if err != nil {
+		t.Fatalf("FindStatementEntries: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2: %+v", len(entries), entries)
+	}
+	accrual := entries[0]
+	if accrual.Kind != "accrual" || accrual.Reference != order || accrual.Amount != 12.5 || accrual.Balance != 12.5 {
+		t.Errorf("accrual entry = %+v", accrual)
+	}
+	if accrual.At.Sub(earnedAt).Abs() > time.Second {
+		t.Errorf("accrual at %v, want %v", accrual.At, earnedAt)
+	}
+	if entries[1].Balance != 10 {
+		t.Errorf("closing entry balance = %.2f, want 10", entries[1].Balance)
+	}
+}
+
+func TestDBStatementAccrualsBeforeLots(t *testing.T) {
+	ctx := context.Background()
+	w, db := newTestDBWallets(t)
+	UID, _ := testdb.CreateUser(t, db)
+	order := testdb.OrderNumber(t)
+	creditedAt := time.Now().UTC().Add(-48 * time.Hour)
+	// Credited before the lots migration, the points went into the legacy
+	// lot and the order has no accrual lot of its own.
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO orders (user_id, order_number, created_at, status, bonus_amount, credited_amount, credited_at) 
+		VALUES ($1, $2, $3, 'PROCESSED', 20, 20, $3);`, UID, order, creditedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credit(t, w, UID, 5, time.Now().UTC().Add(-time.Hour), time.Time{})
+
+	entries, err := w.FindStatementEntries(ctx, UID, models.StatementFilter{})
+	if err != nil {
+		t.Fatalf("FindStatementEntries: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2: %+v", len(entries), entries)
+	}
+	legacy := entries[0]
+	if legacy.Kind != "accrual" || legacy.Reference != order || legacy.Amount != 20 || legacy.Balance != 20 {
+		t.Errorf("pre-lots accrual entry = %+v", legacy)
+	}
+	if legacy.At.Sub(creditedAt).Abs() > time.Second {
+		t.Errorf("pre-lots accrual at %v, want %v", legacy.At, creditedAt)
+	}
+	if entries[1].Balance != 25 {
+		t.Errorf("closing entry balance = %.2f, want 25", entries[1].Balance)
+	}
+}
+
+func TestDBWithdrawalTotalsSkipRefunded(t *testing.T) {
+	ctx := context.Background()
+	w, db := newTestDBWallets(t)
+	UID, _ := testdb.CreateUser(t, db)
+	credit(t, w, UID, 100, time.Now(), time.Time{})
+
+	withdraw(t, w, UID, 10)
+	completed := withdraw(t, w, UID, 20)
+	failed := withdraw(t, w, UID, 30)
+	if _, err := w.SetWithdrawalStatus(ctx, completed, models.WithdrawalStatusPending, models.WithdrawalStatusCompleted, ""); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if _, err := w.SetWithdrawalStatus(ctx, failed, models.WithdrawalStatusPending, models.WithdrawalStatusFailed, "declined"); err != nil {
+		t.Fatalf("fail: %v", err)
+	}
+
+	totals, err := w.GetWithdrawalTotals(ctx, UID, models.WithdrawalFilter{})
+	if err != nil {
+		t.Fatalf("GetWithdrawalTotals: %v", err)
+	}
+	if totals.Count != 2 || totals.Sum != 30 {
+		t.Errorf("totals = %d/%.2f, want 2/30", totals.Count, totals.Sum)
+	}
+}
@@ -9,4 +9,26 @@ type WalletService interface {
 	GetUserBalance(ctx context.Context, UID int) (wallet models.MartUserWallet, err error)
 	GetWithdrawals(ctx context.Context, UID int) (withdrawals []models.Withdrawal, err error)
 	RegisterWithdraw(ctx context.Context, withdraw models.Withdrawal) error
+	CompleteWithdrawal(ctx context.Context, ID int) (models.Withdrawal, error)
+	FailWithdrawal(ctx context.Context, ID int, reason string) (models.Withdrawal, error)
+	ReverseWithdrawal(ctx context.Context, ID int, adminID int, reason string) (models.Withdrawal, error)
+	ExpirePoints(ctx context.Context) (int, error)
+	RunExpiry(ctx context.Context) error
+	FindWithdrawals(ctx context.Context, UID int, filter models.WithdrawalFilter) (withdrawals []models.Withdrawal, next *models.Cursor, err error)
+	GetWithdrawalTotals(ctx context.Context, UID int, filter models.WithdrawalFilter) (models.WithdrawalTotals, error)
+	Transfer(ctx context.Context, UID int, req models.TransferRequest) (transfer models.Transfer, replayed bool, err error)
+	GetTransfers(ctx context.Context, UID int) ([]models.Transfer, error)
+	CreateHold(ctx context.Context, UID int, req models.HoldRequest) (models.BalanceHold, error)
+	CaptureHold(ctx context.Context, UID int, ID int, req models.HoldCapture) (models.BalanceHold, error)
+	ReleaseHold(ctx context.Context, UID int, ID int) (models.BalanceHold, error)
+	GetHolds(ctx context.Context, UID int) ([]models.BalanceHold, error)
+	ReleaseExpiredHolds(ctx context.Context) (int, error)
+	RunHoldSweeper(ctx context.Context) error
+	GetStatement(ctx context.Context, UID int, filter models.StatementFilter) (statement models.Statement, next *models.Cursor, err error)
+}
+
+// NumberValidator checks the order number of a withdrawal. It is satisfied
+// by orders.OrderNumberValidator, which can not be imported from here.
+type NumberValidator interface {
+	Validate(number string) error
 }
@@ -2,15 +2,66 @@ package wallets
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/events"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
 	"github.com/Fuonder/goptherstore.git/internal/models"
+	"go.uber.org/zap"
+	"math"
+	"strconv"
+	"strings"
+	"time"
 )
 
+type Config struct {
+	// AutoComplete settles withdrawals right after the hold is taken, for
+	// deployments without a downstream redemption step.
+	AutoComplete bool
+	// MaxAmount caps a single withdrawal, zero means no cap.
+	MaxAmount float32
+	// Precision is the number of decimal places a sum may have.
+	Precision int
+	// UniqueOrder refuses order numbers already used by a withdrawal that
+	// has not failed. Withdrawals made with it on claim their order in a
+	// unique index, so concurrent requests can not both pass the check.
+	UniqueOrder bool
+	// ExpiryWarning is how far ahead the balance reports expiring points.
+	ExpiryWarning  time.Duration
+	ExpiryInterval time.Duration
+	// PointsTTL is how long legacy points live from the first expiry run,
+	// zero keeps them forever.
+	PointsTTL time.Duration
+	// TransferDailyAmount and TransferDailyCount bound what a user may send
+	// per calendar day, zero means no limit.
+	TransferDailyAmount float32
+	TransferDailyCount  int
+	// HoldDefaultTTL applies to holds created without a TTL, none may
+	// outlive HoldMaxTTL.
+	HoldDefaultTTL    time.Duration
+	HoldMaxTTL        time.Duration
+	HoldSweepInterval time.Duration
+}
+
+const maxIdempotencyKeyLen = 64
+
+// withdrawalTransitions maps a target status to the status it may be
+// reached from.
+var withdrawalTransitions = map[string]string{
+	models.WithdrawalStatusCompleted: models.WithdrawalStatusPending,
+	models.WithdrawalStatusFailed:    models.WithdrawalStatusPending,
+	models.WithdrawalStatusReversed:  models.WithdrawalStatusCompleted,
+}
+
 type WService struct {
-	conn DatabaseWallets
+	conn      DatabaseWallets
+	events    events.Publisher
+	validator NumberValidator
+	cfg       Config
 }
 
-func NewWService(conn DatabaseWallets) *WService {
-	return &WService{conn: conn}
+func NewWService(conn DatabaseWallets, events events.Publisher, validator NumberValidator, cfg Config) *WService {
+	return &WService{conn: conn, events: events, validator: validator, cfg: cfg}
 }
 
 func (s *WService) GetUserBalance(ctx context.Context, UID int) (wallet models.MartUserWallet, err error) {
@@ -18,8 +69,56 @@ func (s *WService) GetUserBalance(ctx context.Context, UID int) (wallet models.M
 	if err != nil {
 		return models.MartUserWallet{}, err
 	}
+	if s.cfg.ExpiryWarning > 0 {
+		now := time.Now()
+		expiring, err := s.conn.GetExpiringPoints(ctx, UID, now, now.Add(s.cfg.ExpiryWarning))
+		if err != nil {
+			return models.MartUserWallet{}, err
+		}
+		if expiring.Amount > 0 {
+			wallet.ExpiringSoon = &expiring
+		}
+	}
 	return wallet, nil
+}
+
+// ExpirePoints debits every lot past its expiry date.
+func (s *WService) ExpirePoints(ctx context.Context) (int, error) {
+	now := time.Now()
+	if s.cfg.PointsTTL > 0 {
+		err := s.conn.ScheduleLegacyLots(ctx, now.Add(s.cfg.PointsTTL))
+		if err != nil {
+			return 0, err
+		}
+	}
+	expired, err := s.conn.ExpireLots(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	for UID, amount := range expired {
+		PublishBalance(ctx, s.conn, s.events, UID, -amount, "expiry")
+	}
+	return len(expired), nil
+}
 
+func (s *WService) RunExpiry(ctx context.Context) error {
+	ticker := time.NewTicker(s.cfg.ExpiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			users, err := s.ExpirePoints(ctx)
+			if err != nil {
+				logger.Log.Error("point expiry failed", zap.Error(err))
+				continue
+			}
+			if users > 0 {
+				logger.Log.Info("points expired", zap.Int("users", users))
+			}
+		}
+	}
 }
 
 func (s *WService) GetWithdrawals(ctx context.Context, UID int) (withdrawals []models.Withdrawal, err error) {
@@ -31,5 +130,397 @@ func (s *WService) GetWithdrawals(ctx context.Context, UID int) (withdrawals []m
 }
 
 func (s *WService) RegisterWithdraw(ctx context.Context, withdraw models.Withdrawal) error {
-	return s.conn.ProcessWithdraw(ctx, withdraw)
+	err := s.validateWithdraw(ctx, withdraw)
+	if err != nil {
+		return err
+	}
+	withdraw.UniqueOrder = s.cfg.UniqueOrder
+	withdraw.ID, err = s.conn.ProcessWithdraw(ctx, withdraw)
+	if err != nil {
+		if errors.Is(err, models.ErrOrderAlreadyUsed) {
+			return orderUsedError()
+		}
+		return err
+	}
+	withdraw.Status = models.WithdrawalStatusPending
+	s.events.Publish(ctx, models.Event{
+		Type:   models.EventWithdrawalCreated,
+		UserID: withdraw.UserID,
+		Data:   withdraw,
+	})
+	PublishBalance(ctx, s.conn, s.events, withdraw.UserID, -withdraw.Amount, "withdrawal")
+	if s.cfg.AutoComplete {
+		// The withdrawal is committed by now, one that can not be settled
+		// stays PENDING for the settlement endpoints instead of failing.
+		_, err = s.CompleteWithdrawal(ctx, withdraw.ID)
+		if err != nil {
+			logger.Log.Error("can not auto-complete withdrawal", zap.Int("withdrawal", withdraw.ID), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func (s *WService) validateWithdraw(ctx context.Context, withdraw models.Withdrawal) error {
+	err := s.validateMaxAmount("sum", withdraw.Amount)
+	if err != nil {
+		return err
+	}
+
+	return s.validateOrder(ctx, withdraw.OrderID)
+}
+
+// validateOrder checks the order number points are redeemed against.
+func (s *WService) validateOrder(ctx context.Context, number string) error {
+	err := s.validator.Validate(number)
+	if err != nil {
+		return &models.WithdrawalError{Field: "order", Rule: "format", Message: err.Error(), Err: err}
+	}
+	if s.cfg.UniqueOrder {
+		used, err := s.conn.IsOrderWithdrawn(ctx, number)
+		if err != nil {
+			return err
+		}
+		if used {
+			return orderUsedError()
+		}
+	}
+	return nil
+}
+
+func orderUsedError() error {
+	return &models.WithdrawalError{Field: "order", Rule: "unused", Message: models.ErrOrderAlreadyUsed.Error(), Err: models.ErrOrderAlreadyUsed}
+}
+
+// validateAmount checks a sum of points moved out of a wallet, field names
+// the request field in the error.
+func (s *WService) validateAmount(field string, amount float32) error {
+	v := float64(amount)
+	if math.IsNaN(v) || math.IsInf(v, 0) || v <= 0 {
+		return &models.WithdrawalError{Field: field, Rule: "positive", Message: field + " must be a positive number", Err: models.ErrInvalidAmount}
+	}
+	if decimals(amount) > s.cfg.Precision {
+		return &models.WithdrawalError{
+			Field:   field,
+			Rule:    "precision",
+			Message: fmt.Sprintf("%s must have at most %d decimal places", field, s.cfg.Precision),
+			Err:     models.ErrInvalidAmount,
+		}
+	}
+	return nil
+}
+
+// validateMaxAmount checks a sum that ends up redeemed, so it is also held
+// to the per-transaction maximum.
+func (s *WService) validateMaxAmount(field string, amount float32) error {
+	err := s.validateAmount(field, amount)
+	if err != nil {
+		return err
+	}
+	if s.cfg.MaxAmount > 0 && amount > s.cfg.MaxAmount {
+		return &models.WithdrawalError{
+			Field:   field,
+			Rule:    "max",
+			Message: fmt.Sprintf("%s must not exceed %s", field, strconv.FormatFloat(float64(s.cfg.MaxAmount), 'f', -1, 32)),
+			Err:     models.ErrInvalidAmount,
+		}
+	}
+	return nil
+}
+
+// Transfer sends points to another user. Retrying with the same
+// idempotency key returns the first transfer instead of sending again.
+func (s *WService) Transfer(ctx context.Context, UID int, req models.TransferRequest) (models.Transfer, bool, error) {
+	req.IdempotencyKey = strings.TrimSpace(req.IdempotencyKey)
+	if req.IdempotencyKey == "" || len(req.IdempotencyKey) > maxIdempotencyKeyLen {
+		return models.Transfer{}, false, models.ErrIdempotencyKey
+	}
+	err := s.validateAmount("amount", req.Amount)
+	if err != nil {
+		return models.Transfer{}, false, err
+	}
+	recipient := strings.TrimSpace(req.Recipient)
+	if recipient == "" {
+		return models.Transfer{}, false, models.ErrRecipientNotFound
+	}
+
+	now := time.Now()
+	limit := models.TransferLimit{
+		Since:  time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()),
+		Amount: s.cfg.TransferDailyAmount,
+		Count:  s.cfg.TransferDailyCount,
+	}
+	transfer, replayed, err := s.conn.Transfer(ctx, models.Transfer{
+		SenderID:       UID,
+		Direction:      models.TransferDirectionOut,
+		Counterparty:   recipient,
+		Amount:         req.Amount,
+		IdempotencyKey: req.IdempotencyKey,
+		CreatedAt:      now,
+	}, limit)
+	if err != nil {
+		return models.Transfer{}, false, err
+	}
+	if !replayed {
+		PublishBalance(ctx, s.conn, s.events, transfer.SenderID, -transfer.Amount, "transfer")
+		PublishBalance(ctx, s.conn, s.events, transfer.RecipientID, transfer.Amount, "transfer")
+	}
+	return transfer, replayed, nil
+}
+
+func (s *WService) GetTransfers(ctx context.Context, UID int) ([]models.Transfer, error) {
+	transfers, err := s.conn.GetTransfers(ctx, UID)
+	if err != nil {
+		return nil, err
+	}
+	if len(transfers) == 0 {
+		return nil, models.ErrNoData
+	}
+	return transfers, nil
+}
+
+// CreateHold reserves points for a checkout. Held points are not part of
+// the available balance until the hold is released or expires.
+func (s *WService) CreateHold(ctx context.Context, UID int, req models.HoldRequest) (models.BalanceHold, error) {
+	err := s.validateMaxAmount("amount", req.Amount)
+	if err != nil {
+		return models.BalanceHold{}, err
+	}
+	ttl := time.Duration(req.TTL) * time.Second
+	if ttl == 0 {
+		ttl = s.cfg.HoldDefaultTTL
+	}
+	if ttl <= 0 || (s.cfg.HoldMaxTTL > 0 && ttl > s.cfg.HoldMaxTTL) {
+		return models.BalanceHold{}, fmt.Errorf("%w: ttl must be between 1 and %d seconds", models.ErrInvalidHold, int(s.cfg.HoldMaxTTL.Seconds()))
+	}
+	req.Order = strings.TrimSpace(req.Order)
+	if req.Order != "" {
+		err = s.validateOrder(ctx, req.Order)
+		if err != nil {
+			return models.BalanceHold{}, err
+		}
+	}
+
+	now := time.Now()
+	hold, err := s.conn.CreateHold(ctx, models.BalanceHold{
+		UserID:    UID,
+		Amount:    req.Amount,
+		OrderID:   req.Order,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return models.BalanceHold{}, err
+	}
+	PublishBalance(ctx, s.conn, s.events, UID, -hold.Amount, "hold")
+	return hold, nil
+}
+
+// CaptureHold books the held points as a completed withdrawal.
+func (s *WService) CaptureHold(ctx context.Context, UID int, ID int, req models.HoldCapture) (models.BalanceHold, error) {
+	if req.Amount != 0 {
+		err := s.validateAmount("amount", req.Amount)
+		if err != nil {
+			return models.BalanceHold{}, err
+		}
+	}
+	req.Order = strings.TrimSpace(req.Order)
+	if req.Order != "" {
+		err := s.validateOrder(ctx, req.Order)
+		if err != nil {
+			return models.BalanceHold{}, err
+		}
+	}
+	hold, err := s.conn.SettleHold(ctx, ID, UID, models.HoldStatusCaptured, req.Amount, req.Order, s.cfg.UniqueOrder, time.Now())
+	if err != nil {
+		if errors.Is(err, models.ErrOrderAlreadyUsed) {
+			return models.BalanceHold{}, orderUsedError()
+		}
+		return models.BalanceHold{}, err
+	}
+	s.events.Publish(ctx, models.Event{
+		Type:   models.EventWithdrawalCreated,
+		UserID: UID,
+		Data: models.Withdrawal{
+			ID:        hold.WithdrawalID,
+			OrderID:   hold.OrderID,
+			Amount:    hold.Captured,
+			Status:    models.WithdrawalStatusCompleted,
+			CreatedAt: *hold.SettledAt,
+		},
+	})
+	if rest := hold.Amount - hold.Captured; rest > 0 {
+		PublishBalance(ctx, s.conn, s.events, UID, rest, "hold_released")
+	}
+	return hold, nil
+}
+
+func (s *WService) ReleaseHold(ctx context.Context, UID int, ID int) (models.BalanceHold, error) {
+	hold, err := s.conn.SettleHold(ctx, ID, UID, models.HoldStatusReleased, 0, "", false, time.Now())
+	if err != nil {
+		return models.BalanceHold{}, err
+	}
+	PublishBalance(ctx, s.conn, s.events, UID, hold.Amount, "hold_released")
+	return hold, nil
+}
+
+func (s *WService) GetHolds(ctx context.Context, UID int) ([]models.BalanceHold, error) {
+	holds, err := s.conn.GetHolds(ctx, UID)
+	if err != nil {
+		return nil, err
+	}
+	if len(holds) == 0 {
+		return nil, models.ErrNoData
+	}
+	return holds, nil
+}
+
+// ReleaseExpiredHolds gives the points of every expired hold back. Holds
+// captured meanwhile are skipped.
+func (s *WService) ReleaseExpiredHolds(ctx context.Context) (int, error) {
+	now := time.Now()
+	IDs, err := s.conn.GetExpiredHolds(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	released := 0
+	for _, ID := range IDs {
+		hold, err := s.conn.SettleHold(ctx, ID, 0, models.HoldStatusExpired, 0, "", false, now)
+		if err != nil {
+			if errors.Is(err, models.ErrHoldNotActive) {
+				continue
+			}
+			return released, err
+		}
+		released++
+		PublishBalance(ctx, s.conn, s.events, hold.UserID, hold.Amount, "hold_expired")
+	}
+	return released, nil
+}
+
+func (s *WService) RunHoldSweeper(ctx context.Context) error {
+	ticker := time.NewTicker(s.cfg.HoldSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			released, err := s.ReleaseExpiredHolds(ctx)
+			if err != nil {
+				logger.Log.Error("hold sweep failed", zap.Error(err))
+				continue
+			}
+			if released > 0 {
+				logger.Log.Info("expired holds released", zap.Int("holds", released))
+			}
+		}
+	}
+}
+
+// decimals counts the decimal places of the shortest representation of v.
+func decimals(v float32) int {
+	s := strconv.FormatFloat(float64(v), 'f', -1, 32)
+	if _, frac, ok := strings.Cut(s, "."); ok {
+		return len(frac)
+	}
+	return 0
+}
+
+// CompleteWithdrawal confirms the redemption, the held sum is counted as
+// withdrawn.
+func (s *WService) CompleteWithdrawal(ctx context.Context, ID int) (models.Withdrawal, error) {
+	return s.transition(ctx, ID, models.WithdrawalStatusCompleted, "")
+}
+
+// FailWithdrawal releases the hold of a redemption that did not go through.
+func (s *WService) FailWithdrawal(ctx context.Context, ID int, reason string) (models.Withdrawal, error) {
+	return s.transition(ctx, ID, models.WithdrawalStatusFailed, reason)
+}
+
+// ReverseWithdrawal refunds a completed withdrawal on behalf of an admin.
+func (s *WService) ReverseWithdrawal(ctx context.Context, ID int, adminID int, reason string) (models.Withdrawal, error) {
+	if strings.TrimSpace(reason) == "" {
+		return models.Withdrawal{}, models.ErrReasonRequired
+	}
+	withdrawal, err := s.transition(ctx, ID, models.WithdrawalStatusReversed, reason)
+	if err != nil {
+		return models.Withdrawal{}, err
+	}
+	logger.Log.Info("withdrawal reversed",
+		zap.Int("withdrawal", ID),
+		zap.Int("admin", adminID),
+		zap.String("reason", reason))
+	return withdrawal, nil
+}
+
+func (s *WService) transition(ctx context.Context, ID int, to string, reason string) (models.Withdrawal, error) {
+	withdrawal, err := s.conn.SetWithdrawalStatus(ctx, ID, withdrawalTransitions[to], to, reason)
+	if err != nil {
+		return models.Withdrawal{}, err
+	}
+	s.events.Publish(ctx, models.Event{
+		Type:   models.EventWithdrawalUpdated,
+		UserID: withdrawal.UserID,
+		Data:   withdrawal,
+	})
+	switch to {
+	case models.WithdrawalStatusCompleted:
+		PublishBalance(ctx, s.conn, s.events, withdrawal.UserID, 0, "withdrawal_completed")
+	case models.WithdrawalStatusFailed:
+		PublishBalance(ctx, s.conn, s.events, withdrawal.UserID, withdrawal.Amount, "withdrawal_refund")
+	case models.WithdrawalStatusReversed:
+		PublishBalance(ctx, s.conn, s.events, withdrawal.UserID, withdrawal.Amount, "withdrawal_reversal")
+	}
+	return withdrawal, nil
+}
+
+// FindWithdrawals returns one page of withdrawals and the cursor of the
+// next page, nil when this page is the last one.
+func (s *WService) FindWithdrawals(ctx context.Context, UID int, filter models.WithdrawalFilter) (withdrawals []models.Withdrawal, next *models.Cursor, err error) {
+	withdrawals, err = s.conn.FindUserWithdrawals(ctx, UID, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if filter.Limit > 0 && len(withdrawals) > filter.Limit {
+		withdrawals = withdrawals[:filter.Limit]
+		last := withdrawals[len(withdrawals)-1]
+		next = &models.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+	if len(withdrawals) == 0 {
+		return nil, nil, models.ErrNoData
+	}
+	return withdrawals, next, nil
+}
+
+// GetStatement returns one page of the balance movements in the period
+// with the balances it opens and closes at. The balances cover the whole
+// period whatever page is asked for.
+func (s *WService) GetStatement(ctx context.Context, UID int, filter models.StatementFilter) (models.Statement, *models.Cursor, error) {
+	statement := models.Statement{}
+	if !filter.From.IsZero() {
+		statement.From = &filter.From
+	}
+	if !filter.To.IsZero() {
+		statement.To = &filter.To
+	}
+	var err error
+	statement.OpeningBalance, statement.ClosingBalance, err = s.conn.GetStatementBalances(ctx, UID, filter)
+	if err != nil {
+		return models.Statement{}, nil, err
+	}
+	statement.Entries, err = s.conn.FindStatementEntries(ctx, UID, filter)
+	if err != nil {
+		return models.Statement{}, nil, err
+	}
+	var next *models.Cursor
+	if filter.Limit > 0 && len(statement.Entries) > filter.Limit {
+		statement.Entries = statement.Entries[:filter.Limit]
+		last := statement.Entries[len(statement.Entries)-1]
+		next = &models.Cursor{CreatedAt: last.At, ID: last.Seq}
+	}
+	return statement, next, nil
+}
+
+func (s *WService) GetWithdrawalTotals(ctx context.Context, UID int, filter models.WithdrawalFilter) (models.WithdrawalTotals, error) {
+	return s.conn.GetWithdrawalTotals(ctx, UID, filter)
 }
@@ -0,0 +1,188 @@
+package wallets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWallets keeps withdrawals in memory, methods a test does not need
+// are left to the embedded nil interface.
+type fakeWallets struct {
+	DatabaseWallets
+	mu          sync.Mutex
+	withdrawals map[int]models.Withdrawal
+	processErr  error
+	settleErr   error
+}
+
+func newFakeWallets() *fakeWallets {
+	return &fakeWallets{withdrawals: make(map[int]models.Withdrawal)}
+}
+
+func (f *fakeWallets) ProcessWithdraw(_ context.Context, withdraw models.Withdrawal) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.processErr != nil {
+		return 0, f.processErr
+	}
+	withdraw.ID = len(f.withdrawals) + 1
+	withdraw.Status = models.WithdrawalStatusPending
+	f.withdrawals[withdraw.ID] = withdraw
+	return withdraw.ID, nil
+}
+
+func (f *fakeWallets) SetWithdrawalStatus(_ context.Context, ID int, from string, to string, reason string) (models.Withdrawal, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.settleErr != nil {
+		return models.Withdrawal{}, f.settleErr
+	}
+	withdrawal, ok := f.withdrawals[ID]
+	if !ok {
+		return models.Withdrawal{}, models.ErrWithdrawalNotFound
+	}
+	if withdrawal.Status != from {
+		return models.Withdrawal{}, fmt.Errorf("%w: withdrawal is not %s", models.ErrInvalidTransition, from)
+	}
+	withdrawal.Status = to
+	withdrawal.Reason = reason
+	f.withdrawals[ID] = withdrawal
+	return withdrawal, nil
+}
+
+func (f *fakeWallets) IsOrderWithdrawn(_ context.Context, orderNumber string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, withdrawal := range f.withdrawals {
+		if withdrawal.OrderID == orderNumber && withdrawal.Status != models.WithdrawalStatusFailed {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeWallets) GetUserWallet(_ context.Context, UID int) (models.MartUserWallet, error) {
+	return models.MartUserWallet{OwnerID: UID}, nil
+}
+
+func (f *fakeWallets) status(ID int) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.withdrawals[ID].Status
+}
+
+type nopPublisher struct{}
+
+func (nopPublisher) Publish(context.Context, models.Event) {}
+
+type acceptAll struct{}
+
+func (acceptAll) Validate(string) error { return nil }
+
+func newTestWService(conn DatabaseWallets, cfg Config) *WService {
+	if cfg.Precision == 0 {
+		cfg.Precision = 2
+	}
+	return NewWService(conn, nopPublisher{}, acceptAll{}, cfg)
+}
+
+func testWithdrawal() models.Withdrawal {
+	return models.Withdrawal{UserID: 1, OrderID: "2377225624", Amount: 10, CreatedAt: time.Now()}
+}
+
+func TestRegisterWithdrawAutoComplete(t *testing.T) {
+	conn := newFakeWallets()
+	s := newTestWService(conn, Config{AutoComplete: true})
+	if err := s.RegisterWithdraw(context.Background(), testWithdrawal()); err != nil {
+		t.Fatalf("RegisterWithdraw: %v", err)
+	}
+	if got := conn.status(1); got != models.WithdrawalStatusCompleted {
+		t.Errorf("status = %s, want %s", got, models.WithdrawalStatusCompleted)
+	}
+}
+
+func TestRegisterWithdrawAutoCompleteFailureStaysPending(t *testing.T) {
+	conn := newFakeWallets()
+	conn.settleErr = errors.New("connection reset")
+	s := newTestWService(conn, Config{AutoComplete: true})
+	if err := s.RegisterWithdraw(context.Background(), testWithdrawal()); err != nil {
+		t.Fatalf("RegisterWithdraw returned %v after the withdrawal was committed", err)
+	}
+	if got := conn.status(1); got != models.WithdrawalStatusPending {
+		t.Errorf("status = %s, want %s", got, models.WithdrawalStatusPending)
+	}
+}
+
+func TestWithdrawalTransitions(t *testing.T) {
+	ctx := context.Background()
+	conn := newFakeWallets()
+	s := newTestWService(conn, Config{})
+	for range 2 {
+		if err := s.RegisterWithdraw(ctx, testWithdrawal()); err != nil {
+			t.Fatalf("RegisterWithdraw: %v", err)
+		}
+	}
+
+	steps := []struct {
+		name string
+		do   func() (models.Withdrawal, error)
+		want error
+		to   string
+	}{
+		{"reverse pending", func() (models.Withdrawal, error) { return s.ReverseWithdrawal(ctx, 1, 9, "refund") }, models.ErrInvalidTransition, models.WithdrawalStatusPending},
+		{"complete pending", func() (models.Withdrawal, error) { return s.CompleteWithdrawal(ctx, 1) }, nil, models.WithdrawalStatusCompleted},
+		{"complete twice", func() (models.Withdrawal, error) { return s.CompleteWithdrawal(ctx, 1) }, models.ErrInvalidTransition, models.WithdrawalStatusCompleted},
+		{"fail completed", func() (models.Withdrawal, error) { return s.FailWithdrawal(ctx, 1, "declined") }, models.ErrInvalidTransition, models.WithdrawalStatusCompleted},
+		{"reverse without reason", func() (models.Withdrawal, error) { return s.ReverseWithdrawal(ctx, 1, 9, " ") }, models.ErrReasonRequired, models.WithdrawalStatusCompleted},
+		{"reverse completed", func() (models.Withdrawal, error) { return s.ReverseWithdrawal(ctx, 1, 9, "refund") }, nil, models.WithdrawalStatusReversed},
+		{"reverse twice", func() (models.Withdrawal, error) { return s.ReverseWithdrawal(ctx, 1, 9, "refund") }, models.ErrInvalidTransition, models.WithdrawalStatusReversed},
+	}
+	for _, step := range steps {
+		_, err := step.do()
+		if !errors.Is(err, step.want) {
+			t.Errorf("%s: error = %v, want %v", step.name, err, step.want)
+		}
+		if got := conn.status(1); got != step.to {
+			t.Errorf("%s: status = %s, want %s", step.name, got, step.to)
+		}
+	}
+
+	if _, err := s.FailWithdrawal(ctx, 2, "declined"); err != nil {
+		t.Fatalf("fail pending: %v", err)
+	}
+	if _, err := s.CompleteWithdrawal(ctx, 2); !errors.Is(err, models.ErrInvalidTransition) {
+		t.Errorf("complete failed: error = %v, want ErrInvalidTransition", err)
+	}
+	if _, err := s.CompleteWithdrawal(ctx, 3); !errors.Is(err, models.ErrWithdrawalNotFound) {
+		t.Errorf("complete unknown: error = %v, want ErrWithdrawalNotFound", err)
+	}
+}
+
+func TestRegisterWithdrawOrderUsedConcurrently(t *testing.T) {
+	conn := newFakeWallets()
+	// The service check passed, the unique index caught the second request.
+	conn.processErr = models.ErrOrderAlreadyUsed
+	s := newTestWService(conn, Config{UniqueOrder: true})
+
+	err := s.RegisterWithdraw(context.Background(), testWithdrawal())
+	var wErr *models.WithdrawalError
+	if !errors.As(err, &wErr) || wErr.Rule != "unused" || !errors.Is(err, models.ErrOrderAlreadyUsed) {
+		t.Fatalf("error = %v, want the unused order field error", err)
+	}
+}
+
+func TestCreateHoldMaxAmount(t *testing.T) {
+	// The fake has no CreateHold, reaching the repository would panic.
+	s := newTestWService(newFakeWallets(), Config{MaxAmount: 100, HoldDefaultTTL: time.Minute})
+
+	_, err := s.CreateHold(context.Background(), 1, models.HoldRequest{Amount: 100.5})
+	var wErr *models.WithdrawalError
+	if !errors.As(err, &wErr) || wErr.Field != "amount" || wErr.Rule != "max" || !errors.Is(err, models.ErrInvalidAmount) {
+		t.Fatalf("error = %v, want the max amount field error", err)
+	}
+}
@@ -0,0 +1,154 @@
+package wallets
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"strconv"
+	"strings"
+)
+
+const (
+	// statementEntries lists every balance movement of user $1. Accruals
+	// come from their point lots, which hold exactly what was credited.
+	// Orders credited before lots existed have none, their points sit in
+	// the legacy lot, so those fall back to the order's credited amount. A
+	// captured hold shows as the hold, its full release and the withdrawal
+	// of the captured sum, so the net effect matches the wallet.
+	statementEntries = `
+						WITH entries AS (
+							SELECT earned_at AS at, 'accrual' AS kind, order_number AS reference, amount::float8 AS amount, id AS ref_id 
+							FROM point_lots 
+							WHERE user_id = $1 AND source = 'accrual' 
+							UNION ALL 
+							SELECT COALESCE(o.credited_at, o.created_at), 'accrual', o.order_number, o.credited_amount::float8, o.id 
+							FROM orders o 
+							WHERE o.user_id = $1 AND o.status = 'PROCESSED' AND o.credited_amount IS NOT NULL 
+								AND NOT EXISTS (
+									SELECT 1 FROM point_lots p 
+									WHERE p.user_id = $1 AND p.order_number = o.order_number AND p.source = 'accrual'
+								) 
+							UNION ALL 
+							SELECT created_at, 'withdrawal', order_number, -COALESCE(amount, 0)::float8, id 
+							FROM withdrawals 
+							WHERE user_id = $1 
+							UNION ALL 
+							SELECT COALESCE(updated_at, created_at), 'refund', order_number, COALESCE(amount, 0)::float8, id 
+							FROM withdrawals 
+							WHERE user_id = $1 AND status IN ('FAILED', 'REVERSED') 
+							UNION ALL 
+							SELECT created_at, 'adjustment', reason, amount::float8, id 
+							FROM balance_adjustments 
+							WHERE user_id = $1 
+							UNION ALL 
+							SELECT r.created_at, 'campaign', c.name, r.amount::float8, r.id 
+							FROM campaign_redemptions r 
+							JOIN campaigns c ON c.id = r.campaign_id 
+							WHERE r.user_id = $1 
+							UNION ALL 
+							SELECT r.rewarded_at, 'referral', u.login, 
+								(CASE WHEN r.referrer_id = $1 THEN r.referrer_bonus ELSE r.referee_bonus END)::float8, r.id 
+							FROM referrals r 
+							JOIN users u ON u.id = CASE WHEN r.referrer_id = $1 THEN r.referee_id ELSE r.referrer_id END 
+							WHERE (r.referrer_id = $1 OR r.referee_id = $1) AND r.status = 'rewarded' 
+							UNION ALL 
+							SELECT t.created_at, 'transfer', u.login, 
+								(CASE WHEN t.sender_id = $1 THEN -t.amount ELSE t.amount END)::float8, t.id 
+							FROM transfers t 
+							JOIN users u ON u.id = CASE WHEN t.sender_id = $1 THEN t.recipient_id ELSE t.sender_id END 
+							WHERE t.sender_id = $1 OR t.recipient_id = $1 
+							UNION ALL 
+							SELECT created_at, 'hold', COALESCE(order_number, ''), -amount::float8, id 
+							FROM balance_holds 
+							WHERE user_id = $1 
+							UNION ALL 
+							SELECT settled_at, 'hold_release', COALESCE(order_number, ''), amount::float8, id 
+							FROM balance_holds 
+							WHERE user_id = $1 AND settled_at IS NOT NULL 
+						), 
+						ledger AS (
+							SELECT at, kind, reference, amount, 
+								ROW_NUMBER() OVER (ORDER BY at, kind, ref_id) AS seq, 
+								SUM(amount) OVER (ORDER BY at, kind, ref_id ROWS UNBOUNDED PRECEDING) AS balance 
+							FROM entries 
+							WHERE amount <> 0 
+						)`
+	StatementBalancesQuery = statementEntries + `
+						SELECT COALESCE(SUM(amount) FILTER (WHERE at < $2::timestamp), 0), 
+							COALESCE(SUM(amount) FILTER (WHERE $3::timestamp IS NULL OR at < $3), 0) 
+						FROM ledger;`
+	StatementEntriesBase = statementEntries + `
+						SELECT seq, at, kind, COALESCE(reference, ''), amount, balance 
+						FROM ledger 
+						WHERE TRUE`
+)
+
+// GetStatementBalances returns the balance before from and before to. A
+// zero from opens at nothing, a zero to closes at the latest entry.
+func (w *DBWallets) GetStatementBalances(ctx context.Context, UID int, filter models.StatementFilter) (opening float32, closing float32, err error) {
+	var from, to sql.NullTime
+	if !filter.From.IsZero() {
+		from = sql.NullTime{Time: filter.From, Valid: true}
+	}
+	if !filter.To.IsZero() {
+		to = sql.NullTime{Time: filter.To, Valid: true}
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	var openingSum, closingSum float64
+	err = w.db.QueryRowContext(ctx, StatementBalancesQuery, UID, from, to).Scan(&openingSum, &closingSum)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get statement balances: %w", err)
+	}
+	return float32(openingSum), float32(closingSum), nil
+}
+
+// FindStatementEntries pages on (at, seq) in chronological order and
+// returns at most filter.Limit+1 rows, the extra one signals a next page.
+func (w *DBWallets) FindStatementEntries(ctx context.Context, UID int, filter models.StatementFilter) ([]models.StatementEntry, error) {
+	var query strings.Builder
+	query.WriteString(StatementEntriesBase)
+	args := []any{UID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if !filter.From.IsZero() {
+		query.WriteString(" AND at >= " + arg(filter.From))
+	}
+	if !filter.To.IsZero() {
+		query.WriteString(" AND at < " + arg(filter.To))
+	}
+	if filter.Cursor != nil {
+		query.WriteString(fmt.Sprintf(" AND (at, seq) > (%s, %s)", arg(filter.Cursor.CreatedAt), arg(filter.Cursor.ID)))
+	}
+	query.WriteString(" ORDER BY seq")
+	if filter.Limit > 0 {
+		query.WriteString(" LIMIT " + arg(filter.Limit+1))
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	rows, err := w.db.QueryContext(ctx, query.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query statement: %v", err)
+	}
+	defer rows.Close()
+	entries := make([]models.StatementEntry, 0)
+	for rows.Next() {
+		var entry models.StatementEntry
+		var amount, balance float64
+		if err := rows.Scan(&entry.Seq, &entry.At, &entry.Kind, &entry.Reference, &amount, &balance); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		entry.Amount, entry.Balance = float32(amount), float32(balance)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %v", err)
+	}
+	return entries, nil
+}
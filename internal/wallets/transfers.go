@@ -0,0 +1,205 @@
+package wallets
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+)
+
+const (
+	GetRecipientQuery = `SELECT id FROM users WHERE login = $1 AND deleted_at IS NULL;`
+	LockWalletsQuery  = `SELECT user_id FROM wallets WHERE user_id = ANY($1) ORDER BY user_id FOR UPDATE;`
+	GetTransferByKey  = `
+						SELECT id, recipient_id, amount, created_at 
+						FROM transfers 
+						WHERE sender_id = $1 AND idempotency_key = $2;`
+	SentTransfersQuery = `
+						SELECT COUNT(*), COALESCE(SUM(amount), 0) 
+						FROM transfers 
+						WHERE sender_id = $1 AND created_at >= $2;`
+	InsertTransferQuery = `
+						INSERT INTO transfers (sender_id, recipient_id, amount, idempotency_key, created_at) 
+						VALUES ($1, $2, $3, $4, $5) RETURNING id;`
+	GetTransfersQuery = `
+						SELECT t.id, t.sender_id, t.recipient_id, u.login, t.amount, t.idempotency_key, t.created_at 
+						FROM transfers t 
+						JOIN users u ON u.id = CASE WHEN t.sender_id = $1 THEN t.recipient_id ELSE t.sender_id END 
+						WHERE t.sender_id = $1 OR t.recipient_id = $1 
+						ORDER BY t.created_at DESC, t.id DESC;`
+
+	// lotRoundingSlack is how far the sender's lots may fall short of a
+	// transfer through REAL rounding alone.
+	lotRoundingSlack = 0.005
+)
+
+// Transfer moves points between two wallets. Both wallets are locked in
+// user_id order, so two opposite transfers can not deadlock. A transfer
+// already stored under the same idempotency key is returned as is, with
+// replayed set. The recipient is looked up by transfer.Counterparty.
+func (w *DBWallets) Transfer(ctx context.Context, transfer models.Transfer, limit models.TransferLimit) (stored models.Transfer, replayed bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Transfer{}, false, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, GetRecipientQuery, transfer.Counterparty).Scan(&transfer.RecipientID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Transfer{}, false, models.ErrRecipientNotFound
+		}
+		return models.Transfer{}, false, fmt.Errorf("failed to find recipient: %w", err)
+	}
+	if transfer.RecipientID == transfer.SenderID {
+		return models.Transfer{}, false, models.ErrSelfTransfer
+	}
+
+	rows, err := tx.QueryContext(ctx, LockWalletsQuery, []int{transfer.SenderID, transfer.RecipientID})
+	if err != nil {
+		return models.Transfer{}, false, fmt.Errorf("failed to lock wallets: %w", err)
+	}
+	locked := 0
+	for rows.Next() {
+		locked++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return models.Transfer{}, false, fmt.Errorf("error during row iteration: %v", err)
+	}
+	if locked != 2 {
+		return models.Transfer{}, false, models.ErrRecipientNotFound
+	}
+
+	// Looked up under the wallet lock, so a retry racing the original
+	// waits for it and then sees its row.
+	var previous models.Transfer
+	err = tx.QueryRowContext(ctx, GetTransferByKey, transfer.SenderID, transfer.IdempotencyKey).Scan(
+		&previous.ID,
+		&previous.RecipientID,
+		&previous.Amount,
+		&previous.CreatedAt,
+	)
+	switch {
+	case err == nil:
+		if previous.RecipientID != transfer.RecipientID || previous.Amount != transfer.Amount {
+			return models.Transfer{}, false, models.ErrIdempotencyConflict
+		}
+		transfer.ID, transfer.CreatedAt = previous.ID, previous.CreatedAt
+		return transfer, true, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return models.Transfer{}, false, fmt.Errorf("failed to check idempotency key: %w", err)
+	}
+
+	if limit.Amount > 0 || limit.Count > 0 {
+		var count int
+		var sent float32
+		err = tx.QueryRowContext(ctx, SentTransfersQuery, transfer.SenderID, limit.Since).Scan(&count, &sent)
+		if err != nil {
+			return models.Transfer{}, false, fmt.Errorf("failed to sum sent transfers: %w", err)
+		}
+		if (limit.Count > 0 && count >= limit.Count) || (limit.Amount > 0 && sent+transfer.Amount > limit.Amount) {
+			return models.Transfer{}, false, models.ErrTransferLimit
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, HoldWithdrawBalance, transfer.Amount, transfer.SenderID)
+	if err != nil {
+		return models.Transfer{}, false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return models.Transfer{}, false, err
+	}
+	if affected == 0 {
+		return models.Transfer{}, false, models.ErrNotEnoughBonuses
+	}
+	// Sent points keep the expiry of the lots they came from, so passing
+	// them around does not extend their life. The recipient gets a lot per
+	// spent lot. Lots past their expiry still count in the balance until
+	// the sweep, so a shortfall beyond rounding means the sender is paying
+	// with expired points and the transfer is refused.
+	usages, err := consumeLots(ctx, tx, transfer.SenderID, transfer.Amount, 0)
+	if err != nil {
+		return models.Transfer{}, false, err
+	}
+	left := transfer.Amount
+	for _, u := range usages {
+		left -= u.amount
+	}
+	if left > lotRoundingSlack {
+		return models.Transfer{}, false, models.ErrNotEnoughBonuses
+	}
+	_, err = tx.ExecContext(ctx, AccrualUpdateBalance, transfer.Amount, transfer.RecipientID)
+	if err != nil {
+		return models.Transfer{}, false, err
+	}
+	for _, u := range usages {
+		err = insertLot(ctx, tx, models.PointLot{
+			UserID:    transfer.RecipientID,
+			Source:    models.LotSourceTransfer,
+			Amount:    u.amount,
+			EarnedAt:  transfer.CreatedAt,
+			ExpiresAt: u.expiresAt,
+		})
+		if err != nil {
+			return models.Transfer{}, false, err
+		}
+	}
+	// Only a rounding remainder is left here, it never expires.
+	err = insertLot(ctx, tx, models.PointLot{
+		UserID:   transfer.RecipientID,
+		Source:   models.LotSourceTransfer,
+		Amount:   left,
+		EarnedAt: transfer.CreatedAt,
+	})
+	if err != nil {
+		return models.Transfer{}, false, err
+	}
+	err = tx.QueryRowContext(
+		ctx, InsertTransferQuery,
+		transfer.SenderID,
+		transfer.RecipientID,
+		transfer.Amount,
+		transfer.IdempotencyKey,
+		transfer.CreatedAt,
+	).Scan(&transfer.ID)
+	if err != nil {
+		return models.Transfer{}, false, fmt.Errorf("failed to record transfer: %w", err)
+	}
+	return transfer, false, tx.Commit()
+}
+
+// GetTransfers lists the transfers sent and received by the user, newest
+// first.
+func (w *DBWallets) GetTransfers(ctx context.Context, UID int) ([]models.Transfer, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	rows, err := w.db.QueryContext(ctx, GetTransfersQuery, UID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transfers: %v", err)
+	}
+	defer rows.Close()
+	transfers := make([]models.Transfer, 0)
+	for rows.Next() {
+		var t models.Transfer
+		err = rows.Scan(&t.ID, &t.SenderID, &t.RecipientID, &t.Counterparty, &t.Amount, &t.IdempotencyKey, &t.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		t.Direction = models.TransferDirectionOut
+		if t.SenderID != UID {
+			t.Direction = models.TransferDirectionIn
+			t.IdempotencyKey = ""
+		}
+		transfers = append(transfers, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %v", err)
+	}
+	return transfers, nil
+}
@@ -0,0 +1,195 @@
+package wallets
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/Fuonder/goptherstore.git/internal/testdb"
+	"sync"
+	"testing"
+	"time"
+)
+
+func idempotencyKey(t *testing.T) string {
+	t.Helper()
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(raw)
+}
+
+func send(w *DBWallets, sender int, recipient string, amount float32, key string, limit models.TransferLimit) (models.Transfer, bool, error) {
+	return w.Transfer(context.Background(), models.Transfer{
+		SenderID:       sender,
+		Counterparty:   recipient,
+		Amount:         amount,
+		IdempotencyKey: key,
+		CreatedAt:      time.Now(),
+	}, limit)
+}
+
+func TestDBTransferKeepsLotExpiry(t *testing.T) {
+	w, db := newTestDBWallets(t)
+	sender, _ := testdb.CreateUser(t, db)
+	recipient, login := testdb.CreateUser(t, db)
+	now := time.Now().UTC()
+	soon, later := now.Add(10*24*time.Hour), now.Add(20*24*time.Hour)
+	credit(t, w, sender, 30, now.Add(-3*time.Hour), soon)
+	credit(t, w, sender, 50, now.Add(-2*time.Hour), later)
+	credit(t, w, sender, 20, now.Add(-time.Hour), time.Time{})
+
+	if _, _, err := send(w, sender, login, 70, idempotencyKey(t), models.TransferLimit{}); err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+	checkWallet(t, w, sender, 30, 0)
+	checkWallet(t, w, recipient, 70, 0)
+
+	lots := userLots(t, db, recipient)
+	want := []struct {
+		remaining float32
+		expiresAt time.Time
+	}{{30, soon}, {40, later}}
+	if len(lots) != len(want) {
+		t.Fatalf("recipient has %d lots, want %d", len(lots), len(want))
+	}
+	for i, lot := range lots {
+		if lot.source != models.LotSourceTransfer || lot.remaining != want[i].remaining {
+			t.Errorf("lot %d = %s %.2f, want transfer %.2f", i, lot.source, lot.remaining, want[i].remaining)
+		}
+		if !lot.expiresAt.Valid || lot.expiresAt.Time.Sub(want[i].expiresAt).Abs() > time.Second {
+			t.Errorf("lot %d expires at %v, want %v", i, lot.expiresAt, want[i].expiresAt)
+		}
+	}
+	if got := lotsRemaining(t, db, sender); got != 30 {
+		t.Errorf("sender lots = %.2f, want 30", got)
+	}
+}
+
+func TestDBOppositeTransfersDoNotDeadlock(t *testing.T) {
+	w, db := newTestDBWallets(t)
+	first, firstLogin := testdb.CreateUser(t, db)
+	second, secondLogin := testdb.CreateUser(t, db)
+	credit(t, w, first, 100, time.Now(), time.Time{})
+	credit(t, w, second, 100, time.Now(), time.Time{})
+
+	type direction struct {
+		sender    int
+		recipient string
+	}
+	const rounds = 10
+	errs := make(chan error, 2*rounds)
+	var wg sync.WaitGroup
+	for range rounds {
+		for _, d := range []direction{{first, secondLogin}, {second, firstLogin}} {
+			key := idempotencyKey(t)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// Separate locks, only the database orders the replicas.
+				replica := &DBWallets{db: db, mu: &sync.RWMutex{}}
+				_, _, err := send(replica, d.sender, d.recipient, 1, key, models.TransferLimit{})
+				errs <- err
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Transfer: %v", err)
+		}
+	}
+	checkWallet(t, w, first, 100, 0)
+	checkWallet(t, w, second, 100, 0)
+}
+
+func TestDBTransferIdempotency(t *testing.T) {
+	w, db := newTestDBWallets(t)
+	sender, _ := testdb.CreateUser(t, db)
+	recipient, login := testdb.CreateUser(t, db)
+	credit(t, w, sender, 100, time.Now(), time.Time{})
+	key := idempotencyKey(t)
+
+	original, replayed, err := send(w, sender, login, 25, key, models.TransferLimit{})
+	if err != nil || replayed {
+		t.Fatalf("Transfer: replayed %v, error %v", replayed, err)
+	}
+	again, replayed, err := send(w, sender, login, 25, key, models.TransferLimit{})
+	if err != nil || !replayed {
+		t.Fatalf("replay: replayed %v, error %v", replayed, err)
+	}
+	if again.ID != original.ID || !again.CreatedAt.Equal(original.CreatedAt) {
+		t.Errorf("replay returned %+v, want %+v", again, original)
+	}
+	if _, _, err = send(w, sender, login, 30, key, models.TransferLimit{}); !errors.Is(err, models.ErrIdempotencyConflict) {
+		t.Errorf("conflicting replay: error = %v, want ErrIdempotencyConflict", err)
+	}
+	checkWallet(t, w, sender, 75, 0)
+	checkWallet(t, w, recipient, 25, 0)
+}
+
+func TestDBTransferLimits(t *testing.T) {
+	w, db := newTestDBWallets(t)
+	sender, _ := testdb.CreateUser(t, db)
+	_, login := testdb.CreateUser(t, db)
+	credit(t, w, sender, 100, time.Now(), time.Time{})
+	limit := models.TransferLimit{Since: time.Now().Add(-time.Hour), Amount: 50, Count: 2}
+
+	if _, _, err := send(w, sender, login, 30, idempotencyKey(t), limit); err != nil {
+		t.Fatalf("first transfer: %v", err)
+	}
+	if _, _, err := send(w, sender, login, 30, idempotencyKey(t), limit); !errors.Is(err, models.ErrTransferLimit) {
+		t.Errorf("over the amount: error = %v, want ErrTransferLimit", err)
+	}
+	if _, _, err := send(w, sender, login, 20, idempotencyKey(t), limit); err != nil {
+		t.Fatalf("second transfer: %v", err)
+	}
+	if _, _, err := send(w, sender, login, 1, idempotencyKey(t), models.TransferLimit{Since: limit.Since, Count: 2}); !errors.Is(err, models.ErrTransferLimit) {
+		t.Errorf("over the count: error = %v, want ErrTransferLimit", err)
+	}
+	checkWallet(t, w, sender, 50, 0)
+}
+
+func TestDBTransferNotEnoughBonuses(t *testing.T) {
+	w, db := newTestDBWallets(t)
+	sender, _ := testdb.CreateUser(t, db)
+	recipient, login := testdb.CreateUser(t, db)
+	credit(t, w, sender, 10, time.Now(), time.Time{})
+
+	if _, _, err := send(w, sender, login, 10.5, idempotencyKey(t), models.TransferLimit{}); !errors.Is(err, models.ErrNotEnoughBonuses) {
+		t.Fatalf("overdraft: error = %v, want ErrNotEnoughBonuses", err)
+	}
+	checkWallet(t, w, sender, 10, 0)
+	checkWallet(t, w, recipient, 0, 0)
+	if got := lotsRemaining(t, db, sender); got != 10 {
+		t.Errorf("sender lots = %.2f, want 10", got)
+	}
+}
+
+func TestDBTransferRefusesExpiredLots(t *testing.T) {
+	w, db := newTestDBWallets(t)
+	sender, _ := testdb.CreateUser(t, db)
+	recipient, login := testdb.CreateUser(t, db)
+	now := time.Now().UTC()
+	// Expired but not swept yet, so the balance still counts it.
+	credit(t, w, sender, 30, now.Add(-48*time.Hour), now.Add(-time.Hour))
+	credit(t, w, sender, 20, now.Add(-time.Hour), time.Time{})
+
+	if _, _, err := send(w, sender, login, 40, idempotencyKey(t), models.TransferLimit{}); !errors.Is(err, models.ErrNotEnoughBonuses) {
+		t.Fatalf("expired lots: error = %v, want ErrNotEnoughBonuses", err)
+	}
+	checkWallet(t, w, sender, 50, 0)
+	checkWallet(t, w, recipient, 0, 0)
+	if lots := userLots(t, db, recipient); len(lots) != 0 {
+		t.Errorf("recipient has %d lots, want none", len(lots))
+	}
+
+	// The live lot alone still covers a smaller transfer.
+	if _, _, err := send(w, sender, login, 20, idempotencyKey(t), models.TransferLimit{}); err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+	checkWallet(t, w, recipient, 20, 0)
+}
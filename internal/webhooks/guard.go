@@ -0,0 +1,80 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+var errForbiddenDestination = errors.New("webhook destination is not a public address")
+
+// isPublicIP tells whether deliveries may be sent to ip. Loopback, private,
+// link-local (cloud metadata lives there), multicast and unspecified
+// addresses are all internal to us.
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		ip.IsUnspecified())
+}
+
+// checkHost resolves host and refuses it unless every address is public,
+// a name pointing at both kinds is as dangerous as a private one.
+func (s *WHService) checkHost(ctx context.Context, host string) error {
+	if s.allowPrivate {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if !isPublicIP(ip) {
+			return fmt.Errorf("%w: %w", models.ErrInvalidWebhook, errForbiddenDestination)
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("%w: url host can not be resolved", models.ErrInvalidWebhook)
+	}
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return fmt.Errorf("%w: %w", models.ErrInvalidWebhook, errForbiddenDestination)
+		}
+	}
+	return nil
+}
+
+// newTransport checks the address actually dialed, so a name that was
+// public at registration and resolves elsewhere later is still refused.
+func (s *WHService) newTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: s.cfg.Timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if s.allowPrivate {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublicIP(ip) {
+				return errForbiddenDestination
+			}
+			return nil
+		},
+	}
+	return &http.Transport{
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
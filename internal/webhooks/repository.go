@@ -0,0 +1,364 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	InsertWebhookQuery = `
+						INSERT INTO webhooks (user_id, url, event_types, secret, created_at) 
+						VALUES ($1, $2, $3, $4, $5) 
+						RETURNING id;`
+	GetWebhooksByOwner = `
+						SELECT id, COALESCE(user_id, 0), url, event_types, secret, created_at 
+						FROM webhooks 
+						WHERE user_id IS NOT DISTINCT FROM $1 
+						ORDER BY id;`
+	GetWebhookByID = `
+						SELECT id, COALESCE(user_id, 0), url, event_types, secret, created_at 
+						FROM webhooks 
+						WHERE id = $1;`
+	GetSubscribedWebhooks = `
+						SELECT id, COALESCE(user_id, 0), url, event_types, secret, created_at 
+						FROM webhooks 
+						WHERE user_id = $1 OR user_id IS NULL;`
+	DeleteWebhookQuery = `DELETE FROM webhooks WHERE id = $1 AND user_id IS NOT DISTINCT FROM $2;`
+
+	InsertDeliveryQuery = `
+						INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, status, next_attempt_at, created_at) 
+						VALUES ($1, $2, $3, $4, $5, $6, $7) 
+						RETURNING id;`
+	// Claiming moves next_attempt_at forward by the lease, so a delivery
+	// stuck on a dead replica is picked up again once the lease runs out.
+	ClaimDeliveriesQuery = `
+						UPDATE webhook_deliveries SET next_attempt_at = $2 
+						WHERE id IN (
+							SELECT id FROM webhook_deliveries 
+							WHERE status = 'pending' AND next_attempt_at <= $1 
+							ORDER BY next_attempt_at 
+							LIMIT $3 
+							FOR UPDATE SKIP LOCKED
+						) 
+						RETURNING id, webhook_id, event_id, event_type, payload, status, attempts, created_at;`
+	UpdateDeliveryQuery = `
+						UPDATE webhook_deliveries 
+						SET status = $1, attempts = $2, next_attempt_at = $3, last_status_code = $4, last_error = $5, delivered_at = $6 
+						WHERE id = $7;`
+	InsertAttemptQuery = `
+						INSERT INTO webhook_attempts (delivery_id, status_code, error, duration_ms, created_at) 
+						VALUES ($1, $2, $3, $4, $5);`
+	GetDeliveriesByWebhook = `
+						SELECT id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at, 
+						       COALESCE(last_status_code, 0), COALESCE(last_error, ''), created_at, delivered_at 
+						FROM webhook_deliveries 
+						WHERE webhook_id = $1 
+						ORDER BY id DESC 
+						LIMIT $2;`
+	GetDeliveryByID = `
+						SELECT id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at, 
+						       COALESCE(last_status_code, 0), COALESCE(last_error, ''), created_at, delivered_at 
+						FROM webhook_deliveries 
+						WHERE id = $1 AND webhook_id = $2;`
+	GetAttemptsByDelivery = `
+						SELECT COALESCE(status_code, 0), COALESCE(error, ''), duration_ms, created_at 
+						FROM webhook_attempts 
+						WHERE delivery_id = $1 
+						ORDER BY id;`
+)
+
+type DatabaseWebhooks interface {
+	CreateWebhook(ctx context.Context, hook models.Webhook) (int, error)
+	GetWebhooks(ctx context.Context, owner int) ([]models.Webhook, error)
+	GetWebhook(ctx context.Context, ID int) (models.Webhook, error)
+	GetSubscribedWebhooks(ctx context.Context, UID int) ([]models.Webhook, error)
+	DeleteWebhook(ctx context.Context, owner int, ID int) error
+	EnqueueDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) ([]int, error)
+	ClaimDeliveries(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]models.WebhookDelivery, error)
+	RecordAttempt(ctx context.Context, delivery models.WebhookDelivery, attempt models.WebhookAttempt) error
+	GetDeliveries(ctx context.Context, webhookID int, limit int) ([]models.WebhookDelivery, error)
+	GetDelivery(ctx context.Context, webhookID int, ID int) (models.WebhookDelivery, error)
+}
+
+type DBWebhooks struct {
+	db *sql.DB
+	mu *sync.RWMutex
+}
+
+func NewDBWebhooks(db *sql.DB, mu *sync.RWMutex) (*DBWebhooks, error) {
+	return &DBWebhooks{db: db, mu: mu}, nil
+}
+
+// ownerArg maps the global owner 0 to NULL.
+func ownerArg(owner int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(owner), Valid: owner != 0}
+}
+
+func (w *DBWebhooks) CreateWebhook(ctx context.Context, hook models.Webhook) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var ID int
+	err := w.db.QueryRowContext(
+		ctx, InsertWebhookQuery,
+		ownerArg(hook.UserID),
+		hook.URL,
+		strings.Join(hook.EventTypes, ","),
+		hook.Secret,
+		hook.CreatedAt,
+	).Scan(&ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return ID, nil
+}
+
+func (w *DBWebhooks) GetWebhooks(ctx context.Context, owner int) ([]models.Webhook, error) {
+	return w.queryWebhooks(ctx, GetWebhooksByOwner, ownerArg(owner))
+}
+
+func (w *DBWebhooks) GetSubscribedWebhooks(ctx context.Context, UID int) ([]models.Webhook, error) {
+	return w.queryWebhooks(ctx, GetSubscribedWebhooks, UID)
+}
+
+func (w *DBWebhooks) queryWebhooks(ctx context.Context, query string, arg any) ([]models.Webhook, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	rows, err := w.db.QueryContext(ctx, query, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhooks: %v", err)
+	}
+	defer rows.Close()
+	hooks := make([]models.Webhook, 0)
+
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %v", err)
+	}
+	return hooks, nil
+}
+
+func (w *DBWebhooks) GetWebhook(ctx context.Context, ID int) (models.Webhook, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	hook, err := scanWebhook(w.db.QueryRowContext(ctx, GetWebhookByID, ID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Webhook{}, models.ErrWebhookNotFound
+		}
+		return models.Webhook{}, err
+	}
+	return hook, nil
+}
+
+func (w *DBWebhooks) DeleteWebhook(ctx context.Context, owner int, ID int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	res, err := w.db.ExecContext(ctx, DeleteWebhookQuery, ID, ownerArg(owner))
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return models.ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (w *DBWebhooks) EnqueueDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) ([]int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	IDs := make([]int, 0, len(deliveries))
+	for _, d := range deliveries {
+		var ID int
+		err = tx.QueryRowContext(
+			ctx, InsertDeliveryQuery,
+			d.WebhookID,
+			d.EventID,
+			d.EventType,
+			string(d.Payload),
+			d.Status,
+			d.NextAttemptAt,
+			d.CreatedAt,
+		).Scan(&ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to enqueue delivery: %w", err)
+		}
+		IDs = append(IDs, ID)
+	}
+	return IDs, tx.Commit()
+}
+
+func (w *DBWebhooks) ClaimDeliveries(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]models.WebhookDelivery, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	rows, err := w.db.QueryContext(ctx, ClaimDeliveriesQuery, now, leaseUntil, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim deliveries: %v", err)
+	}
+	defer rows.Close()
+	deliveries := make([]models.WebhookDelivery, 0)
+
+	for rows.Next() {
+		var d models.WebhookDelivery
+		var payload string
+		err = rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &payload, &d.Status, &d.Attempts, &d.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		d.Payload = []byte(payload)
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %v", err)
+	}
+	return deliveries, nil
+}
+
+func (w *DBWebhooks) RecordAttempt(ctx context.Context, d models.WebhookDelivery, attempt models.WebhookAttempt) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx, InsertAttemptQuery,
+		d.ID,
+		sql.NullInt64{Int64: int64(attempt.StatusCode), Valid: attempt.StatusCode != 0},
+		sql.NullString{String: attempt.Error, Valid: attempt.Error != ""},
+		attempt.DurationMS,
+		attempt.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record attempt: %w", err)
+	}
+	_, err = tx.ExecContext(
+		ctx, UpdateDeliveryQuery,
+		d.Status,
+		d.Attempts,
+		d.NextAttemptAt,
+		sql.NullInt64{Int64: int64(d.LastStatusCode), Valid: d.LastStatusCode != 0},
+		sql.NullString{String: d.LastError, Valid: d.LastError != ""},
+		d.DeliveredAt,
+		d.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update delivery: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (w *DBWebhooks) GetDeliveries(ctx context.Context, webhookID int, limit int) ([]models.WebhookDelivery, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	rows, err := w.db.QueryContext(ctx, GetDeliveriesByWebhook, webhookID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query deliveries: %v", err)
+	}
+	defer rows.Close()
+	deliveries := make([]models.WebhookDelivery, 0)
+
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %v", err)
+	}
+	return deliveries, nil
+}
+
+func (w *DBWebhooks) GetDelivery(ctx context.Context, webhookID int, ID int) (models.WebhookDelivery, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	d, err := scanDelivery(w.db.QueryRowContext(ctx, GetDeliveryByID, ID, webhookID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.WebhookDelivery{}, models.ErrDeliveryNotFound
+		}
+		return models.WebhookDelivery{}, err
+	}
+
+	rows, err := w.db.QueryContext(ctx, GetAttemptsByDelivery, ID)
+	if err != nil {
+		return models.WebhookDelivery{}, fmt.Errorf("failed to query attempts: %v", err)
+	}
+	defer rows.Close()
+	d.Log = make([]models.WebhookAttempt, 0)
+	for rows.Next() {
+		var a models.WebhookAttempt
+		if err := rows.Scan(&a.StatusCode, &a.Error, &a.DurationMS, &a.CreatedAt); err != nil {
+			return models.WebhookDelivery{}, fmt.Errorf("failed to scan row: %v", err)
+		}
+		d.Log = append(d.Log, a)
+	}
+	if err := rows.Err(); err != nil {
+		return models.WebhookDelivery{}, fmt.Errorf("error during row iteration: %v", err)
+	}
+	return d, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanWebhook(row rowScanner) (models.Webhook, error) {
+	var hook models.Webhook
+	var eventTypes string
+	err := row.Scan(&hook.ID, &hook.UserID, &hook.URL, &eventTypes, &hook.Secret, &hook.CreatedAt)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+	hook.EventTypes = make([]string, 0)
+	if eventTypes != "" {
+		hook.EventTypes = strings.Split(eventTypes, ",")
+	}
+	return hook, nil
+}
+
+func scanDelivery(row rowScanner) (models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	var payload string
+	var next, delivered sql.NullTime
+	err := row.Scan(
+		&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &payload, &d.Status, &d.Attempts, &next,
+		&d.LastStatusCode, &d.LastError, &d.CreatedAt, &delivered,
+	)
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	d.Payload = []byte(payload)
+	if next.Valid && d.Status == models.DeliveryStatusPending {
+		d.NextAttemptAt = &next.Time
+	}
+	if delivered.Valid {
+		d.DeliveredAt = &delivered.Time
+	}
+	return d, nil
+}
@@ -0,0 +1,19 @@
+package webhooks
+
+import (
+	"context"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+)
+
+// WebhookService methods take the owner of the webhook, 0 for global
+// webhooks managed by admins.
+type WebhookService interface {
+	CreateWebhook(ctx context.Context, owner int, req models.WebhookRequest) (models.WebhookCreated, error)
+	GetWebhooks(ctx context.Context, owner int) ([]models.Webhook, error)
+	DeleteWebhook(ctx context.Context, owner int, ID int) error
+	GetDeliveries(ctx context.Context, owner int, webhookID int) ([]models.WebhookDelivery, error)
+	GetDelivery(ctx context.Context, owner int, webhookID int, ID int) (models.WebhookDelivery, error)
+	ReplayDelivery(ctx context.Context, owner int, webhookID int, ID int) (models.WebhookDelivery, error)
+	Publish(ctx context.Context, event models.Event)
+	RunDispatcher(ctx context.Context) error
+}
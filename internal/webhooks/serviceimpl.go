@@ -0,0 +1,324 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/Fuonder/goptherstore.git/internal/logger"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/zap"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	maxWebhooksPerOwner = 10
+	deliveriesPageSize  = 100
+
+	SignatureHeader = "X-Gophermart-Signature"
+	TimestampHeader = "X-Gophermart-Timestamp"
+	EventHeader     = "X-Gophermart-Event"
+	DeliveryHeader  = "X-Gophermart-Delivery"
+)
+
+type Config struct {
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	Timeout      time.Duration
+	PollInterval time.Duration
+	BatchSize    int
+}
+
+type WHService struct {
+	conn   DatabaseWebhooks
+	cfg    Config
+	client *resty.Client
+	// allowPrivate lifts the destination guard, tests deliver to loopback.
+	allowPrivate bool
+}
+
+func NewWHService(conn DatabaseWebhooks, cfg Config) *WHService {
+	s := &WHService{conn: conn, cfg: cfg}
+	s.client = resty.New().
+		SetTransport(s.newTransport()).
+		SetTimeout(cfg.Timeout).
+		SetRedirectPolicy(resty.NoRedirectPolicy())
+	return s
+}
+
+func (s *WHService) CreateWebhook(ctx context.Context, owner int, req models.WebhookRequest) (models.WebhookCreated, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return models.WebhookCreated{}, fmt.Errorf("%w: url must be an absolute http(s) url", models.ErrInvalidWebhook)
+	}
+	if err = s.checkHost(ctx, u.Hostname()); err != nil {
+		return models.WebhookCreated{}, err
+	}
+	for _, eventType := range req.Events {
+		if !slices.Contains(models.KnownEventTypes, eventType) {
+			return models.WebhookCreated{}, fmt.Errorf("%w: unknown event %q", models.ErrInvalidWebhook, eventType)
+		}
+	}
+	existing, err := s.conn.GetWebhooks(ctx, owner)
+	if err != nil {
+		return models.WebhookCreated{}, err
+	}
+	if len(existing) >= maxWebhooksPerOwner {
+		return models.WebhookCreated{}, fmt.Errorf("%w: at most %d webhooks allowed", models.ErrInvalidWebhook, maxWebhooksPerOwner)
+	}
+
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return models.WebhookCreated{}, err
+	}
+	eventTypes := slices.Compact(slices.Sorted(slices.Values(req.Events)))
+	if eventTypes == nil {
+		eventTypes = make([]string, 0)
+	}
+	hook := models.Webhook{
+		UserID:     owner,
+		URL:        u.String(),
+		EventTypes: eventTypes,
+		Secret:     "whsec_" + hex.EncodeToString(raw),
+		CreatedAt:  time.Now(),
+	}
+	hook.ID, err = s.conn.CreateWebhook(ctx, hook)
+	if err != nil {
+		return models.WebhookCreated{}, err
+	}
+	return models.WebhookCreated{Webhook: hook, Secret: hook.Secret}, nil
+}
+
+func (s *WHService) GetWebhooks(ctx context.Context, owner int) ([]models.Webhook, error) {
+	hooks, err := s.conn.GetWebhooks(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	if len(hooks) == 0 {
+		return nil, models.ErrNoData
+	}
+	return hooks, nil
+}
+
+func (s *WHService) DeleteWebhook(ctx context.Context, owner int, ID int) error {
+	return s.conn.DeleteWebhook(ctx, owner, ID)
+}
+
+func (s *WHService) ownedWebhook(ctx context.Context, owner int, ID int) (models.Webhook, error) {
+	hook, err := s.conn.GetWebhook(ctx, ID)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+	if hook.UserID != owner {
+		return models.Webhook{}, models.ErrWebhookNotFound
+	}
+	return hook, nil
+}
+
+func (s *WHService) GetDeliveries(ctx context.Context, owner int, webhookID int) ([]models.WebhookDelivery, error) {
+	_, err := s.ownedWebhook(ctx, owner, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	deliveries, err := s.conn.GetDeliveries(ctx, webhookID, deliveriesPageSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return nil, models.ErrNoData
+	}
+	return deliveries, nil
+}
+
+func (s *WHService) GetDelivery(ctx context.Context, owner int, webhookID int, ID int) (models.WebhookDelivery, error) {
+	_, err := s.ownedWebhook(ctx, owner, webhookID)
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	return s.conn.GetDelivery(ctx, webhookID, ID)
+}
+
+// ReplayDelivery queues the same payload again as a new delivery, the
+// original entry stays in the log untouched.
+func (s *WHService) ReplayDelivery(ctx context.Context, owner int, webhookID int, ID int) (models.WebhookDelivery, error) {
+	original, err := s.GetDelivery(ctx, owner, webhookID, ID)
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	now := time.Now()
+	replay := models.WebhookDelivery{
+		WebhookID:     webhookID,
+		EventID:       original.EventID,
+		EventType:     original.EventType,
+		Payload:       original.Payload,
+		Status:        models.DeliveryStatusPending,
+		NextAttemptAt: &now,
+		CreatedAt:     now,
+	}
+	IDs, err := s.conn.EnqueueDeliveries(ctx, []models.WebhookDelivery{replay})
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	replay.ID = IDs[0]
+	return replay, nil
+}
+
+// Publish queues a delivery for every webhook subscribed to the event.
+// Failures are logged, the operation that raised the event stands.
+func (s *WHService) Publish(ctx context.Context, event models.Event) {
+	hooks, err := s.conn.GetSubscribedWebhooks(ctx, event.UserID)
+	if err != nil {
+		logger.Log.Error("can not load webhooks", zap.Error(err))
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logger.Log.Error("can not marshal webhook payload", zap.Error(err))
+		return
+	}
+
+	now := time.Now()
+	deliveries := make([]models.WebhookDelivery, 0, len(hooks))
+	for _, hook := range hooks {
+		if len(hook.EventTypes) > 0 && !slices.Contains(hook.EventTypes, event.Type) {
+			continue
+		}
+		deliveries = append(deliveries, models.WebhookDelivery{
+			WebhookID:     hook.ID,
+			EventID:       event.ID,
+			EventType:     event.Type,
+			Payload:       payload,
+			Status:        models.DeliveryStatusPending,
+			NextAttemptAt: &now,
+			CreatedAt:     now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+	if _, err = s.conn.EnqueueDeliveries(ctx, deliveries); err != nil {
+		logger.Log.Error("can not enqueue webhook deliveries", zap.String("event", event.ID), zap.Error(err))
+	}
+}
+
+func (s *WHService) RunDispatcher(ctx context.Context) error {
+	ticker := time.NewTicker(s.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			s.dispatchDue(ctx)
+		}
+	}
+}
+
+func (s *WHService) dispatchDue(ctx context.Context) {
+	now := time.Now()
+	// The lease outlives a full attempt so nobody else claims it meanwhile.
+	deliveries, err := s.conn.ClaimDeliveries(ctx, now, now.Add(2*s.cfg.Timeout), s.cfg.BatchSize)
+	if err != nil {
+		logger.Log.Error("can not claim webhook deliveries", zap.Error(err))
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, d := range deliveries {
+		wg.Add(1)
+		go func(d models.WebhookDelivery) {
+			defer wg.Done()
+			s.deliver(ctx, d)
+		}(d)
+	}
+	wg.Wait()
+}
+
+func (s *WHService) deliver(ctx context.Context, d models.WebhookDelivery) {
+	hook, err := s.conn.GetWebhook(ctx, d.WebhookID)
+	if err != nil {
+		logger.Log.Error("can not load webhook", zap.Int("webhook", d.WebhookID), zap.Error(err))
+		return
+	}
+
+	started := time.Now()
+	timestamp := strconv.FormatInt(started.Unix(), 10)
+	resp, err := s.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetHeader(EventHeader, d.EventType).
+		SetHeader(DeliveryHeader, strconv.Itoa(d.ID)).
+		SetHeader(TimestampHeader, timestamp).
+		SetHeader(SignatureHeader, Sign(hook.Secret, timestamp, d.Payload)).
+		SetBody([]byte(d.Payload)).
+		Post(hook.URL)
+
+	attempt := models.WebhookAttempt{
+		DurationMS: time.Since(started).Milliseconds(),
+		CreatedAt:  started,
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	} else {
+		attempt.StatusCode = resp.StatusCode()
+		if !resp.IsSuccess() {
+			attempt.Error = "unexpected status " + resp.Status()
+		}
+	}
+
+	d.Attempts++
+	d.LastStatusCode = attempt.StatusCode
+	d.LastError = attempt.Error
+	switch {
+	case attempt.Error == "":
+		d.Status = models.DeliveryStatusDelivered
+		d.DeliveredAt = &started
+		d.NextAttemptAt = nil
+	case d.Attempts >= s.cfg.MaxAttempts:
+		d.Status = models.DeliveryStatusFailed
+		d.NextAttemptAt = nil
+		logger.Log.Warn("webhook delivery failed permanently", zap.Int("delivery", d.ID), zap.String("error", d.LastError))
+	default:
+		next := time.Now().Add(s.backoff(d.Attempts))
+		d.NextAttemptAt = &next
+	}
+
+	err = s.conn.RecordAttempt(ctx, d, attempt)
+	if err != nil {
+		logger.Log.Error("can not record webhook attempt", zap.Int("delivery", d.ID), zap.Error(err))
+	}
+}
+
+// backoff doubles the base delay with every failed attempt.
+func (s *WHService) backoff(attempts int) time.Duration {
+	delay := s.cfg.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= s.cfg.MaxBackoff {
+			return s.cfg.MaxBackoff
+		}
+	}
+	return delay
+}
+
+// Sign returns the signature header value: HMAC-SHA256 over
+// "<timestamp>.<body>" keyed with the webhook secret.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,359 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"github.com/Fuonder/goptherstore.git/internal/models"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWebhooks keeps everything in memory and remembers recorded attempts.
+type fakeWebhooks struct {
+	mu         sync.Mutex
+	hooks      map[int]models.Webhook
+	deliveries map[int]models.WebhookDelivery
+	enqueued   []models.WebhookDelivery
+	recorded   []models.WebhookDelivery
+	attempts   []models.WebhookAttempt
+}
+
+func newFakeWebhooks(hooks ...models.Webhook) *fakeWebhooks {
+	f := &fakeWebhooks{
+		hooks:      make(map[int]models.Webhook),
+		deliveries: make(map[int]models.WebhookDelivery),
+	}
+	for _, hook := range hooks {
+		f.hooks[hook.ID] = hook
+	}
+	return f
+}
+
+func (f *fakeWebhooks) CreateWebhook(_ context.Context, hook models.Webhook) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	hook.ID = len(f.hooks) + 1
+	f.hooks[hook.ID] = hook
+	return hook.ID, nil
+}
+
+func (f *fakeWebhooks) GetWebhooks(_ context.Context, owner int) ([]models.Webhook, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var hooks []models.Webhook
+	for _, hook := range f.hooks {
+		if hook.UserID == owner {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks, nil
+}
+
+func (f *fakeWebhooks) GetWebhook(_ context.Context, ID int) (models.Webhook, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	hook, ok := f.hooks[ID]
+	if !ok {
+		return models.Webhook{}, models.ErrWebhookNotFound
+	}
+	return hook, nil
+}
+
+func (f *fakeWebhooks) GetSubscribedWebhooks(ctx context.Context, UID int) ([]models.Webhook, error) {
+	return f.GetWebhooks(ctx, UID)
+}
+
+func (f *fakeWebhooks) DeleteWebhook(_ context.Context, _ int, ID int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.hooks, ID)
+	return nil
+}
+
+func (f *fakeWebhooks) EnqueueDeliveries(_ context.Context, deliveries []models.WebhookDelivery) ([]int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	IDs := make([]int, 0, len(deliveries))
+	for _, d := range deliveries {
+		d.ID = len(f.deliveries) + 1
+		f.deliveries[d.ID] = d
+		f.enqueued = append(f.enqueued, d)
+		IDs = append(IDs, d.ID)
+	}
+	return IDs, nil
+}
+
+func (f *fakeWebhooks) ClaimDeliveries(_ context.Context, now time.Time, _ time.Time, limit int) ([]models.WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var due []models.WebhookDelivery
+	for _, d := range f.deliveries {
+		if d.Status == models.DeliveryStatusPending && d.NextAttemptAt != nil && !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+		if len(due) == limit {
+			break
+		}
+	}
+	return due, nil
+}
+
+func (f *fakeWebhooks) RecordAttempt(_ context.Context, d models.WebhookDelivery, attempt models.WebhookAttempt) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deliveries[d.ID] = d
+	f.recorded = append(f.recorded, d)
+	f.attempts = append(f.attempts, attempt)
+	return nil
+}
+
+func (f *fakeWebhooks) GetDeliveries(_ context.Context, webhookID int, _ int) ([]models.WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var deliveries []models.WebhookDelivery
+	for _, d := range f.deliveries {
+		if d.WebhookID == webhookID {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries, nil
+}
+
+func (f *fakeWebhooks) GetDelivery(_ context.Context, webhookID int, ID int) (models.WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d, ok := f.deliveries[ID]
+	if !ok || d.WebhookID != webhookID {
+		return models.WebhookDelivery{}, models.ErrNoData
+	}
+	return d, nil
+}
+
+func (f *fakeWebhooks) lastRecorded(t *testing.T) (models.WebhookDelivery, models.WebhookAttempt) {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.recorded) == 0 {
+		t.Fatal("no attempt recorded")
+	}
+	return f.recorded[len(f.recorded)-1], f.attempts[len(f.attempts)-1]
+}
+
+var testConfig = Config{
+	MaxAttempts:  3,
+	BaseBackoff:  time.Second,
+	MaxBackoff:   5 * time.Second,
+	Timeout:      2 * time.Second,
+	PollInterval: time.Second,
+	BatchSize:    10,
+}
+
+func newTestService(conn DatabaseWebhooks) *WHService {
+	s := NewWHService(conn, testConfig)
+	s.allowPrivate = true
+	return s
+}
+
+func pendingDelivery(ID int, webhookID int) models.WebhookDelivery {
+	now := time.Now()
+	return models.WebhookDelivery{
+		ID:            ID,
+		WebhookID:     webhookID,
+		EventID:       "evt-1",
+		EventType:     models.EventBalanceChanged,
+		Payload:       []byte(`{"id":"evt-1"}`),
+		Status:        models.DeliveryStatusPending,
+		NextAttemptAt: &now,
+		CreatedAt:     now,
+	}
+}
+
+func TestCreateWebhookRejectsInternalDestinations(t *testing.T) {
+	s := NewWHService(newFakeWebhooks(), testConfig)
+	urls := []string{
+		"http://127.0.0.1/hook",
+		"http://localhost:8080/hook",
+		"http://10.0.0.7/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://[fe80::1]/hook",
+		"http://0.0.0.0/hook",
+	}
+	for _, u := range urls {
+		_, err := s.CreateWebhook(context.Background(), 1, models.WebhookRequest{URL: u})
+		if !errors.Is(err, models.ErrInvalidWebhook) {
+			t.Errorf("CreateWebhook(%q) error = %v, want ErrInvalidWebhook", u, err)
+		}
+	}
+
+	created, err := s.CreateWebhook(context.Background(), 1, models.WebhookRequest{URL: "https://93.184.216.34/hook"})
+	if err != nil {
+		t.Fatalf("public destination rejected: %v", err)
+	}
+	if created.Secret == "" {
+		t.Error("secret is not returned on creation")
+	}
+}
+
+func TestDeliverRefusesPrivateAddressAtDial(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer srv.Close()
+
+	// The hook was stored earlier, e.g. its name resolved publicly back then.
+	conn := newFakeWebhooks(models.Webhook{ID: 1, UserID: 1, URL: srv.URL, Secret: "s"})
+	s := NewWHService(conn, testConfig)
+	s.deliver(context.Background(), pendingDelivery(1, 1))
+
+	if hits != 0 {
+		t.Fatalf("receiver was called %d times", hits)
+	}
+	d, attempt := conn.lastRecorded(t)
+	if attempt.Error == "" || d.Status != models.DeliveryStatusPending {
+		t.Errorf("got status %q error %q, want a failed attempt", d.Status, attempt.Error)
+	}
+}
+
+func TestDeliverSignsPayload(t *testing.T) {
+	const secret = "whsec_test"
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	got := make(chan received, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- received{header: r.Header.Clone(), body: body}
+	}))
+	defer srv.Close()
+
+	conn := newFakeWebhooks(models.Webhook{ID: 1, UserID: 1, URL: srv.URL, Secret: secret})
+	conn.deliveries[7] = pendingDelivery(7, 1)
+	s := newTestService(conn)
+	s.dispatchDue(context.Background())
+
+	r := <-got
+	want := Sign(secret, r.header.Get(TimestampHeader), r.body)
+	if sig := r.header.Get(SignatureHeader); sig != want {
+		t.Errorf("signature = %q, want %q", sig, want)
+	}
+	if r.header.Get(EventHeader) != models.EventBalanceChanged || r.header.Get(DeliveryHeader) != "7" {
+		t.Errorf("unexpected headers %v", r.header)
+	}
+	if string(r.body) != `{"id":"evt-1"}` {
+		t.Errorf("body = %s", r.body)
+	}
+	d, attempt := conn.lastRecorded(t)
+	if d.Status != models.DeliveryStatusDelivered || d.DeliveredAt == nil || d.NextAttemptAt != nil {
+		t.Errorf("delivery not marked delivered: %+v", d)
+	}
+	if attempt.StatusCode != http.StatusOK || attempt.Error != "" {
+		t.Errorf("unexpected attempt %+v", attempt)
+	}
+}
+
+func TestDeliverRetriesWithBackoff(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	conn := newFakeWebhooks(models.Webhook{ID: 1, UserID: 1, URL: srv.URL, Secret: "s"})
+	s := newTestService(conn)
+	d := pendingDelivery(1, 1)
+	for attempt := 1; attempt < testConfig.MaxAttempts; attempt++ {
+		before := time.Now()
+		s.deliver(context.Background(), d)
+		d, _ = conn.lastRecorded(t)
+
+		if d.Status != models.DeliveryStatusPending || d.Attempts != attempt {
+			t.Fatalf("attempt %d: status %q attempts %d", attempt, d.Status, d.Attempts)
+		}
+		if d.LastStatusCode != http.StatusInternalServerError || d.LastError == "" {
+			t.Errorf("attempt %d: failure not recorded: %+v", attempt, d)
+		}
+		wait := testConfig.BaseBackoff << (attempt - 1)
+		if d.NextAttemptAt == nil || d.NextAttemptAt.Before(before.Add(wait)) || d.NextAttemptAt.After(time.Now().Add(wait)) {
+			t.Errorf("attempt %d: next attempt %v, want about %v from now", attempt, d.NextAttemptAt, wait)
+		}
+	}
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	s := newTestService(newFakeWebhooks())
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{10, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := s.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestDeliverFailsPermanentlyAfterMaxAttempts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	conn := newFakeWebhooks(models.Webhook{ID: 1, UserID: 1, URL: srv.URL, Secret: "s"})
+	s := newTestService(conn)
+	d := pendingDelivery(1, 1)
+	d.Attempts = testConfig.MaxAttempts - 1
+	conn.deliveries[d.ID] = d
+	s.deliver(context.Background(), d)
+
+	got, _ := conn.lastRecorded(t)
+	if got.Status != models.DeliveryStatusFailed || got.NextAttemptAt != nil || got.Attempts != testConfig.MaxAttempts {
+		t.Fatalf("delivery not failed permanently: %+v", got)
+	}
+
+	// A failed delivery is never claimed again.
+	due, _ := conn.ClaimDeliveries(context.Background(), time.Now().Add(time.Hour), time.Now(), 10)
+	if len(due) != 0 {
+		t.Errorf("failed delivery is still due: %+v", due)
+	}
+}
+
+func TestReplayDelivery(t *testing.T) {
+	conn := newFakeWebhooks(models.Webhook{ID: 1, UserID: 1, URL: "https://example.com", Secret: "s"})
+	original := pendingDelivery(1, 1)
+	original.Status = models.DeliveryStatusFailed
+	original.Attempts = testConfig.MaxAttempts
+	original.NextAttemptAt = nil
+	conn.deliveries[original.ID] = original
+	s := newTestService(conn)
+
+	if _, err := s.ReplayDelivery(context.Background(), 2, 1, original.ID); !errors.Is(err, models.ErrWebhookNotFound) {
+		t.Fatalf("replay by another owner: error = %v, want ErrWebhookNotFound", err)
+	}
+
+	replay, err := s.ReplayDelivery(context.Background(), 1, 1, original.ID)
+	if err != nil {
+		t.Fatalf("ReplayDelivery: %v", err)
+	}
+	if replay.ID == original.ID || replay.Status != models.DeliveryStatusPending || replay.Attempts != 0 || replay.NextAttemptAt == nil {
+		t.Errorf("replay is not a fresh pending delivery: %+v", replay)
+	}
+	if replay.EventID != original.EventID || string(replay.Payload) != string(original.Payload) {
+		t.Errorf("replay payload differs: %+v", replay)
+	}
+	if stored := conn.deliveries[original.ID]; stored.Status != models.DeliveryStatusFailed {
+		t.Errorf("original delivery changed: %+v", stored)
+	}
+}